@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PresignDriver interface to be implemented by storage drivers that can
+// issue URLs granting temporary access to a file, without credentials.
+type PresignDriver interface {
+	Driver
+	// PresignURL returns a URL through which an HTTP request of method
+	// (GET or PUT) can be made for path, valid for expires. Other methods
+	// fail with ErrNotSupported.
+	PresignURL(method, path string, expires time.Duration) (string, error)
+}
+
+// Capability is a set of optional features of a driver, each corresponding
+// to one of the optional interfaces of this package.
+type Capability uint
+
+const (
+	// CapDanger DangerDriver.
+	CapDanger Capability = 1 << iota
+	// CapContext ContextDriver.
+	CapContext
+	// CapStat StatDriver.
+	CapStat
+	// CapPut PutDriver.
+	CapPut
+	// CapCopy CopyDriver.
+	CapCopy
+	// CapList ListDriver.
+	CapList
+	// CapWalk WalkDriver.
+	CapWalk
+	// CapRange RangeDriver.
+	CapRange
+	// CapSeek SeekDriver.
+	CapSeek
+	// CapWriter WriterDriver.
+	CapWriter
+	// CapPresign PresignDriver.
+	CapPresign
+)
+
+var capabilityNames = []string{
+	"danger",
+	"context",
+	"stat",
+	"put",
+	"copy",
+	"list",
+	"walk",
+	"range",
+	"seek",
+	"writer",
+	"presign",
+}
+
+// Has reports whether c includes all of the capabilities of x.
+func (c Capability) Has(x Capability) bool {
+	return c&x == x
+}
+
+// String returns the names of the capabilities in c, separated by "|".
+func (c Capability) String() string {
+	var names []string
+	for i, name := range capabilityNames {
+		if c.Has(1 << uint(i)) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// Capabilities reports the optional interfaces d implements.
+func Capabilities(d Driver) Capability {
+	var c Capability
+	if _, ok := d.(DangerDriver); ok {
+		c |= CapDanger
+	}
+	if _, ok := d.(ContextDriver); ok {
+		c |= CapContext
+	}
+	if _, ok := d.(StatDriver); ok {
+		c |= CapStat
+	}
+	if _, ok := d.(PutDriver); ok {
+		c |= CapPut
+	}
+	if _, ok := d.(CopyDriver); ok {
+		c |= CapCopy
+	}
+	if _, ok := d.(ListDriver); ok {
+		c |= CapList
+	}
+	if _, ok := d.(WalkDriver); ok {
+		c |= CapWalk
+	}
+	if _, ok := d.(RangeDriver); ok {
+		c |= CapRange
+	}
+	if _, ok := d.(SeekDriver); ok {
+		c |= CapSeek
+	}
+	if _, ok := d.(WriterDriver); ok {
+		c |= CapWriter
+	}
+	if _, ok := d.(PresignDriver); ok {
+		c |= CapPresign
+	}
+	return c
+}
+
+// AsDanger returns d as a DangerDriver, if it implements it.
+func AsDanger(d Driver) (DangerDriver, bool) {
+	dd, ok := d.(DangerDriver)
+	return dd, ok
+}
+
+// Presign returns a presigned URL for method on path of d, if it implements
+// PresignDriver, and fails with ErrNotSupported otherwise.
+func Presign(d Driver, method, path string, expires time.Duration) (string, error) {
+	pd, ok := d.(PresignDriver)
+	if !ok {
+		return "", fmt.Errorf("%w: %T cannot presign URLs", ErrNotSupported, d)
+	}
+	return pd.PresignURL(method, path, expires)
+}
@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCapabilities(t *testing.T) {
+	d := &rangeDriver{}
+	c := Capabilities(d)
+	if want := CapStat | CapRange; c != want {
+		t.Errorf("expected %v got %v", want, c)
+	}
+	if !c.Has(CapRange) || c.Has(CapRange|CapList) {
+		t.Errorf("unexpected Has results for %v", c)
+	}
+	if got, want := c.String(), "stat|range"; got != want {
+		t.Errorf("expected %q got %q", want, got)
+	}
+	if got := Capability(0).String(); got != "none" {
+		t.Errorf("expected %q got %q", "none", got)
+	}
+	if _, ok := AsDanger(d); ok {
+		t.Errorf("expected %T not to be a DangerDriver", d)
+	}
+	if _, err := Presign(d, "GET", "a.txt", time.Minute); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("expected %v got %v", ErrNotSupported, err)
+	}
+}
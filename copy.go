@@ -0,0 +1,65 @@
+package storage
+
+import "reflect"
+
+// Copy copies the file on srcPath of src to dstPath of dst. When src and dst
+// are the same driver and it implements CopyDriver, the copy happens within
+// the backend. Otherwise the file is streamed from src.GetFile into
+// dst.AddFile, carrying the content type and metadata over if src implements
+// StatDriver and dst implements PutDriver.
+func Copy(dst Driver, dstPath string, src Driver, srcPath string) error {
+	if cd, ok := dst.(CopyDriver); ok && sameDriver(dst, src) {
+		return cd.Copy(srcPath, dstPath)
+	}
+	return streamCopy(dst, dstPath, src, srcPath)
+}
+
+// Move moves the file on srcPath of src to dstPath of dst. Like Copy, it is
+// done within the backend when possible, and otherwise the file is copied
+// and then removed from src.
+func Move(dst Driver, dstPath string, src Driver, srcPath string) error {
+	if cd, ok := dst.(CopyDriver); ok && sameDriver(dst, src) {
+		return cd.Move(srcPath, dstPath)
+	}
+	if err := streamCopy(dst, dstPath, src, srcPath); err != nil {
+		return err
+	}
+	return src.RemoveFile(srcPath)
+}
+
+func streamCopy(dst Driver, dstPath string, src Driver, srcPath string) error {
+	var opts *PutOptions
+	if sd, ok := src.(StatDriver); ok {
+		fi, err := sd.Stat(srcPath)
+		if err != nil {
+			return err
+		}
+		opts = &PutOptions{
+			ContentType:        fi.ContentType,
+			CacheControl:       fi.CacheControl,
+			ContentDisposition: fi.ContentDisposition,
+			ContentEncoding:    fi.ContentEncoding,
+			Metadata:           fi.Metadata,
+		}
+	}
+
+	rc, err := src.GetFile(srcPath)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if pd, ok := dst.(PutDriver); ok && opts != nil {
+		_, err = pd.AddFileWithOptions(rc, dstPath, opts)
+	} else {
+		_, err = dst.AddFile(rc, dstPath)
+	}
+	return err
+}
+
+// sameDriver reports whether a and b are the same driver instance, without
+// panicking on drivers of non-comparable types.
+func sameDriver(a, b Driver) bool {
+	ta := reflect.TypeOf(a)
+	return ta == reflect.TypeOf(b) && ta.Comparable() && a == b
+}
@@ -3,12 +3,14 @@
 package storage
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net/url"
 	"path/filepath"
 	"sync"
+	"time"
 )
 
 var (
@@ -35,9 +37,11 @@ type Driver interface {
 	NormalizePath(entries ...string) string
 	// AddFile saves the contents of r to path.
 	AddFile(r io.Reader, path string) (string, error)
-	// GetFile returns an io.ReadCloser with the contents of the file.
+	// GetFile returns an io.ReadCloser with the contents of the file. The
+	// error wraps ErrNotFound if there is no file on path.
 	GetFile(path string) (io.ReadCloser, error)
 	// RemoveFile removes the file on path from the driver (if found).
+	// Removing a file that does not exist is not an error.
 	RemoveFile(path string) error
 }
 
@@ -52,9 +56,212 @@ type DangerDriver interface {
 	DeleteContainer() error
 }
 
+// ContextDriver interface to be implemented by storage drivers whose
+// operations can be cancelled or given a deadline. The methods mirror those
+// of Driver; the context-less variants are equivalent to calling these with
+// context.Background().
+type ContextDriver interface {
+	Driver
+	// OpenContext is the context-aware version of Open.
+	OpenContext(ctx context.Context, url string) (Driver, error)
+	// AddFileContext saves the contents of r to path. Drivers must stop
+	// consuming r once ctx is done, and must not leave a partial file behind.
+	AddFileContext(ctx context.Context, r io.Reader, path string) (string, error)
+	// GetFileContext returns an io.ReadCloser with the contents of the file.
+	GetFileContext(ctx context.Context, path string) (io.ReadCloser, error)
+	// RemoveFileContext removes the file on path from the driver (if found).
+	RemoveFileContext(ctx context.Context, path string) error
+}
+
+// StatDriver interface to be implemented by storage drivers that can report
+// file metadata without reading the file contents.
+type StatDriver interface {
+	Driver
+	// Stat returns the FileInfo of the file on path. The error wraps
+	// ErrNotFound if there is no file on path.
+	Stat(path string) (*FileInfo, error)
+	// Exists reports whether there is a file on path.
+	Exists(path string) (bool, error)
+}
+
+// Exists reports whether there is a file on path. It uses the driver's Exists
+// method if it implements StatDriver, and opens the file otherwise.
+func Exists(d Driver, path string) (bool, error) {
+	if sd, ok := d.(StatDriver); ok {
+		return sd.Exists(path)
+	}
+	rc, err := d.GetFile(path)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	rc.Close()
+	return true, nil
+}
+
+// FileInfo describes a stored file.
+type FileInfo struct {
+	// Path of the file, relative to the driver, as passed to GetFile.
+	Path string
+	// Size in bytes.
+	Size int64
+	// ModTime is the last modification (or upload) time.
+	ModTime time.Time
+	// ContentType the file was saved with. Drivers that do not store it
+	// report ResolveContentType(Path).
+	ContentType string
+	// CacheControl, ContentDisposition and ContentEncoding the file was
+	// saved with, if any.
+	CacheControl       string
+	ContentDisposition string
+	ContentEncoding    string
+	// ETag is an opaque identifier of the file contents, without surrounding
+	// quotes. It changes whenever the contents change, but its format is
+	// driver-specific (e.g. an MD5 hash on S3, size and mtime on fs).
+	ETag string
+	// Metadata is the user-defined metadata of the file, with lower-cased
+	// keys. May be nil.
+	Metadata map[string]string
+}
+
+// PutDriver interface to be implemented by storage drivers that accept
+// per-write options.
+type PutDriver interface {
+	Driver
+	// AddFileWithOptions is AddFile, configured through opts. opts may be
+	// nil.
+	AddFileWithOptions(r io.Reader, path string, opts *PutOptions) (string, error)
+}
+
+// CopyDriver interface to be implemented by storage drivers that can copy
+// and move files without downloading them. Use the package-level Copy and
+// Move to copy between any two drivers.
+type CopyDriver interface {
+	Driver
+	// Copy copies the file on src to dst, along with its content type and
+	// metadata. It fails with ErrAlreadyExists if dst holds a file.
+	Copy(src, dst string) error
+	// Move is Copy, followed by the removal of src.
+	Move(src, dst string) error
+}
+
+// ListDriver interface to be implemented by storage drivers that can
+// enumerate the files they hold.
+type ListDriver interface {
+	Driver
+	// List returns a page of the files whose path starts with prefix. The
+	// prefix is matched literally, so "img/" and "img" are not the same. opts
+	// may be nil.
+	List(prefix string, opts *ListOptions) (*ListPage, error)
+}
+
+// WalkDriver interface to be implemented by storage drivers that can walk
+// their files without paginating through List.
+type WalkDriver interface {
+	Driver
+	// Walk calls fn for every file whose path starts with prefix, at any
+	// depth. The order is driver-specific.
+	Walk(prefix string, fn WalkFunc) error
+}
+
+// ListOptions control the results of ListDriver.List.
+type ListOptions struct {
+	// Delimiter, if set, groups the paths that contain it after the prefix
+	// into ListPage.Prefixes, which emulates folders. Typically "/".
+	Delimiter string
+	// MaxResults caps the number of files plus prefixes in a page. Zero
+	// means the driver default, usually 1000.
+	MaxResults int
+	// Token resumes a listing from the NextToken of a previous page.
+	Token string
+}
+
+// ListPage is a page of results from ListDriver.List.
+type ListPage struct {
+	// Files found. ContentType and Metadata may be empty, use
+	// StatDriver.Stat to get them.
+	Files []*FileInfo
+	// Prefixes are the common prefixes ("folders") found when
+	// ListOptions.Delimiter is set. Each ends with the delimiter.
+	Prefixes []string
+	// NextToken is empty on the last page.
+	NextToken string
+}
+
+// WalkFunc is called by Walk for every file found. Returning SkipAll stops
+// the walk without error; any other error stops the walk and is returned by
+// Walk.
+type WalkFunc func(fi *FileInfo) error
+
+// Walk walks the files of d under prefix, calling fn for each. It uses the
+// driver's Walk method if it implements WalkDriver, or pages through List if
+// it implements ListDriver, and returns ErrNotSupported otherwise.
+func Walk(d Driver, prefix string, fn WalkFunc) error {
+	var err error
+	switch drv := d.(type) {
+	case WalkDriver:
+		err = drv.Walk(prefix, fn)
+	case ListDriver:
+		err = walkList(drv, prefix, fn)
+	default:
+		return fmt.Errorf("%w: %T cannot list files", ErrNotSupported, d)
+	}
+	if err == SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walkList(d ListDriver, prefix string, fn WalkFunc) error {
+	opts := &ListOptions{}
+	for {
+		page, err := d.List(prefix, opts)
+		if err != nil {
+			return err
+		}
+		for _, fi := range page.Files {
+			if err := fn(fi); err != nil {
+				return err
+			}
+		}
+		if page.NextToken == "" {
+			return nil
+		}
+		opts.Token = page.NextToken
+	}
+}
+
 // Open checks for a registered driver, and calls the underlying driver
 // Open method.
 func Open(urlString string) (Driver, error) {
+	d, err := lookup(urlString)
+	if err != nil {
+		return nil, err
+	}
+	return d.Open(urlString)
+}
+
+// OpenContext checks for a registered driver, and calls the underlying driver
+// OpenContext method. Drivers that do not implement ContextDriver are opened
+// with Open, after checking that ctx is not done.
+func OpenContext(ctx context.Context, urlString string) (Driver, error) {
+	d, err := lookup(urlString)
+	if err != nil {
+		return nil, err
+	}
+	if cd, ok := d.(ContextDriver); ok {
+		return cd.OpenContext(ctx, urlString)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return d.Open(urlString)
+}
+
+// lookup returns the registered driver for the scheme of urlString.
+func lookup(urlString string) (Driver, error) {
 	u, err := url.Parse(urlString)
 	if err != nil {
 		return nil, err
@@ -70,8 +277,7 @@ func Open(urlString string) (Driver, error) {
 	if !ok {
 		return nil, fmt.Errorf("storage: unknown driver %v (forgotten import)", u.Scheme)
 	}
-
-	return d.Open(urlString)
+	return d, nil
 }
 
 // Register registers a driver on the package. Typically called from driver implementations.
@@ -93,6 +299,16 @@ var (
 	ErrAlreadyExists = errors.New("file already exists")
 	// ErrInvalidExtension invalid extension.
 	ErrInvalidExtension = errors.New("invalid extension")
+	// ErrNotFound file not found.
+	ErrNotFound = errors.New("file not found")
+	// ErrPreconditionFailed the file does not match an IfMatch write mode.
+	ErrPreconditionFailed = errors.New("precondition failed")
+	// ErrNotSupported the driver does not support the operation.
+	ErrNotSupported = errors.New("operation not supported")
+	// ErrReadOnly the driver cannot add nor remove files.
+	ErrReadOnly = errors.New("read-only driver")
+	// SkipAll is returned from a WalkFunc to stop the walk without error.
+	SkipAll = errors.New("skip everything and stop the walk")
 )
 
 // ResolveContentType resolves the content-type based on the extension of path.
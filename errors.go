@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"errors"
+)
+
+// Error records a failed driver operation. The providers in this package
+// return *Error values, which unwrap to the underlying cause, so
+// errors.Is(err, ErrNotFound) works regardless of the driver.
+type Error struct {
+	// Op is the operation that failed, e.g. "add", "get" or "remove".
+	Op string
+	// Driver is the name the driver is registered under, e.g. "fs".
+	Driver string
+	// Path of the file the operation acted on, if any.
+	Path string
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *Error) Error() string {
+	s := "storage: " + e.Driver + ": " + e.Op
+	if e.Path != "" {
+		s += " " + e.Path
+	}
+	if e.Err == nil {
+		return s
+	}
+	return s + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether the underlying error is temporary, e.g. a
+// network error that may go away on its own.
+func (e *Error) Temporary() bool {
+	var t interface{ Temporary() bool }
+	return errors.As(e.Err, &t) && t.Temporary()
+}
+
+// Retryable reports whether retrying the operation unchanged may succeed:
+// the backend asked for a retry (throttling, 5xx responses), or the
+// underlying error is a timeout or temporary. Cancelled operations are never
+// retryable.
+func (e *Error) Retryable() bool {
+	if errors.Is(e.Err, context.Canceled) {
+		return false
+	}
+	var r interface{ Retryable() bool }
+	if errors.As(e.Err, &r) {
+		return r.Retryable()
+	}
+	var t interface{ Timeout() bool }
+	if errors.As(e.Err, &t) && t.Timeout() {
+		return true
+	}
+	return e.Temporary()
+}
+
+// IsRetryable reports whether err, or any error it wraps, is retryable.
+func IsRetryable(err error) bool {
+	var r interface{ Retryable() bool }
+	return errors.As(err, &r) && r.Retryable()
+}
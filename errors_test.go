@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+type retryError bool
+
+func (retryError) Error() string     { return "retry error" }
+func (e retryError) Retryable() bool { return bool(e) }
+
+func TestError(t *testing.T) {
+	for _, tt := range []struct {
+		err       *Error
+		msg       string
+		is        error
+		temporary bool
+		retryable bool
+	}{
+		{
+			&Error{Op: "add", Driver: "fs", Path: "a.txt", Err: ErrAlreadyExists},
+			"storage: fs: add a.txt: file already exists",
+			ErrAlreadyExists, false, false,
+		},
+		{
+			&Error{Op: "add", Driver: "fs", Path: "a.exe", Err: fmt.Errorf("%w .exe", ErrInvalidExtension)},
+			"storage: fs: add a.exe: invalid extension .exe",
+			ErrInvalidExtension, false, false,
+		},
+		{
+			&Error{Op: "open", Driver: "fs", Err: context.Canceled},
+			"storage: fs: open: context canceled",
+			context.Canceled, false, false,
+		},
+		{
+			&Error{Op: "get", Driver: "awss3", Path: "a.txt", Err: &net.OpError{Op: "read", Err: timeoutError{}}},
+			"storage: awss3: get a.txt: read: i/o timeout",
+			nil, true, true,
+		},
+		{
+			&Error{Op: "get", Driver: "awss3", Path: "a.txt", Err: retryError(true)},
+			"storage: awss3: get a.txt: retry error",
+			nil, false, true,
+		},
+		{
+			&Error{Op: "get", Driver: "awss3", Path: "a.txt", Err: retryError(false)},
+			"storage: awss3: get a.txt: retry error",
+			nil, false, false,
+		},
+		{
+			&Error{Op: "remove", Driver: "fs", Path: "a.txt"},
+			"storage: fs: remove a.txt",
+			nil, false, false,
+		},
+	} {
+		t.Run(tt.msg, func(t *testing.T) {
+			if got := tt.err.Error(); got != tt.msg {
+				t.Errorf("expected message %q got %q", tt.msg, got)
+			}
+			if tt.is != nil && !errors.Is(tt.err, tt.is) {
+				t.Errorf("expected error to be %v", tt.is)
+			}
+			if got := tt.err.Temporary(); got != tt.temporary {
+				t.Errorf("expected Temporary() %v got %v", tt.temporary, got)
+			}
+			if got := tt.err.Retryable(); got != tt.retryable {
+				t.Errorf("expected Retryable() %v got %v", tt.retryable, got)
+			}
+			wrapped := fmt.Errorf("wrapped: %w", tt.err)
+			if got := IsRetryable(wrapped); got != tt.retryable {
+				t.Errorf("expected IsRetryable %v got %v", tt.retryable, got)
+			}
+		})
+	}
+}
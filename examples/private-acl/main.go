@@ -11,7 +11,7 @@ import (
 	"strings"
 
 	"github.com/djangulo/go-storage"
-	awss3 "github.com/djangulo/go-storage/providers/aws-s3"
+	_ "github.com/djangulo/go-storage/providers/aws-s3"
 )
 
 var (
@@ -50,10 +50,14 @@ func main() {
 	}
 
 	// graceful shutdown and bucket cleanup
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	cleanup := func() {
-		danger := bobDriver.(*awss3.S3Storage)
+		danger, ok := storage.AsDanger(bobDriver)
+		if !ok {
+			log.Printf("%T cannot delete its container", bobDriver)
+			return
+		}
 		if err := danger.DeleteContainer(); err != nil {
 			log.Println(err)
 		}
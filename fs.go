@@ -0,0 +1,314 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AsFS returns an fs.FS of the files of d, for the consumers of the io/fs
+// package, such as template.ParseFS and http.FS. Files implement io.Seeker
+// if GetFileSeeker supports d, as http.FS needs.
+// If d can list files (ListDriver or WalkDriver), the returned FS also
+// implements fs.ReadDirFS and fs.StatFS, and the directories are those the
+// paths of the files imply, "/" being the separator. Otherwise, only files
+// and the root "." can be opened.
+// Missing files fail with fs.ErrNotExist. Stat reports the size and
+// modification time of the files if d implements StatDriver or can list
+// files, and zero values otherwise.
+func AsFS(d Driver) fs.FS {
+	f := &driverFS{d: d}
+	switch d.(type) {
+	case ListDriver, WalkDriver:
+		return &listFS{f}
+	}
+	return f
+}
+
+// driverFS is the fs.FS of a driver that cannot list files.
+type driverFS struct {
+	d Driver
+}
+
+// listFS is the fs.FS of a driver that can list files.
+type listFS struct {
+	*driverFS
+}
+
+// pathError returns err as the *fs.PathError of op on name, with
+// ErrNotFound turned into fs.ErrNotExist.
+func pathError(op, name string, err error) error {
+	if errors.Is(err, ErrNotFound) {
+		err = fs.ErrNotExist
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+func (f *driverFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, pathError("open", name, fs.ErrInvalid)
+	}
+	if name == "." {
+		return &dir{fsys: f, name: name}, nil
+	}
+	file, err := f.open(name)
+	if errors.Is(err, ErrNotFound) && f.isDir(name) {
+		return &dir{fsys: f, name: name}, nil
+	}
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	return file, nil
+}
+
+// open opens the file on name, seekable if GetFileSeeker supports the
+// driver.
+func (f *driverFS) open(name string) (fs.File, error) {
+	rsc, err := GetFileSeeker(f.d, name)
+	if err == nil {
+		return &seekFile{file{fsys: f, name: name, rc: rsc}, rsc}, nil
+	}
+	if !errors.Is(err, ErrNotSupported) {
+		return nil, err
+	}
+	rc, err := f.d.GetFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return &file{fsys: f, name: name, rc: rc}, nil
+}
+
+// isDir reports whether there are files under name. It is always false if
+// the driver cannot list files.
+func (f *driverFS) isDir(name string) bool {
+	found := false
+	err := Walk(f.d, name+"/", func(*FileInfo) error {
+		found = true
+		return SkipAll
+	})
+	return err == nil && found
+}
+
+// stat returns the FileInfo of the file on name, through Stat or a listing
+// of the driver, or one holding only the path.
+func (f *driverFS) stat(name string) (*FileInfo, error) {
+	if sd, ok := f.d.(StatDriver); ok {
+		return sd.Stat(name)
+	}
+	var fi *FileInfo
+	err := Walk(f.d, name, func(info *FileInfo) error {
+		if info.Path == name {
+			fi = info
+			return SkipAll
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, ErrNotSupported):
+		return &FileInfo{Path: name}, nil
+	case err != nil:
+		return nil, err
+	case fi == nil:
+		return nil, ErrNotFound
+	}
+	return fi, nil
+}
+
+// readDir returns the entries of the directory name, sorted by name.
+func (f *driverFS) readDir(name string) ([]fs.DirEntry, error) {
+	prefix := ""
+	if name != "." {
+		prefix = name + "/"
+	}
+	var (
+		entries []fs.DirEntry
+		seen    = make(map[string]bool)
+	)
+	add := func(rel string, fi *FileInfo) {
+		if i := strings.Index(rel, "/"); i >= 0 {
+			rel = rel[:i]
+			fi = nil
+		}
+		if rel == "" || seen[rel] {
+			return
+		}
+		seen[rel] = true
+		if fi == nil {
+			entries = append(entries, dirInfo(rel))
+		} else {
+			entries = append(entries, &fileInfo{fi})
+		}
+	}
+
+	var err error
+	if ld, ok := f.d.(ListDriver); ok {
+		// only list the files of the directory, and its subdirectories
+		opts := &ListOptions{Delimiter: "/"}
+		for {
+			var page *ListPage
+			if page, err = ld.List(prefix, opts); err != nil {
+				break
+			}
+			for _, fi := range page.Files {
+				add(strings.TrimPrefix(fi.Path, prefix), fi)
+			}
+			for _, p := range page.Prefixes {
+				add(strings.TrimPrefix(p, prefix), nil)
+			}
+			if page.NextToken == "" {
+				break
+			}
+			opts.Token = page.NextToken
+		}
+	} else {
+		err = Walk(f.d, prefix, func(fi *FileInfo) error {
+			add(strings.TrimPrefix(fi.Path, prefix), fi)
+			return nil
+		})
+	}
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+	if len(entries) == 0 && name != "." {
+		return nil, pathError("readdir", name, fs.ErrNotExist)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// ReadDir returns the entries of the directory name, sorted by name.
+func (f *listFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, pathError("readdir", name, fs.ErrInvalid)
+	}
+	return f.readDir(name)
+}
+
+// Stat returns the fs.FileInfo of the file or directory name.
+func (f *listFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, pathError("stat", name, fs.ErrInvalid)
+	}
+	if name == "." {
+		return dirInfo(name), nil
+	}
+	fi, err := f.stat(name)
+	if errors.Is(err, ErrNotFound) && f.isDir(name) {
+		return dirInfo(path.Base(name)), nil
+	}
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	return &fileInfo{fi}, nil
+}
+
+// file is an fs.File reading a file of the driver.
+type file struct {
+	fsys *driverFS
+	name string
+	rc   io.ReadCloser
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	return f.rc.Read(p)
+}
+
+func (f *file) Close() error {
+	return f.rc.Close()
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	fi, err := f.fsys.stat(f.name)
+	if err != nil {
+		return nil, pathError("stat", f.name, err)
+	}
+	return &fileInfo{fi}, nil
+}
+
+// seekFile is a file that can seek.
+type seekFile struct {
+	file
+	s io.Seeker
+}
+
+func (f *seekFile) Seek(offset int64, whence int) (int64, error) {
+	return f.s.Seek(offset, whence)
+}
+
+// dir is an fs.ReadDirFile of a directory.
+type dir struct {
+	fsys *driverFS
+	name string
+	// entries not yet returned by ReadDir, loaded on the first call.
+	entries []fs.DirEntry
+	loaded  bool
+}
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *dir) Close() error {
+	return nil
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return dirInfo(path.Base(d.name)), nil
+}
+
+// ReadDir returns the next n entries of the directory, or all of them if
+// n <= 0. It fails with ErrNotSupported if the driver cannot list files.
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.loaded {
+		entries, err := d.fsys.readDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.loaded = entries, true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+// fileInfo is the fs.FileInfo, and fs.DirEntry, of a file. Sys returns its
+// *FileInfo.
+type fileInfo struct {
+	fi *FileInfo
+}
+
+func (i *fileInfo) Name() string               { return path.Base(i.fi.Path) }
+func (i *fileInfo) Size() int64                { return i.fi.Size }
+func (i *fileInfo) Mode() fs.FileMode          { return 0444 }
+func (i *fileInfo) ModTime() time.Time         { return i.fi.ModTime }
+func (i *fileInfo) IsDir() bool                { return false }
+func (i *fileInfo) Sys() interface{}           { return i.fi }
+func (i *fileInfo) Type() fs.FileMode          { return 0 }
+func (i *fileInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+// dirInfo is the fs.FileInfo, and fs.DirEntry, of a directory.
+type dirInfo string
+
+func (i dirInfo) Name() string               { return string(i) }
+func (i dirInfo) Size() int64                { return 0 }
+func (i dirInfo) Mode() fs.FileMode          { return fs.ModeDir | 0555 }
+func (i dirInfo) ModTime() time.Time         { return time.Time{} }
+func (i dirInfo) IsDir() bool                { return true }
+func (i dirInfo) Sys() interface{}           { return nil }
+func (i dirInfo) Type() fs.FileMode          { return fs.ModeDir }
+func (i dirInfo) Info() (fs.FileInfo, error) { return i, nil }
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"errors"
+	"html/template"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// walkDriver is a rangeDriver that can walk its files.
+type walkDriver struct {
+	*rangeDriver
+}
+
+func (d walkDriver) Walk(prefix string, fn WalkFunc) error {
+	var paths []string
+	for p := range d.files {
+		if strings.HasPrefix(p, prefix) {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		if err := fn(&FileInfo{Path: p, Size: int64(len(d.files[p]))}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listDriver is a rangeDriver that can list its files, in a single page.
+type listDriver struct {
+	*rangeDriver
+}
+
+func (d listDriver) List(prefix string, opts *ListOptions) (*ListPage, error) {
+	page := &ListPage{}
+	seen := make(map[string]bool)
+	walkDriver(d).Walk(prefix, func(fi *FileInfo) error {
+		if i := strings.Index(fi.Path[len(prefix):], opts.Delimiter); opts.Delimiter != "" && i >= 0 {
+			p := fi.Path[:len(prefix)+i+1]
+			if !seen[p] {
+				seen[p] = true
+				page.Prefixes = append(page.Prefixes, p)
+			}
+			return nil
+		}
+		page.Files = append(page.Files, fi)
+		return nil
+	})
+	return page, nil
+}
+
+func TestAsFS(t *testing.T) {
+	files := map[string]string{
+		"index.html":       "<h1>{{.}}</h1>",
+		"css/site.css":     "body { margin: 0 }",
+		"css/fonts/a.woff": "font",
+		"img/logo.svg":     "<svg/>",
+	}
+	d := &rangeDriver{files: files}
+
+	t.Run("walk", func(t *testing.T) {
+		if err := fstest.TestFS(AsFS(walkDriver{d}), "index.html", "css/site.css", "css/fonts/a.woff", "img/logo.svg"); err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("list", func(t *testing.T) {
+		if err := fstest.TestFS(AsFS(listDriver{d}), "index.html", "css/site.css", "css/fonts/a.woff", "img/logo.svg"); err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("stat", func(t *testing.T) {
+		fsys := AsFS(walkDriver{d})
+		fi, err := fs.Stat(fsys, "css")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !fi.IsDir() || fi.Name() != "css" {
+			t.Errorf("expected the directory css got %v %q", fi.Mode(), fi.Name())
+		}
+		fi, err = fs.Stat(fsys, "css/site.css")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if fi.IsDir() || fi.Size() != int64(len(files["css/site.css"])) {
+			t.Errorf("expected a file of %d bytes got %v of %d bytes", len(files["css/site.css"]), fi.Mode(), fi.Size())
+		}
+	})
+	t.Run("template", func(t *testing.T) {
+		tmpl, err := template.ParseFS(AsFS(d), "index.html")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, "hello"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := b.String(); got != "<h1>hello</h1>" {
+			t.Errorf("expected %q got %q", "<h1>hello</h1>", got)
+		}
+	})
+	t.Run("http", func(t *testing.T) {
+		srv := httptest.NewServer(http.FileServer(http.FS(AsFS(walkDriver{d}))))
+		defer srv.Close()
+		req, _ := http.NewRequest("GET", srv.URL+"/css/site.css", nil)
+		req.Header.Set("Range", "bytes=0-3")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusPartialContent || string(b) != "body" {
+			t.Errorf("expected %d %q got %d %q", http.StatusPartialContent, "body", resp.StatusCode, string(b))
+		}
+	})
+	t.Run("not exist", func(t *testing.T) {
+		_, err := fs.ReadFile(AsFS(walkDriver{d}), "missing.txt")
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected %v got %v", fs.ErrNotExist, err)
+		}
+		_, err = fs.ReadDir(AsFS(walkDriver{d}), "missing")
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected %v got %v", fs.ErrNotExist, err)
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		_, err := AsFS(d).Open("../index.html")
+		if !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("expected %v got %v", fs.ErrInvalid, err)
+		}
+	})
+	t.Run("cannot list", func(t *testing.T) {
+		fsys := AsFS(d)
+		if _, ok := fsys.(fs.ReadDirFS); ok {
+			t.Errorf("expected %T not to implement fs.ReadDirFS", fsys)
+		}
+		b, err := fs.ReadFile(fsys, "css/site.css")
+		if err != nil || string(b) != files["css/site.css"] {
+			t.Errorf("expected %q, <nil> got %q, %v", files["css/site.css"], string(b), err)
+		}
+		if _, err := fsys.Open("css"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected %v got %v", fs.ErrNotExist, err)
+		}
+		if _, err := fs.ReadDir(fsys, "."); !errors.Is(err, ErrNotSupported) {
+			t.Errorf("expected %v got %v", ErrNotSupported, err)
+		}
+	})
+}
@@ -1,5 +1,22 @@
 module github.com/djangulo/go-storage
 
-go 1.15
+go 1.16
 
-require github.com/aws/aws-sdk-go v1.35.12
+require (
+	cloud.google.com/go/storage v1.15.0
+	github.com/Azure/azure-storage-blob-go v0.15.0
+	github.com/alicebob/miniredis/v2 v2.23.0
+	github.com/aws/aws-sdk-go v1.44.256
+	github.com/fclairamb/ftpserverlib v0.13.0
+	github.com/fsouza/fake-gcs-server v1.19.4
+	github.com/go-redis/redis/v8 v8.11.4
+	github.com/jlaffaye/ftp v0.0.0-20210307004419-5d4190119067
+	github.com/johannesboyne/gofakes3 v0.0.0-20230506070712-04da935ef877
+	github.com/mattn/go-sqlite3 v1.14.14
+	github.com/pkg/sftp v1.13.5
+	github.com/spf13/afero v1.5.1
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/crypto v0.0.0-20211215153901-e495a2d5b3d3
+	golang.org/x/net v0.9.0
+	google.golang.org/api v0.45.0
+)
@@ -2,16 +2,51 @@
 package util
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	"github.com/djangulo/go-storage"
 )
 
-func BucketExists(client s3iface.S3API, bucket string) (exists bool) {
+// ContextError returns the error of the context a request was cancelled
+// with, which the SDK does not unwrap to, or err otherwise. Besides
+// cancelled requests, this covers uploads whose body failed with the error
+// of their context.
+func ContextError(err error) error {
+	var aerr awserr.Error
+	for e := err; errors.As(e, &aerr); e = aerr.OrigErr() {
+		switch aerr.Code() {
+		// s3manager reports failed reads of upload bodies as ReadRequestBody
+		case request.CanceledErrorCode, "ReadRequestBody":
+		default:
+			continue
+		}
+		if orig := aerr.OrigErr(); orig == context.Canceled || orig == context.DeadlineExceeded {
+			return orig
+		}
+	}
+	return err
+}
+
+func BucketExists(ctx context.Context, client s3iface.S3API, bucket string) (exists bool) {
 	if bucket == "" {
 		return false
 	}
-	_, err := client.HeadBucket(&s3.HeadBucketInput{
+	_, err := client.HeadBucketWithContext(ctx, &s3.HeadBucketInput{
 		Bucket: &bucket,
 	})
 	if aerr, ok := err.(awserr.Error); ok {
@@ -28,3 +63,482 @@ func BucketExists(client s3iface.S3API, bucket string) (exists bool) {
 	}
 	return
 }
+
+// FileInfo converts the output of a HeadObject call for p into a
+// storage.FileInfo.
+func FileInfo(p string, out *s3.HeadObjectOutput) *storage.FileInfo {
+	fi := &storage.FileInfo{
+		Path:        p,
+		Size:        aws.Int64Value(out.ContentLength),
+		ModTime:     aws.TimeValue(out.LastModified),
+		ContentType: aws.StringValue(out.ContentType),
+		ETag:        strings.Trim(aws.StringValue(out.ETag), `"`),
+
+		CacheControl:       aws.StringValue(out.CacheControl),
+		ContentDisposition: aws.StringValue(out.ContentDisposition),
+		ContentEncoding:    aws.StringValue(out.ContentEncoding),
+	}
+	if len(out.Metadata) > 0 {
+		fi.Metadata = make(map[string]string, len(out.Metadata))
+		for k, v := range out.Metadata {
+			fi.Metadata[strings.ToLower(k)] = aws.StringValue(v)
+		}
+	}
+	return fi
+}
+
+// UploadInput returns the input to upload r to key in bucket, configured
+// through opts. acl is used unless opts overrides it.
+func UploadInput(bucket, key, acl string, r io.Reader, opts *storage.PutOptions) *s3manager.UploadInput {
+	input := &s3manager.UploadInput{
+		Bucket:      &bucket,
+		Key:         &key,
+		Body:        r,
+		ACL:         optional(acl),
+		ContentType: aws.String(opts.ResolveContentType(key)),
+	}
+	if opts == nil {
+		return input
+	}
+	if opts.ACL != "" {
+		input.ACL = &opts.ACL
+	}
+	if opts.CacheControl != "" {
+		input.CacheControl = &opts.CacheControl
+	}
+	if opts.ContentDisposition != "" {
+		input.ContentDisposition = &opts.ContentDisposition
+	}
+	if opts.ContentEncoding != "" {
+		input.ContentEncoding = &opts.ContentEncoding
+	}
+	if len(opts.Metadata) > 0 {
+		input.Metadata = aws.StringMap(opts.Metadata)
+	}
+	return input
+}
+
+// optional returns nil for an empty s, so the SDK does not send it.
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// ObjectKey joins root and p into an object key, keeping any trailing slash
+// of p so it can be used as a listing prefix. An empty root is the root of
+// the bucket.
+func ObjectKey(root, p string) string {
+	if root == "" {
+		return strings.TrimPrefix(p, "/")
+	}
+	return strings.TrimSuffix(root, "/") + "/" + strings.TrimPrefix(p, "/")
+}
+
+// ListObjects lists a page of the objects in bucket whose keys start with
+// ObjectKey(root, prefix). Returned paths are relative to root.
+func ListObjects(ctx context.Context, client s3iface.S3API, bucket, root, prefix string, opts *storage.ListOptions) (*storage.ListPage, error) {
+	if opts == nil {
+		opts = &storage.ListOptions{}
+	}
+	input := &s3.ListObjectsV2Input{
+		Bucket: &bucket,
+		Prefix: aws.String(ObjectKey(root, prefix)),
+	}
+	if opts.Delimiter != "" {
+		input.Delimiter = &opts.Delimiter
+	}
+	if opts.MaxResults > 0 {
+		input.MaxKeys = aws.Int64(int64(opts.MaxResults))
+	}
+	if opts.Token != "" {
+		input.ContinuationToken = &opts.Token
+	}
+	out, err := client.ListObjectsV2WithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	page := &storage.ListPage{
+		Files:     make([]*storage.FileInfo, 0, len(out.Contents)),
+		NextToken: aws.StringValue(out.NextContinuationToken),
+	}
+	for _, obj := range out.Contents {
+		page.Files = append(page.Files, objectInfo(root, obj))
+	}
+	for _, cp := range out.CommonPrefixes {
+		page.Prefixes = append(page.Prefixes, relativePath(root, aws.StringValue(cp.Prefix)))
+	}
+	return page, nil
+}
+
+// WalkObjects calls fn for every object in bucket whose key starts with
+// ObjectKey(root, prefix).
+func WalkObjects(ctx context.Context, client s3iface.S3API, bucket, root, prefix string, fn storage.WalkFunc) error {
+	var fnErr error
+	err := client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: &bucket,
+		Prefix: aws.String(ObjectKey(root, prefix)),
+	}, func(out *s3.ListObjectsV2Output, last bool) bool {
+		for _, obj := range out.Contents {
+			if fnErr = fn(objectInfo(root, obj)); fnErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return fnErr
+}
+
+func objectInfo(root string, obj *s3.Object) *storage.FileInfo {
+	return &storage.FileInfo{
+		Path:    relativePath(root, aws.StringValue(obj.Key)),
+		Size:    aws.Int64Value(obj.Size),
+		ModTime: aws.TimeValue(obj.LastModified),
+		ETag:    strings.Trim(aws.StringValue(obj.ETag), `"`),
+	}
+}
+
+func relativePath(root, key string) string {
+	if root == "" {
+		return key
+	}
+	return strings.TrimPrefix(key, strings.TrimSuffix(root, "/")+"/")
+}
+
+// GetObjectRange returns length bytes of key starting at offset, through a
+// GetObject with a Range header, or the rest of the object if length is
+// negative. An offset past the end of the object yields an empty body, as S3
+// rejects such a range.
+func GetObjectRange(ctx context.Context, client s3iface.S3API, bucket, key string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		_, err := client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+			Bucket: &bucket,
+			Key:    &key,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	rng := RangeHeader(offset, length)
+	out, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+		Range:  &rng,
+	})
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == "InvalidRange" {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// IsNotFound reports whether err is the error S3 returns for a missing
+// object. GetObject reports NoSuchKey, while HeadObject, having no response
+// body, only reports NotFound.
+func IsNotFound(err error) bool {
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey, "NotFound":
+			return true
+		}
+	}
+	return false
+}
+
+// awsError wraps an error from the AWS SDK, adding the storage sentinel it
+// corresponds to (if any), and the SDK's retry classification.
+type awsError struct {
+	err  error
+	kind error
+}
+
+// AWSError wraps err so it matches storage.ErrNotFound when S3 reports a
+// missing object, or the context error of a cancelled request, and reports
+// whether the SDK considers it retryable.
+func AWSError(err error) error {
+	if err == nil {
+		return nil
+	}
+	ae := &awsError{err: err}
+	if IsNotFound(err) {
+		ae.kind = storage.ErrNotFound
+	} else if cerr := ContextError(err); cerr != err {
+		ae.kind = cerr
+	}
+	return ae
+}
+
+func (e *awsError) Error() string {
+	return e.err.Error()
+}
+
+func (e *awsError) Unwrap() error {
+	return e.err
+}
+
+func (e *awsError) Is(target error) bool {
+	return e.kind != nil && target == e.kind
+}
+
+func (e *awsError) Retryable() bool {
+	var rf awserr.RequestFailure
+	if errors.As(e.err, &rf) && (rf.StatusCode() >= 500 || rf.StatusCode() == 429) {
+		return true
+	}
+	var aerr awserr.Error
+	if errors.As(e.err, &aerr) {
+		switch aerr.Code() {
+		// S3 specific codes the SDK does not classify
+		case "SlowDown", "InternalError", "ServiceUnavailable":
+			return true
+		}
+	}
+	return request.IsErrorRetryable(e.err) || request.IsErrorThrottle(e.err)
+}
+
+// Upload uploads input with uploader, enforcing mode. The mode is checked
+// through HeadObject before the upload, and again once the body is read,
+// right before the object is committed. When conditional is set, mode is
+// instead sent to S3 as If-None-Match/If-Match headers on the requests that
+// commit the object, which makes the check atomic on backends that honour
+// them.
+func Upload(ctx context.Context, client s3iface.S3API, uploader *s3manager.Uploader, input *s3manager.UploadInput, mode storage.WriteMode, conditional bool) error {
+	if mode.Overwrites() {
+		_, err := uploader.UploadWithContext(ctx, input)
+		return err
+	}
+	// fail before uploading the body if possible
+	if err := checkMode(ctx, client, input, mode); err != nil {
+		return err
+	}
+
+	var commitErr error
+	option := conditionalWrite(mode)
+	if !conditional {
+		option = onCommit(func(r *request.Request) {
+			if commitErr = checkMode(r.Context(), client, input, mode); commitErr != nil {
+				r.Error = commitErr
+			}
+		})
+	}
+	_, err := uploader.UploadWithContext(ctx, input, func(u *s3manager.Uploader) {
+		u.RequestOptions = append(u.RequestOptions, option)
+	})
+	switch {
+	case commitErr != nil:
+		return commitErr
+	case isPreconditionFailed(err):
+		if mode.ETag() != "" {
+			return fmt.Errorf("%w: %s", storage.ErrPreconditionFailed, mode)
+		}
+		return storage.ErrAlreadyExists
+	}
+	return err
+}
+
+// checkMode checks the object input is uploaded to against mode. It is not
+// atomic.
+func checkMode(ctx context.Context, client s3iface.S3API, input *s3manager.UploadInput, mode storage.WriteMode) error {
+	out, err := client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: input.Bucket,
+		Key:    input.Key,
+	})
+	var existing *storage.FileInfo
+	switch {
+	case err == nil:
+		existing = FileInfo(aws.StringValue(input.Key), out)
+	case !IsNotFound(err):
+		return err
+	}
+	return mode.Check(existing)
+}
+
+// onCommit calls fn with the request that creates the object, once the
+// whole body is uploaded. fn fails the request by setting its Error.
+func onCommit(fn func(r *request.Request)) request.Option {
+	return func(r *request.Request) {
+		switch r.Operation.Name {
+		case "PutObject", "CompleteMultipartUpload":
+			fn(r)
+		}
+	}
+}
+
+// conditionalWrite sets the conditional headers matching mode on the
+// requests that create the object.
+func conditionalWrite(mode storage.WriteMode) request.Option {
+	return onCommit(func(r *request.Request) {
+		if etag := mode.ETag(); etag != "" {
+			r.HTTPRequest.Header.Set("If-Match", `"`+etag+`"`)
+		} else {
+			r.HTTPRequest.Header.Set("If-None-Match", "*")
+		}
+	})
+}
+
+// isPreconditionFailed reports whether err, or any error it carries, is a
+// 412 response. s3manager reports multipart failures through OrigErr.
+func isPreconditionFailed(err error) bool {
+	for err != nil {
+		if rf, ok := err.(awserr.RequestFailure); ok && rf.StatusCode() == 412 {
+			return true
+		}
+		aerr, ok := err.(awserr.Error)
+		if !ok {
+			return false
+		}
+		if aerr.Code() == "PreconditionFailed" {
+			return true
+		}
+		err = aerr.OrigErr()
+	}
+	return false
+}
+
+var (
+	// MaxCopyObjectSize is the largest object CopyObject can copy in a
+	// single request. Larger objects are copied in parts.
+	MaxCopyObjectSize int64 = 5 << 30
+	// CopyPartSize is the minimum part size of multipart copies.
+	CopyPartSize int64 = 1 << 30
+)
+
+// CopyObject copies srcKey to dstKey within bucket, failing with
+// storage.ErrAlreadyExists if dstKey exists. The content type, headers and
+// metadata of srcKey are kept, the ACL is set to acl. When conditional is
+// set, a multipart copy is committed with If-None-Match, see Upload.
+func CopyObject(ctx context.Context, client s3iface.S3API, bucket, srcKey, dstKey, acl string, conditional bool) error {
+	src, err := client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: &bucket,
+		Key:    &srcKey,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: &bucket,
+		Key:    &dstKey,
+	})
+	if err == nil {
+		return storage.ErrAlreadyExists
+	}
+	if !IsNotFound(err) {
+		return err
+	}
+
+	// escape the key segments only, as some S3-compatible services split
+	// the source on unescaped slashes
+	segments := strings.Split(strings.TrimPrefix(srcKey, "/"), "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	source := bucket + "/" + strings.Join(segments, "/")
+	if aws.Int64Value(src.ContentLength) <= MaxCopyObjectSize {
+		_, err = client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+			Bucket:     &bucket,
+			Key:        &dstKey,
+			CopySource: &source,
+			ACL:        optional(acl),
+		})
+		return err
+	}
+	return multipartCopy(ctx, client, bucket, source, dstKey, acl, src, conditional)
+}
+
+func multipartCopy(ctx context.Context, client s3iface.S3API, bucket, source, dstKey, acl string, src *s3.HeadObjectOutput, conditional bool) error {
+	upload, err := client.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:             &bucket,
+		Key:                &dstKey,
+		ACL:                optional(acl),
+		ContentType:        src.ContentType,
+		CacheControl:       src.CacheControl,
+		ContentDisposition: src.ContentDisposition,
+		ContentEncoding:    src.ContentEncoding,
+		Metadata:           src.Metadata,
+	})
+	if err != nil {
+		return err
+	}
+
+	size := aws.Int64Value(src.ContentLength)
+	partSize := CopyPartSize
+	if min := (size + s3manager.MaxUploadParts - 1) / s3manager.MaxUploadParts; min > partSize {
+		partSize = min
+	}
+	var parts []*s3.CompletedPart
+	for n, start := int64(1), int64(0); start < size; n, start = n+1, start+partSize {
+		end := start + partSize - 1
+		if end >= size {
+			end = size - 1
+		}
+		out, err := client.UploadPartCopyWithContext(ctx, &s3.UploadPartCopyInput{
+			Bucket:          &bucket,
+			Key:             &dstKey,
+			CopySource:      &source,
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+			PartNumber:      aws.Int64(n),
+			UploadId:        upload.UploadId,
+		})
+		if err != nil {
+			abortUpload(client, bucket, dstKey, upload.UploadId)
+			return err
+		}
+		parts = append(parts, &s3.CompletedPart{
+			ETag:       out.CopyPartResult.ETag,
+			PartNumber: aws.Int64(n),
+		})
+	}
+
+	var opts []request.Option
+	if conditional {
+		opts = append(opts, conditionalWrite(storage.CreateOnly))
+	}
+	_, err = client.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          &bucket,
+		Key:             &dstKey,
+		UploadId:        upload.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	}, opts...)
+	if err != nil {
+		abortUpload(client, bucket, dstKey, upload.UploadId)
+		if isPreconditionFailed(err) {
+			return storage.ErrAlreadyExists
+		}
+		return err
+	}
+	return nil
+}
+
+// abortUpload aborts a multipart upload, on a fresh context as the one the
+// upload failed with may be done.
+func abortUpload(client s3iface.S3API, bucket, key string, uploadID *string) {
+	client.AbortMultipartUploadWithContext(context.Background(), &s3.AbortMultipartUploadInput{
+		Bucket:   &bucket,
+		Key:      &key,
+		UploadId: uploadID,
+	})
+}
+
+// PresignObject returns a presigned URL for a GET or PUT of key, valid for
+// expires.
+func PresignObject(client s3iface.S3API, method, bucket, key string, expires time.Duration) (string, error) {
+	var req *request.Request
+	switch strings.ToUpper(method) {
+	case http.MethodGet:
+		req, _ = client.GetObjectRequest(&s3.GetObjectInput{Bucket: &bucket, Key: &key})
+	case http.MethodPut:
+		req, _ = client.PutObjectRequest(&s3.PutObjectInput{Bucket: &bucket, Key: &key})
+	default:
+		return "", fmt.Errorf("%w: presigned %s", storage.ErrNotSupported, method)
+	}
+	return req.Presign(expires)
+}
@@ -0,0 +1,289 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	"github.com/djangulo/go-storage"
+)
+
+// Bucket implements the file operations shared by the drivers of S3 and
+// S3-compatible services, on the objects under a prefix of a bucket. Drivers
+// embed it, and add opening, and the URLs of their service.
+type Bucket struct {
+	// Driver is the name of the driver in its errors.
+	Driver  string
+	Session *session.Session
+	Client  s3iface.S3API
+	Name    string
+	// Prefix is joined to the paths of the files into their keys.
+	Prefix  string
+	FileACL string
+	// ACLs are the canned ACLs the service accepts for uploads.
+	ACLs map[string]struct{}
+	// Conditional is set if the service enforces the If-None-Match and
+	// If-Match headers of uploads.
+	Conditional bool
+	// URL of the prefix, which AddFile returns the URL of files under.
+	URL    string
+	Accept map[string]struct{}
+}
+
+func (b *Bucket) key(p string) string {
+	return path.Join(b.Prefix, p)
+}
+
+func (b *Bucket) error(op, p string, err error) error {
+	return &storage.Error{Op: op, Driver: b.Driver, Path: p, Err: AWSError(err)}
+}
+
+// Accepts reports whether files with the extension ext may be added.
+func (b *Bucket) Accepts(ext string) (accepts bool) {
+	_, accepts = b.Accept[ext]
+	return
+}
+
+// Close noop
+func (b *Bucket) Close() error {
+	return nil
+}
+
+func (b *Bucket) AddFile(r io.Reader, p string) (string, error) {
+	return b.addFile(context.Background(), r, p, nil)
+}
+
+// AddFileContext is the context-aware version of AddFile. Cancelling ctx
+// aborts the upload.
+func (b *Bucket) AddFileContext(ctx context.Context, r io.Reader, p string) (string, error) {
+	return b.addFile(ctx, r, p, nil)
+}
+
+// AddFileWithOptions is AddFile, configured through opts. If the service is
+// Conditional, the write mode is sent as If-None-Match/If-Match headers,
+// which it enforces atomically; otherwise it is checked through HeadObject
+// before the upload, and again before the object is created.
+func (b *Bucket) AddFileWithOptions(r io.Reader, p string, opts *storage.PutOptions) (string, error) {
+	return b.addFile(context.Background(), r, p, opts)
+}
+
+func (b *Bucket) addFile(ctx context.Context, r io.Reader, p string, opts *storage.PutOptions) (string, error) {
+	if opts == nil {
+		opts = &storage.PutOptions{}
+	}
+	if err := b.validate(p, opts); err != nil {
+		return "", b.error("add", p, err)
+	}
+	input := UploadInput(b.Name, b.key(p), b.FileACL, ContextReader(ctx, r), opts)
+	err := Upload(ctx, b.Client, s3manager.NewUploader(b.Session), input, opts.Mode, b.Conditional)
+	if err != nil {
+		return "", b.error("add", p, err)
+	}
+
+	return JoinURL(b.URL, p), nil
+}
+
+// validate checks the extension of p and the ACL of opts.
+func (b *Bucket) validate(p string, opts *storage.PutOptions) error {
+	if ext := filepath.Ext(p); !b.Accepts(ext) {
+		return fmt.Errorf("%w %s", storage.ErrInvalidExtension, ext)
+	}
+	if _, ok := b.ACLs[opts.ACL]; opts.ACL != "" && !ok {
+		return fmt.Errorf("unknown acl: %s", opts.ACL)
+	}
+	return nil
+}
+
+// OpenWriter returns a storage.Writer that feeds an s3manager upload through
+// a pipe. Parts are uploaded as they are written, but the object is only
+// created on Close, after the write mode is checked again; CloseWithError
+// aborts the upload.
+func (b *Bucket) OpenWriter(p string, opts *storage.PutOptions) (storage.Writer, error) {
+	if opts == nil {
+		opts = &storage.PutOptions{}
+	}
+	if err := b.validate(p, opts); err != nil {
+		return nil, b.error("add", p, err)
+	}
+	return storage.NewPipeWriter(func(r io.Reader) error {
+		_, err := b.addFile(context.Background(), r, p, opts)
+		return err
+	}), nil
+}
+
+func (b *Bucket) RemoveFile(p string) error {
+	return b.RemoveFileContext(context.Background(), p)
+}
+
+// RemoveFileContext is the context-aware version of RemoveFile.
+func (b *Bucket) RemoveFileContext(ctx context.Context, p string) error {
+	key := b.key(p)
+	_, err := b.Client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: &b.Name,
+		Key:    &key,
+	})
+	if err != nil {
+		return b.error("remove", p, err)
+	}
+	return nil
+}
+
+// Stat returns the FileInfo of the object on p, through a HeadObject call.
+func (b *Bucket) Stat(p string) (*storage.FileInfo, error) {
+	key := b.key(p)
+	out, err := b.Client.HeadObject(&s3.HeadObjectInput{
+		Bucket: &b.Name,
+		Key:    &key,
+	})
+	if err != nil {
+		return nil, b.error("stat", p, err)
+	}
+	return FileInfo(p, out), nil
+}
+
+// Exists reports whether there is an object on p.
+func (b *Bucket) Exists(p string) (bool, error) {
+	_, err := b.Stat(p)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// List returns a page of the objects under prefix, through ListObjectsV2.
+func (b *Bucket) List(prefix string, opts *storage.ListOptions) (*storage.ListPage, error) {
+	page, err := ListObjects(context.Background(), b.Client, b.Name, b.Prefix, prefix, opts)
+	if err != nil {
+		return nil, b.error("list", prefix, err)
+	}
+	return page, nil
+}
+
+// Walk calls fn for every object under prefix, through ListObjectsV2Pages.
+func (b *Bucket) Walk(prefix string, fn storage.WalkFunc) error {
+	var fnErr error
+	err := WalkObjects(context.Background(), b.Client, b.Name, b.Prefix, prefix, func(fi *storage.FileInfo) error {
+		fnErr = fn(fi)
+		return fnErr
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		return b.error("walk", prefix, err)
+	}
+	return nil
+}
+
+func (b *Bucket) GetFile(p string) (io.ReadCloser, error) {
+	return b.GetFileContext(context.Background(), p)
+}
+
+// GetFileContext is the context-aware version of GetFile. ctx also governs
+// reads from the returned body.
+func (b *Bucket) GetFileContext(ctx context.Context, p string) (io.ReadCloser, error) {
+	key := b.key(p)
+	file, err := b.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: &b.Name,
+		Key:    &key,
+	})
+	if err != nil {
+		return nil, b.error("get", p, err)
+	}
+	return ContextReadCloser(ctx, file.Body), nil
+}
+
+// GetFileRange returns length bytes of the object on p starting at offset,
+// or the rest of the object if length is negative, through a ranged
+// GetObject. Combined with Stat, it makes storage.GetFileSeeker work.
+func (b *Bucket) GetFileRange(p string, offset, length int64) (io.ReadCloser, error) {
+	rc, err := GetObjectRange(context.Background(), b.Client, b.Name, b.key(p), offset, length)
+	if err != nil {
+		return nil, b.error("get", p, err)
+	}
+	return rc, nil
+}
+
+// Copy copies the object on src to dst through CopyObject, without
+// downloading it. It fails with storage.ErrAlreadyExists if dst exists.
+func (b *Bucket) Copy(src, dst string) error {
+	if ext := filepath.Ext(dst); !b.Accepts(ext) {
+		return b.error("copy", dst, fmt.Errorf("%w %s", storage.ErrInvalidExtension, ext))
+	}
+	err := CopyObject(
+		context.Background(),
+		b.Client,
+		b.Name,
+		b.key(src),
+		b.key(dst),
+		b.FileACL,
+		b.Conditional,
+	)
+	if err != nil {
+		return b.error("copy", src, err)
+	}
+	return nil
+}
+
+// Move is Copy, followed by removing src.
+func (b *Bucket) Move(src, dst string) error {
+	if err := b.Copy(src, dst); err != nil {
+		return err
+	}
+	if err := b.RemoveFile(src); err != nil {
+		var se *storage.Error
+		if errors.As(err, &se) {
+			se.Op = "move"
+		}
+		return err
+	}
+	return nil
+}
+
+// PresignURL returns a presigned URL for a GET or PUT of the object on p,
+// valid for expires. The URL is on the endpoint of the service, and uploads
+// through it use the bucket's default ACL.
+func (b *Bucket) PresignURL(method, p string, expires time.Duration) (string, error) {
+	u, err := PresignObject(b.Client, method, b.Name, b.key(p), expires)
+	if err != nil {
+		return "", b.error("presign", p, err)
+	}
+	return u, nil
+}
+
+// EmptyContainer removes every object in the bucket, including those
+// outside of the prefix.
+func (b *Bucket) EmptyContainer() error {
+	iter := s3manager.NewDeleteListIterator(b.Client, &s3.ListObjectsInput{
+		Bucket: &b.Name,
+	})
+	if err := s3manager.NewBatchDeleteWithClient(b.Client).Delete(aws.BackgroundContext(), iter); err != nil {
+		return b.error("empty", "", err)
+	}
+
+	return nil
+}
+
+// DeleteContainer empties the bucket, and deletes it.
+func (b *Bucket) DeleteContainer() error {
+	if err := b.EmptyContainer(); err != nil {
+		return err
+	}
+	_, err := b.Client.DeleteBucket(&s3.DeleteBucketInput{
+		Bucket: &b.Name,
+	})
+	if err != nil {
+		return b.error("delete", "", err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package util
+
+import (
+	"context"
+	"io"
+)
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+	buf []byte
+}
+
+type readResult struct {
+	n   int
+	err error
+}
+
+// ContextReader returns an io.Reader that reads from r until ctx is done,
+// after which every Read returns ctx.Err(). A Read blocked on r returns as
+// soon as ctx is done, abandoning the underlying Read to a goroutine, so
+// that a stalled body cannot hold up a cancelled call.
+func ContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return &contextReader{ctx: ctx, r: r}
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	// contexts that are never done need no goroutine
+	if cr.ctx.Done() == nil {
+		return cr.r.Read(p)
+	}
+	if cap(cr.buf) < len(p) {
+		cr.buf = make([]byte, len(p))
+	}
+	buf := cr.buf[:len(p)]
+	ch := make(chan readResult, 1)
+	go func() {
+		n, err := cr.r.Read(buf)
+		ch <- readResult{n, err}
+	}()
+	select {
+	case res := <-ch:
+		copy(p, buf[:res.n])
+		return res.n, res.err
+	case <-cr.ctx.Done():
+		// the abandoned Read still owns buf
+		cr.buf = nil
+		return 0, cr.ctx.Err()
+	}
+}
+
+type contextReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// ContextReadCloser is ContextReader for the body of a download, which
+// Close closes. rc is returned as is if ctx is never done.
+func ContextReadCloser(ctx context.Context, rc io.ReadCloser) io.ReadCloser {
+	if ctx.Done() == nil {
+		return rc
+	}
+	return &contextReadCloser{Reader: ContextReader(ctx, rc), Closer: rc}
+}
@@ -0,0 +1,78 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// StatusError is an unexpected response of an HTTP server.
+type StatusError struct {
+	Method string
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: unexpected status %s", e.Method, e.Status)
+}
+
+// Retryable reports whether the server failed, or asked to slow down.
+func (e *StatusError) Retryable() bool {
+	return e.Code >= 500 || e.Code == http.StatusTooManyRequests
+}
+
+// Status returns the status code of the StatusError in err's chain, or 0.
+func Status(err error) int {
+	var serr *StatusError
+	if errors.As(err, &serr) {
+		return serr.Code
+	}
+	return 0
+}
+
+// CheckStatus returns resp if its status is one of ok. Any other response
+// is drained and closed, and fails with a *StatusError.
+func CheckStatus(resp *http.Response, ok ...int) (*http.Response, error) {
+	for _, code := range ok {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return nil, &StatusError{Method: resp.Request.Method, Code: resp.StatusCode, Status: resp.Status}
+}
+
+// GetRange returns length bytes of a file starting at offset, or the rest
+// of the file if length is negative, through get, which sends a GET request
+// with the given Range header, and must accept both 200 and 206 responses.
+// Servers that ignore the range send the whole file, which is skipped
+// through, and a range past the end of the file reads nothing.
+func GetRange(get func(header http.Header) (*http.Response, error), offset, length int64) (io.ReadCloser, error) {
+	resp, err := get(http.Header{"Range": {RangeHeader(offset, length)}})
+	if Status(err) == http.StatusRequestedRangeNotSatisfiable {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, offset); err != nil && err != io.EOF {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+	if length < 0 {
+		return resp.Body, nil
+	}
+	return &limitedBody{Reader: io.LimitReader(resp.Body, length), Closer: resp.Body}, nil
+}
+
+type limitedBody struct {
+	io.Reader
+	io.Closer
+}
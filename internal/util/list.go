@@ -0,0 +1,89 @@
+package util
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/djangulo/go-storage"
+)
+
+// DefaultMaxResults is the page size used when storage.ListOptions does not
+// set one.
+const DefaultMaxResults = 1000
+
+// Paginate builds a storage.ListPage out of every file known to a driver, for
+// drivers that cannot filter or paginate natively. files is sorted in place
+// by path. The returned NextToken is the last path or prefix in the page.
+func Paginate(files []*storage.FileInfo, prefix string, opts *storage.ListOptions) *storage.ListPage {
+	if opts == nil {
+		opts = &storage.ListOptions{}
+	}
+	max := opts.MaxResults
+	if max <= 0 {
+		max = DefaultMaxResults
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	var (
+		page = &storage.ListPage{}
+		last string
+		n    int
+	)
+	for _, fi := range files {
+		if !strings.HasPrefix(fi.Path, prefix) {
+			continue
+		}
+		name, isPrefix := fi.Path, false
+		if opts.Delimiter != "" {
+			if i := strings.Index(fi.Path[len(prefix):], opts.Delimiter); i >= 0 {
+				name = fi.Path[:len(prefix)+i+len(opts.Delimiter)]
+				isPrefix = true
+			}
+		}
+		if opts.Token != "" && name <= opts.Token {
+			continue
+		}
+		if isPrefix && name == last {
+			continue
+		}
+		if n == max {
+			page.NextToken = last
+			break
+		}
+		if isPrefix {
+			page.Prefixes = append(page.Prefixes, name)
+		} else {
+			page.Files = append(page.Files, fi)
+		}
+		last = name
+		n++
+	}
+	return page
+}
+
+// ListFromWalk builds a page of the files under prefix out of every file
+// d walks, for drivers that cannot filter or paginate natively. Errors of
+// the walk are reported as those of a list.
+func ListFromWalk(d storage.WalkDriver, prefix string, opts *storage.ListOptions) (*storage.ListPage, error) {
+	var files = make([]*storage.FileInfo, 0)
+	err := d.Walk(prefix, func(fi *storage.FileInfo) error {
+		files = append(files, fi)
+		return nil
+	})
+	if err != nil {
+		if serr, ok := err.(*storage.Error); ok {
+			serr.Op = "list"
+		}
+		return nil, err
+	}
+	return Paginate(files, prefix, opts), nil
+}
+
+// FnError wraps the errors of a storage.WalkFunc, so that drivers walking
+// through callbacks of their own can tell them apart, and return them as
+// is.
+type FnError struct {
+	Err error
+}
+
+func (e *FnError) Error() string { return e.Err.Error() }
@@ -0,0 +1,40 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrNegativeOffset is returned by ClampRange for ranges starting before the
+// beginning of the file.
+var ErrNegativeOffset = errors.New("negative offset")
+
+// ClampRange returns the offset and length of the part of a file of size
+// bytes that a range of length bytes starting at offset covers, as
+// storage.RangeDriver defines it: a negative length, or one past the end of
+// the file, reads to the end, and an offset past the end reads nothing.
+// It fails with ErrNegativeOffset if offset is negative.
+func ClampRange(size, offset, length int64) (int64, int64, error) {
+	if offset < 0 {
+		return 0, 0, ErrNegativeOffset
+	}
+	if offset > size {
+		offset = size
+	}
+	// compare against the bytes left, as offset+length may overflow
+	if length < 0 || length > size-offset {
+		length = size - offset
+	}
+	return offset, length, nil
+}
+
+// RangeHeader returns the value of the HTTP Range header requesting length
+// bytes starting at offset. A negative length, or one reaching past the
+// largest offset, requests the rest of the file.
+func RangeHeader(offset, length int64) string {
+	if length < 0 || length > math.MaxInt64-offset {
+		return fmt.Sprintf("bytes=%d-", offset)
+	}
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+}
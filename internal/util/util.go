@@ -1,10 +1,31 @@
 package util
 
 import (
+	"fmt"
 	"net/url"
+	"path"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
+var userinfore = regexp.MustCompile(`^([\w+.-]+://)([^:/@]*)(:[^@]*)?@`)
+
+// RedactURL replaces the secret in the userinfo of urlString with "xxxxx",
+// so urlString can be safely included in error messages. The user name is
+// kept when followed by a password, and redacted otherwise, as some schemes
+// only take a token. It works on strings url.Parse would reject.
+func RedactURL(urlString string) string {
+	m := userinfore.FindStringSubmatchIndex(urlString)
+	if m == nil {
+		return urlString
+	}
+	if m[6] == -1 {
+		return urlString[:m[3]] + "xxxxx" + urlString[m[1]-1:]
+	}
+	return urlString[:m[5]] + ":xxxxx" + urlString[m[1]-1:]
+}
+
 // ParseCommaSeparatedQuery turns a query string of the form
 //    .../?x=a,b,c,d&x=f
 // into a map[string]struct{}.
@@ -29,3 +50,28 @@ func ParseCommaSeparatedQuery(q url.Values, field string, defaults ...string) ma
 	}
 	return ret
 }
+
+// ParseBoolQuery returns the boolean value of field in q, as parsed by
+// strconv.ParseBool, or def if it is not set.
+func ParseBoolQuery(q url.Values, field string, def bool) (bool, error) {
+	v := q.Get(field)
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s value: %s", field, v)
+	}
+	return b, nil
+}
+
+// JoinURL joins entries onto the path of base. Unlike path.Join, it keeps the
+// double slash after the scheme of an absolute URL.
+func JoinURL(base string, entries ...string) string {
+	u, err := url.Parse(base)
+	if err != nil || u.Scheme == "" {
+		return path.Join(append([]string{base}, entries...)...)
+	}
+	u.Path = path.Join(append([]string{"/", u.Path}, entries...)...)
+	return u.String()
+}
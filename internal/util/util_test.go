@@ -1,9 +1,26 @@
 package util
 
 import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
 	"net/url"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/djangulo/go-storage"
 )
 
 func TestParseCommaSeparatedQuery(t *testing.T) {
@@ -51,3 +68,306 @@ func TestParseCommaSeparatedQuery(t *testing.T) {
 		})
 	}
 }
+
+func TestContextReader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := ContextReader(ctx, strings.NewReader("hello world"))
+
+	p := make([]byte, 5)
+	if n, err := r.Read(p); n != 5 || err != nil {
+		t.Fatalf("expected 5, <nil> got %d, %v", n, err)
+	}
+	cancel()
+	if _, err := r.Read(p); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v got %v", context.Canceled, err)
+	}
+}
+
+func TestContextReaderBlocked(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := ContextReader(ctx, pr)
+
+	time.AfterFunc(10*time.Millisecond, cancel)
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 5))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected %v got %v", context.Canceled, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Read blocked after the context was cancelled")
+	}
+}
+
+func TestContextError(t *testing.T) {
+	err := awserr.New(request.CanceledErrorCode, "request context canceled", context.Canceled)
+	if got := ContextError(err); got != context.Canceled {
+		t.Errorf("expected %v got %v", context.Canceled, got)
+	}
+	err = awserr.New("MultipartUpload", "upload multipart failed",
+		awserr.New("ReadRequestBody", "read multipart upload data failed", context.Canceled))
+	if got := ContextError(err); got != context.Canceled {
+		t.Errorf("expected %v got %v", context.Canceled, got)
+	}
+	err = awserr.New("NoSuchKey", "not found", nil)
+	if got := ContextError(err); got != err {
+		t.Errorf("expected %v got %v", err, got)
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	var files = []*storage.FileInfo{
+		{Path: "img/b.png"},
+		{Path: "img/a.png"},
+		{Path: "img/icons/x.svg"},
+		{Path: "img/icons/y.svg"},
+		{Path: "img.txt"},
+		{Path: "other/c.png"},
+	}
+	for _, tt := range []struct {
+		name     string
+		prefix   string
+		opts     *storage.ListOptions
+		files    []string
+		prefixes []string
+		token    string
+	}{
+		{"nil options", "img/", nil, []string{"img/a.png", "img/b.png", "img/icons/x.svg", "img/icons/y.svg"}, nil, ""},
+		{"delimiter", "img/", &storage.ListOptions{Delimiter: "/"}, []string{"img/a.png", "img/b.png"}, []string{"img/icons/"}, ""},
+		{"root delimiter", "", &storage.ListOptions{Delimiter: "/"}, []string{"img.txt"}, []string{"img/", "other/"}, ""},
+		{"max results", "img/", &storage.ListOptions{MaxResults: 2}, []string{"img/a.png", "img/b.png"}, nil, "img/b.png"},
+		{"token", "img/", &storage.ListOptions{MaxResults: 2, Token: "img/b.png"}, []string{"img/icons/x.svg", "img/icons/y.svg"}, nil, ""},
+		{"token is a prefix", "", &storage.ListOptions{Delimiter: "/", Token: "img/"}, nil, []string{"other/"}, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			page := Paginate(files, tt.prefix, tt.opts)
+			got := make([]string, 0)
+			for _, fi := range page.Files {
+				got = append(got, fi.Path)
+			}
+			if tt.files == nil {
+				tt.files = []string{}
+			}
+			if !reflect.DeepEqual(got, tt.files) {
+				t.Errorf("expected files %v got %v", tt.files, got)
+			}
+			if !reflect.DeepEqual(page.Prefixes, tt.prefixes) {
+				t.Errorf("expected prefixes %v got %v", tt.prefixes, page.Prefixes)
+			}
+			if page.NextToken != tt.token {
+				t.Errorf("expected token %q got %q", tt.token, page.NextToken)
+			}
+		})
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+	}{
+		{"do://key:secret@space/prefix?accept=.txt", "do://key:xxxxx@space/prefix?accept=.txt"},
+		{"do://key:se/cr+et@space/prefix", "do://key:xxxxx@space/prefix"},
+		{"do://secret@space/prefix", "do://xxxxx@space/prefix"},
+		{"do://:secret@space/prefix", "do://:xxxxx@space/prefix"},
+		{"awss3://bucket/prefix", "awss3://bucket/prefix"},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := RedactURL(tt.in); got != tt.want {
+				t.Errorf("expected %q got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAWSError(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		in        error
+		notFound  bool
+		retryable bool
+	}{
+		{"no such key", awserr.New(s3.ErrCodeNoSuchKey, "key does not exist", nil), true, false},
+		{"head not found", awserr.New("NotFound", "not found", nil), true, false},
+		{"throttled", awserr.New("SlowDown", "slow down", nil), false, true},
+		{"access denied", awserr.New("AccessDenied", "access denied", nil), false, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := &storage.Error{Op: "get", Driver: "awss3", Err: AWSError(tt.in)}
+			if got := errors.Is(err, storage.ErrNotFound); got != tt.notFound {
+				t.Errorf("expected errors.Is(err, ErrNotFound) %v got %v", tt.notFound, got)
+			}
+			if got := err.Retryable(); got != tt.retryable {
+				t.Errorf("expected Retryable() %v got %v", tt.retryable, got)
+			}
+		})
+	}
+}
+
+func TestAWSErrorCancelled(t *testing.T) {
+	in := awserr.New(request.CanceledErrorCode, "request context canceled", context.Canceled)
+	err := &storage.Error{Op: "get", Driver: "awss3", Err: AWSError(in)}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v got %v", context.Canceled, err)
+	}
+	if err.Retryable() {
+		t.Error("expected a cancelled request not to be retryable")
+	}
+}
+
+func TestUploadInput(t *testing.T) {
+	r := strings.NewReader("hello world")
+	if in := UploadInput("bucket", "a.txt", "", r, nil); in.ACL != nil {
+		t.Errorf("expected no ACL got %q", *in.ACL)
+	}
+	in := UploadInput("bucket", "a.txt", "private", r, &storage.PutOptions{ACL: "public-read"})
+	if got := aws.StringValue(in.ACL); got != "public-read" {
+		t.Errorf("expected %q got %q", "public-read", got)
+	}
+}
+
+func TestPresignObject(t *testing.T) {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	client := s3.New(sess)
+
+	for _, method := range []string{"GET", "put"} {
+		u, err := PresignObject(client, method, "bucket", "/assets/a.txt", time.Minute)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.Contains(u, "/assets/a.txt") || !strings.Contains(u, "X-Amz-Signature=") {
+			t.Errorf("unexpected presigned %s url %q", method, u)
+		}
+	}
+	_, err := PresignObject(client, "DELETE", "bucket", "/assets/a.txt", time.Minute)
+	if !errors.Is(err, storage.ErrNotSupported) {
+		t.Errorf("expected %v got %v", storage.ErrNotSupported, err)
+	}
+}
+
+func TestJoinURL(t *testing.T) {
+	for _, tt := range []struct {
+		base    string
+		entries []string
+		want    string
+	}{
+		{"https://bucket.s3.us-east-1.amazonaws.com/assets", []string{"a/b.txt"}, "https://bucket.s3.us-east-1.amazonaws.com/assets/a/b.txt"},
+		{"https://cdn.example.com", []string{"/assets", "/b.txt"}, "https://cdn.example.com/assets/b.txt"},
+		{"http://localhost:9000/bucket/", []string{"b.txt"}, "http://localhost:9000/bucket/b.txt"},
+		{"/assets", []string{"b.txt"}, "/assets/b.txt"},
+	} {
+		if got := JoinURL(tt.base, tt.entries...); got != tt.want {
+			t.Errorf("JoinURL(%q, %q): expected %q got %q", tt.base, tt.entries, tt.want, got)
+		}
+	}
+}
+
+func TestParseBoolQuery(t *testing.T) {
+	q := url.Values{"a": {"true"}, "b": {"0"}, "c": {"maybe"}}
+	if v, err := ParseBoolQuery(q, "a", false); !v || err != nil {
+		t.Errorf("expected true got %v, %v", v, err)
+	}
+	if v, err := ParseBoolQuery(q, "b", true); v || err != nil {
+		t.Errorf("expected false got %v, %v", v, err)
+	}
+	if v, err := ParseBoolQuery(q, "missing", true); !v || err != nil {
+		t.Errorf("expected default true got %v, %v", v, err)
+	}
+	if _, err := ParseBoolQuery(q, "c", false); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestClampRange(t *testing.T) {
+	for _, tt := range []struct {
+		offset, length int64
+		wantOffset     int64
+		wantLength     int64
+	}{
+		{0, -1, 0, 10},
+		{3, 4, 3, 4},
+		{7, 10, 7, 3},
+		{1, math.MaxInt64, 1, 9},
+		{20, -1, 10, 0},
+		{20, math.MaxInt64, 10, 0},
+	} {
+		offset, length, err := ClampRange(10, tt.offset, tt.length)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if offset != tt.wantOffset || length != tt.wantLength {
+			t.Errorf("ClampRange(10, %d, %d): expected %d, %d got %d, %d", tt.offset, tt.length, tt.wantOffset, tt.wantLength, offset, length)
+		}
+	}
+	if _, _, err := ClampRange(10, -1, 4); !errors.Is(err, ErrNegativeOffset) {
+		t.Errorf("expected %v got %v", ErrNegativeOffset, err)
+	}
+}
+
+func TestRangeHeader(t *testing.T) {
+	for _, tt := range []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, -1, "bytes=0-"},
+		{3, 4, "bytes=3-6"},
+		{3, math.MaxInt64, "bytes=3-"},
+		{0, math.MaxInt64, "bytes=0-9223372036854775806"},
+	} {
+		if got := RangeHeader(tt.offset, tt.length); got != tt.want {
+			t.Errorf("RangeHeader(%d, %d): expected %q got %q", tt.offset, tt.length, tt.want, got)
+		}
+	}
+}
+
+func TestGetRange(t *testing.T) {
+	const data = "0123456789"
+	for _, tt := range []struct {
+		name           string
+		code           int
+		offset, length int64
+		want           string
+	}{
+		{"partial", http.StatusPartialContent, 3, 4, "3456"},
+		{"range ignored", http.StatusOK, 3, 4, "3456"},
+		{"range ignored to the end", http.StatusOK, 3, -1, "3456789"},
+		{"not satisfiable", http.StatusRequestedRangeNotSatisfiable, 20, 4, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, err := GetRange(func(header http.Header) (*http.Response, error) {
+				if got, want := header.Get("Range"), RangeHeader(tt.offset, tt.length); got != want {
+					t.Errorf("expected range %q got %q", want, got)
+				}
+				switch tt.code {
+				case http.StatusOK:
+					return &http.Response{StatusCode: tt.code, Body: ioutil.NopCloser(strings.NewReader(data))}, nil
+				case http.StatusPartialContent:
+					body := data[tt.offset : tt.offset+tt.length]
+					return &http.Response{StatusCode: tt.code, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+				}
+				return nil, &StatusError{Method: http.MethodGet, Code: tt.code}
+			}, tt.offset, tt.length)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			if b, err := ioutil.ReadAll(rc); string(b) != tt.want || err != nil {
+				t.Errorf("expected %q, <nil> got %q, %v", tt.want, string(b), err)
+			}
+		})
+	}
+	_, err := GetRange(func(http.Header) (*http.Response, error) {
+		return nil, &StatusError{Method: http.MethodGet, Code: http.StatusForbidden}
+	}, 0, 4)
+	if Status(err) != http.StatusForbidden {
+		t.Errorf("expected a %d status error got %v", http.StatusForbidden, err)
+	}
+}
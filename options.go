@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PutOptions control a single write through PutDriver.AddFileWithOptions.
+// The zero value (and a nil *PutOptions) behaves like AddFile.
+type PutOptions struct {
+	// Mode determines what happens when path already holds a file. Default
+	// CreateOnly.
+	Mode WriteMode
+	// ContentType of the file. Default ResolveContentType(path).
+	ContentType string
+	// CacheControl, ContentDisposition and ContentEncoding are stored along
+	// the file, and served as the respective HTTP headers by drivers that
+	// serve files over HTTP.
+	CacheControl       string
+	ContentDisposition string
+	ContentEncoding    string
+	// Metadata is user-defined metadata stored along the file. Keys are
+	// case-insensitive, and read back lower-cased.
+	Metadata map[string]string
+	// ACL overrides the driver-wide ACL (e.g. "private", "public-read") for
+	// this file. Drivers without ACLs ignore it.
+	ACL string
+}
+
+// ResolveContentType returns o.ContentType, or ResolveContentType(path) if
+// it is not set. o may be nil.
+func (o *PutOptions) ResolveContentType(path string) string {
+	if o != nil && o.ContentType != "" {
+		return o.ContentType
+	}
+	return ResolveContentType(path)
+}
+
+// WriteMode determines what a write does when the destination path already
+// holds a file. Use CreateOnly, Overwrite or IfMatch.
+type WriteMode struct {
+	overwrite bool
+	etag      string
+}
+
+var (
+	// CreateOnly fails with ErrAlreadyExists if the path holds a file. This
+	// is the behaviour of AddFile. Drivers make the check atomic where the
+	// backend supports it.
+	CreateOnly = WriteMode{}
+	// Overwrite replaces any existing file.
+	Overwrite = WriteMode{overwrite: true}
+)
+
+// IfMatch only replaces an existing file whose ETag (see FileInfo) is etag,
+// and fails with ErrPreconditionFailed otherwise, including when there is no
+// file on the path.
+func IfMatch(etag string) WriteMode {
+	return WriteMode{etag: strings.Trim(etag, `"`)}
+}
+
+// Overwrites reports whether m replaces existing files unconditionally.
+func (m WriteMode) Overwrites() bool {
+	return m.overwrite
+}
+
+// ETag returns the ETag an IfMatch mode requires, or "" for other modes.
+func (m WriteMode) ETag() string {
+	return m.etag
+}
+
+func (m WriteMode) String() string {
+	switch {
+	case m.overwrite:
+		return "Overwrite"
+	case m.etag != "":
+		return fmt.Sprintf("IfMatch(%q)", m.etag)
+	default:
+		return "CreateOnly"
+	}
+}
+
+// Check returns the error a write with mode m must fail with, given the
+// FileInfo of the file currently on the destination path, or nil if there is
+// none. Drivers that cannot enforce m atomically call it before writing.
+func (m WriteMode) Check(existing *FileInfo) error {
+	switch {
+	case m.overwrite:
+		return nil
+	case m.etag != "":
+		if existing == nil {
+			return fmt.Errorf("%w: no file to match %s against", ErrPreconditionFailed, m)
+		}
+		if existing.ETag != m.etag {
+			return fmt.Errorf("%w: %s does not match %q", ErrPreconditionFailed, m, existing.ETag)
+		}
+		return nil
+	default:
+		if existing != nil {
+			return ErrAlreadyExists
+		}
+		return nil
+	}
+}
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWriteModeCheck(t *testing.T) {
+	existing := &FileInfo{Path: "a.txt", ETag: "abc"}
+	for _, tt := range []struct {
+		mode     WriteMode
+		existing *FileInfo
+		want     error
+	}{
+		{CreateOnly, nil, nil},
+		{CreateOnly, existing, ErrAlreadyExists},
+		{Overwrite, nil, nil},
+		{Overwrite, existing, nil},
+		{IfMatch("abc"), existing, nil},
+		{IfMatch(`"abc"`), existing, nil},
+		{IfMatch("xyz"), existing, ErrPreconditionFailed},
+		{IfMatch("abc"), nil, ErrPreconditionFailed},
+	} {
+		name := tt.mode.String()
+		if tt.existing == nil {
+			name += " no file"
+		}
+		t.Run(name, func(t *testing.T) {
+			err := tt.mode.Check(tt.existing)
+			if tt.want == nil && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("expected %v got %v", tt.want, err)
+			}
+		})
+	}
+}
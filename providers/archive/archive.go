@@ -0,0 +1,500 @@
+// Package archive implements a storage.Driver for the files of a zip or tar
+// archive. An archive is either read, or built from scratch and written out
+// on Close.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/djangulo/go-storage"
+	"github.com/djangulo/go-storage/internal/util"
+)
+
+// Storage is a storage.Driver for the files of an archive.
+type Storage struct {
+	config *Config
+
+	// file is the archive being read, or its decompressed copy.
+	file *os.File
+	// tmp is the path of the decompressed copy of a tar+gz archive, which
+	// Close removes.
+	tmp     string
+	entries map[string]*entry
+	// names of the entries, sorted.
+	names []string
+
+	// mu serializes the writes of an archive being built.
+	mu sync.Mutex
+	w  *builder
+}
+
+type Config struct {
+	// Format is zip, tar or tar+gz.
+	Format string
+	// File is the path of the archive on disk.
+	File string
+	// Write builds a new archive rather than reading File.
+	Write  bool
+	accept map[string]struct{}
+}
+
+// entry is a file of an archive being read.
+type entry struct {
+	info *storage.FileInfo
+	// zf is the zip entry, nil for tar entries.
+	zf *zip.File
+	// offset of the contents of a tar entry in the file.
+	offset int64
+}
+
+// compile-time checks of the optional interfaces Storage implements.
+var (
+	_ storage.ContextDriver = (*Storage)(nil)
+	_ storage.StatDriver    = (*Storage)(nil)
+	_ storage.ListDriver    = (*Storage)(nil)
+	_ storage.WalkDriver    = (*Storage)(nil)
+	_ storage.RangeDriver   = (*Storage)(nil)
+)
+
+func init() {
+	storage.Register("zip", &Storage{})
+	storage.Register("tar", &Storage{})
+	storage.Register("tar+gz", &Storage{})
+}
+
+// Path returns the URL of the archive, without parameters.
+func (s *Storage) Path() string {
+	return s.config.Format + "://" + filepath.ToSlash(s.config.File)
+}
+
+func (s *Storage) Accepts(ext string) (accepts bool) {
+	_, accepts = s.config.accept[ext]
+	return
+}
+
+func (s *Storage) NormalizePath(entries ...string) string {
+	return util.JoinURL(s.Path(), entries...)
+}
+
+var ErrURLParse = errors.New("error parsing url")
+
+func parseURL(urlString string) (*Config, error) {
+	u, err := url.Parse(urlString)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrURLParse, err)
+	}
+	c := &Config{
+		Format: u.Scheme,
+		File:   filepath.FromSlash(u.Host + u.Path),
+	}
+	switch c.Format {
+	case "zip", "tar", "tar+gz":
+	default:
+		c.File = ""
+	}
+	if c.File == "" {
+		return nil, fmt.Errorf(
+			"%w: %s does not match \"zip|tar|tar+gz:///path/to/archive\" format",
+			ErrURLParse,
+			urlString,
+		)
+	}
+
+	q := u.Query()
+	switch mode := q.Get("mode"); mode {
+	case "", "read":
+	case "write":
+		c.Write = true
+	default:
+		return nil, fmt.Errorf("%w: invalid mode %q", ErrURLParse, mode)
+	}
+	c.accept = util.ParseCommaSeparatedQuery(q, "accept", ".jpeg", ".jpg", ".png", ".svg")
+	return c, nil
+}
+
+// Open creates a *Storage. The urlString should be in the form
+// zip:///path/to/archive.zip?mode=&accept=
+// or tar://... for tar archives and tar+gz://... for gzipped tar archives.
+// Relative paths are written as zip://./archive.zip.
+// In read mode, the entries of the archive are indexed on Open, and tar+gz
+// archives are decompressed to a temporary file, so that every file can be
+// read without going through the archive. The driver is read-only.
+// In write mode, the files added are written to a new archive, which Close
+// moves to path, replacing any archive there. Files cannot be read back
+// until then.
+// The URL parameters accepted are as follows:
+//   - mode: either read or write. Default read.
+//   - accept: comma-separated list of file extensions to accept. Could be
+//     repeated. e.g. url://host/path?accept=.jpeg,.svg&accept=.png would
+//     accept .jpeg, .svg and .png files. Default .jpeg,.jpg,.png,.svg
+func (s *Storage) Open(urlString string) (storage.Driver, error) {
+	return s.OpenContext(context.Background(), urlString)
+}
+
+// OpenContext is the context-aware version of Open. The context is only
+// checked before opening the archive.
+func (s *Storage) OpenContext(ctx context.Context, urlString string) (storage.Driver, error) {
+	var err error
+
+	if err := ctx.Err(); err != nil {
+		return nil, s.error("open", "", err)
+	}
+
+	ns := &Storage{}
+
+	ns.config, err = parseURL(urlString)
+	if err != nil {
+		return nil, s.error("open", "", err)
+	}
+	if ns.config.Write {
+		ns.w, err = newBuilder(ns.config)
+	} else {
+		err = ns.index()
+	}
+	if err != nil {
+		return nil, s.error("open", "", err)
+	}
+
+	return ns, nil
+}
+
+func (s *Storage) error(op, p string, err error) error {
+	return &storage.Error{Op: op, Driver: "archive", Path: p, Err: err}
+}
+
+// Close finalizes an archive being written, moving it to its path. For an
+// archive being read, it closes the archive and removes its decompressed
+// copy, if any.
+func (s *Storage) Close() error {
+	if s.w != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if err := s.w.close(s.config.File); err != nil {
+			return s.error("close", "", err)
+		}
+		return nil
+	}
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	if s.tmp != "" {
+		os.Remove(s.tmp)
+	}
+	if err != nil {
+		return s.error("close", "", err)
+	}
+	return nil
+}
+
+// index opens the archive and records its regular files.
+func (s *Storage) index() error {
+	file, err := os.Open(s.config.File)
+	if err != nil {
+		return err
+	}
+	s.entries = make(map[string]*entry)
+	switch s.config.Format {
+	case "zip":
+		err = s.indexZip(file)
+	case "tar+gz":
+		if file, err = s.decompress(file); err == nil {
+			err = s.indexTar(file)
+		}
+	default:
+		err = s.indexTar(file)
+	}
+	if err != nil {
+		if file != nil {
+			file.Close()
+		}
+		if s.tmp != "" {
+			os.Remove(s.tmp)
+		}
+		return err
+	}
+	s.file = file
+	sort.Strings(s.names)
+	return nil
+}
+
+func (s *Storage) indexZip(file *os.File) error {
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(file, fi.Size())
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+		s.add(zf.Name, &entry{
+			zf: zf,
+			info: &storage.FileInfo{
+				Size:    int64(zf.UncompressedSize64),
+				ModTime: zf.Modified,
+				ETag:    fmt.Sprintf("%08x", zf.CRC32),
+			},
+		})
+	}
+	return nil
+}
+
+// indexTar records the offset of each file in the tar archive, which
+// tar.Reader leaves the file at after reading its header.
+func (s *Storage) indexTar(file *os.File) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	tr := tar.NewReader(file)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// sparse files are not stored contiguously
+		if !hdr.FileInfo().Mode().IsRegular() || hdr.Typeflag == tar.TypeGNUSparse {
+			continue
+		}
+		offset, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		s.add(hdr.Name, &entry{
+			offset: offset,
+			info: &storage.FileInfo{
+				Size:    hdr.Size,
+				ModTime: hdr.ModTime,
+				ETag:    fmt.Sprintf("%x-%x", hdr.ModTime.UnixNano(), hdr.Size),
+			},
+		})
+	}
+}
+
+// decompress copies the contents of the gzipped file to a temporary file,
+// and closes it.
+func (s *Storage) decompress(file *os.File) (*os.File, error) {
+	defer file.Close()
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile("", "go-storage-*.tar")
+	if err != nil {
+		return nil, err
+	}
+	s.tmp = tmp.Name()
+	if _, err := io.Copy(tmp, zr); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// add records e under the cleaned name. Later entries of the same name
+// replace earlier ones, as with tar.
+func (s *Storage) add(name string, e *entry) {
+	name = clean(name)
+	if name == "" {
+		return
+	}
+	if _, ok := s.entries[name]; !ok {
+		s.names = append(s.names, name)
+	}
+	s.entries[name] = e
+}
+
+// clean returns name relative to the root of the archive.
+func clean(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// errWriteMode is returned by the read operations of an archive being
+// written.
+var errWriteMode = fmt.Errorf("%w: the archive is open for writing", storage.ErrNotSupported)
+
+// lookup returns the entry of p.
+func (s *Storage) lookup(p string) (*entry, error) {
+	if s.w != nil {
+		return nil, errWriteMode
+	}
+	e, ok := s.entries[clean(p)]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return e, nil
+}
+
+// AddFile adds the contents of r to an archive open for writing. It fails
+// with storage.ErrReadOnly for an archive open for reading.
+func (s *Storage) AddFile(r io.Reader, p string) (string, error) {
+	return s.AddFileContext(context.Background(), r, p)
+}
+
+// AddFileContext is the context-aware version of AddFile. r is read whole
+// before anything is added to the archive, so a cancelled or failed read
+// leaves nothing behind.
+func (s *Storage) AddFileContext(ctx context.Context, r io.Reader, p string) (string, error) {
+	if s.w == nil {
+		return "", s.error("add", p, storage.ErrReadOnly)
+	}
+	if ext := filepath.Ext(p); !s.Accepts(ext) {
+		return "", s.error("add", p, fmt.Errorf("%w %s", storage.ErrInvalidExtension, ext))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.w.add(ctx, r, clean(p)); err != nil {
+		return "", s.error("add", p, err)
+	}
+	return s.NormalizePath(p), nil
+}
+
+// RemoveFile fails with storage.ErrReadOnly, or storage.ErrNotSupported
+// for an archive open for writing.
+func (s *Storage) RemoveFile(p string) error {
+	return s.RemoveFileContext(context.Background(), p)
+}
+
+// RemoveFileContext is the context-aware version of RemoveFile.
+func (s *Storage) RemoveFileContext(ctx context.Context, p string) error {
+	if s.w != nil {
+		return s.error("remove", p, fmt.Errorf("%w: files cannot be removed from an archive", storage.ErrNotSupported))
+	}
+	return s.error("remove", p, storage.ErrReadOnly)
+}
+
+// GetFile returns the contents of the file on path, reading only its
+// entry of the archive.
+func (s *Storage) GetFile(p string) (io.ReadCloser, error) {
+	return s.GetFileContext(context.Background(), p)
+}
+
+// GetFileContext is the context-aware version of GetFile. ctx also governs
+// reads from the returned io.ReadCloser.
+func (s *Storage) GetFileContext(ctx context.Context, p string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, s.error("get", p, err)
+	}
+	e, err := s.lookup(p)
+	if err != nil {
+		return nil, s.error("get", p, err)
+	}
+	if e.zf == nil {
+		return ioutil.NopCloser(util.ContextReader(ctx, io.NewSectionReader(s.file, e.offset, e.info.Size))), nil
+	}
+	rc, err := e.zf.Open()
+	if err != nil {
+		return nil, s.error("get", p, err)
+	}
+	return &readCloser{Reader: util.ContextReader(ctx, rc), Closer: rc}, nil
+}
+
+// GetFileRange returns length bytes of the file on path starting at offset,
+// or the rest of the file if length is negative. Ranges of tar entries and
+// uncompressed zip entries are read directly; compressed zip entries are
+// decompressed up to offset.
+func (s *Storage) GetFileRange(p string, offset, length int64) (io.ReadCloser, error) {
+	e, err := s.lookup(p)
+	if err != nil {
+		return nil, s.error("get", p, err)
+	}
+	offset, length, err = util.ClampRange(e.info.Size, offset, length)
+	if err != nil {
+		return nil, s.error("get", p, err)
+	}
+	switch {
+	case e.zf == nil:
+		return ioutil.NopCloser(io.NewSectionReader(s.file, e.offset+offset, length)), nil
+	case e.zf.Method == zip.Store:
+		start, err := e.zf.DataOffset()
+		if err != nil {
+			return nil, s.error("get", p, err)
+		}
+		return ioutil.NopCloser(io.NewSectionReader(s.file, start+offset, length)), nil
+	}
+	rc, err := e.zf.Open()
+	if err != nil {
+		return nil, s.error("get", p, err)
+	}
+	if _, err := io.CopyN(ioutil.Discard, rc, offset); err != nil {
+		rc.Close()
+		return nil, s.error("get", p, err)
+	}
+	return &readCloser{Reader: io.LimitReader(rc, length), Closer: rc}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// info returns a copy of the FileInfo of e, for path p.
+func info(p string, e *entry) *storage.FileInfo {
+	fi := *e.info
+	fi.Path = p
+	fi.ContentType = storage.ResolveContentType(p)
+	return &fi
+}
+
+// Stat returns the FileInfo of the file on path, from the archive headers.
+// The ETag of zip entries is their CRC-32.
+func (s *Storage) Stat(p string) (*storage.FileInfo, error) {
+	e, err := s.lookup(p)
+	if err != nil {
+		return nil, s.error("stat", p, err)
+	}
+	return info(clean(p), e), nil
+}
+
+// Exists reports whether there is a file on path.
+func (s *Storage) Exists(p string) (bool, error) {
+	_, err := s.Stat(p)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// List returns a page of the files under prefix, out of the entries of
+// the archive.
+func (s *Storage) List(prefix string, opts *storage.ListOptions) (*storage.ListPage, error) {
+	return util.ListFromWalk(s, prefix, opts)
+}
+
+// Walk calls fn for every file under prefix, in lexical order.
+func (s *Storage) Walk(prefix string, fn storage.WalkFunc) error {
+	if s.w != nil {
+		return s.error("walk", prefix, errWriteMode)
+	}
+	for i := sort.SearchStrings(s.names, prefix); i < len(s.names); i++ {
+		name := s.names[i]
+		if !strings.HasPrefix(name, prefix) {
+			break
+		}
+		if err := fn(info(name, s.entries[name])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
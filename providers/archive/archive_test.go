@@ -0,0 +1,263 @@
+package archive
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/djangulo/go-storage"
+	storagetest "github.com/djangulo/go-storage/testing"
+)
+
+var files = map[string]string{
+	"a.txt":          "hello world",
+	"img/b.svg":      "<svg></svg>",
+	"img/deep/c.svg": "<svg>deep</svg>",
+}
+
+func TestArchive(t *testing.T) {
+	for _, tt := range []struct{ format, ext string }{
+		{"zip", ".zip"},
+		{"tar", ".tar"},
+		{"tar+gz", ".tar.gz"},
+	} {
+		t.Run(tt.format, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "bundles", "bundle"+tt.ext)
+			base := tt.format + "://" + filepath.ToSlash(file) + "?accept=.txt,.svg"
+
+			w, err := storage.Open(base + "&mode=write")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for p, content := range files {
+				if _, err := w.AddFile(strings.NewReader(content), p); err != nil {
+					t.Fatal(err)
+				}
+			}
+			t.Run("add existing", func(t *testing.T) {
+				_, err := w.AddFile(strings.NewReader("again"), "a.txt")
+				if !errors.Is(err, storage.ErrAlreadyExists) {
+					t.Errorf("expected %v got %v", storage.ErrAlreadyExists, err)
+				}
+			})
+			t.Run("cancelled add", func(t *testing.T) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				_, err := w.(storage.ContextDriver).AddFileContext(ctx, strings.NewReader("hello"), "cancelled.txt")
+				if !errors.Is(err, context.Canceled) {
+					t.Errorf("expected %v got %v", context.Canceled, err)
+				}
+			})
+			t.Run("get while writing", func(t *testing.T) {
+				_, err := w.GetFile("a.txt")
+				if !errors.Is(err, storage.ErrNotSupported) {
+					t.Errorf("expected %v got %v", storage.ErrNotSupported, err)
+				}
+			})
+			t.Run("written on close", func(t *testing.T) {
+				if _, err := os.Stat(file); !os.IsNotExist(err) {
+					t.Errorf("expected no archive before Close, got %v", err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := os.Stat(file); err != nil {
+					t.Fatal(err)
+				}
+				matches, _ := filepath.Glob(filepath.Join(filepath.Dir(file), tempPattern))
+				if len(matches) != 0 {
+					t.Errorf("expected no temporary files got %v", matches)
+				}
+				if _, err := w.AddFile(strings.NewReader("late"), "late.txt"); err == nil {
+					t.Error("expected an error")
+				}
+			})
+
+			drv, err := storage.Open(base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer drv.Close()
+			storagetest.TestReadOnly(t, drv, files)
+
+			t.Run("list", func(t *testing.T) {
+				page, err := drv.(storage.ListDriver).List("img/", &storage.ListOptions{Delimiter: "/"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(page.Files) != 1 || page.Files[0].Path != "img/b.svg" {
+					t.Errorf("expected img/b.svg got %+v", page.Files)
+				}
+				if !reflect.DeepEqual(page.Prefixes, []string{"img/deep/"}) {
+					t.Errorf("expected [img/deep/] got %v", page.Prefixes)
+				}
+			})
+			t.Run("cancelled add not stored", func(t *testing.T) {
+				if ok, err := storage.Exists(drv, "cancelled.txt"); ok || err != nil {
+					t.Errorf("expected false, <nil> got %v, %v", ok, err)
+				}
+			})
+			t.Run("path", func(t *testing.T) {
+				want := tt.format + "://" + filepath.ToSlash(file) + "/img/b.svg"
+				if got := drv.NormalizePath("img/b.svg"); got != want {
+					t.Errorf("expected %q got %q", want, got)
+				}
+			})
+		})
+	}
+}
+
+func TestZip(t *testing.T) {
+	// an archive built elsewhere, with directories and stored entries
+	file := filepath.Join(t.TempDir(), "bundle.zip")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for _, e := range []struct {
+		name   string
+		method uint16
+	}{
+		{"./assets/", zip.Store},
+		{"./assets/stored.txt", zip.Store},
+		{"./assets/deflated.txt", zip.Deflate},
+	} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: e.name, Method: e.method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(e.name, "/") {
+			fmt.Fprint(w, "0123456789")
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	drv, err := storage.Open("zip://" + filepath.ToSlash(file) + "?accept=.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer drv.Close()
+
+	t.Run("directories", func(t *testing.T) {
+		var got []string
+		storage.Walk(drv, "", func(fi *storage.FileInfo) error {
+			got = append(got, fi.Path)
+			return nil
+		})
+		want := []string{"assets/deflated.txt", "assets/stored.txt"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("expected %v got %v", want, got)
+		}
+	})
+	for _, p := range []string{"assets/stored.txt", "assets/deflated.txt"} {
+		for _, rng := range []struct {
+			offset, length int64
+			want           string
+		}{
+			{0, -1, "0123456789"},
+			{3, 4, "3456"},
+			{7, 10, "789"},
+			{1, math.MaxInt64, "123456789"},
+			{20, -1, ""},
+		} {
+			t.Run(fmt.Sprintf("range %s %d %d", p, rng.offset, rng.length), func(t *testing.T) {
+				rc, err := drv.(storage.RangeDriver).GetFileRange(p, rng.offset, rng.length)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer rc.Close()
+				b, err := ioutil.ReadAll(rc)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(b) != rng.want {
+					t.Errorf("expected %q got %q", rng.want, string(b))
+				}
+			})
+		}
+	}
+}
+
+func TestTarGzClose(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	w, err := storage.Open("tar+gz://" + filepath.ToSlash(file) + "?mode=write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	drv, err := storage.Open("tar+gz://" + filepath.ToSlash(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp := drv.(*Storage).tmp
+	if _, err := os.Stat(tmp); err != nil {
+		t.Fatalf("expected a decompressed copy, got %v", err)
+	}
+	if err := drv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("expected Close to remove the decompressed copy, got %v", err)
+	}
+}
+
+func TestParseURL(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want *Config
+		err  error
+	}{
+		{
+			"zip:///srv/bundles/bundle.zip?accept=.txt",
+			&Config{
+				Format: "zip",
+				File:   filepath.FromSlash("/srv/bundles/bundle.zip"),
+				accept: map[string]struct{}{".txt": {}},
+			},
+			nil,
+		},
+		{
+			"tar+gz://./bundle.tar.gz?mode=write",
+			&Config{
+				Format: "tar+gz",
+				File:   filepath.FromSlash("./bundle.tar.gz"),
+				Write:  true,
+				accept: map[string]struct{}{".jpeg": {}, ".jpg": {}, ".png": {}, ".svg": {}},
+			},
+			nil,
+		},
+		{"tar:///bundle.tar?mode=append", nil, ErrURLParse},
+		{"zip://", nil, ErrURLParse},
+		{"rar:///bundle.rar", nil, ErrURLParse},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseURL(tt.in)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("expected %v got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nexpected\t%+v\ngot\t\t%+v", tt.want, got)
+			}
+		})
+	}
+}
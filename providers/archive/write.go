@@ -0,0 +1,149 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/djangulo/go-storage"
+	"github.com/djangulo/go-storage/internal/util"
+)
+
+// tempPattern names the temporary file an archive is written to, until
+// Close moves it to its path.
+const tempPattern = ".go-storage-*.tmp"
+
+// builder writes a new archive.
+type builder struct {
+	file *os.File
+	zw   *zip.Writer
+	tw   *tar.Writer
+	gz   *gzip.Writer
+	// names of the files added.
+	names map[string]struct{}
+	// err is the first failed write to the archive, which leaves it
+	// corrupt.
+	err    error
+	closed bool
+}
+
+func newBuilder(c *Config) (*builder, error) {
+	dir := filepath.Dir(c.File)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	file, err := ioutil.TempFile(dir, tempPattern)
+	if err != nil {
+		return nil, err
+	}
+	// TempFile creates files readable only by the owner
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	b := &builder{file: file, names: make(map[string]struct{})}
+	switch c.Format {
+	case "zip":
+		b.zw = zip.NewWriter(file)
+	case "tar+gz":
+		b.gz = gzip.NewWriter(file)
+		b.tw = tar.NewWriter(b.gz)
+	default:
+		b.tw = tar.NewWriter(file)
+	}
+	return b, nil
+}
+
+// add writes the contents of r to the archive as name. r is spooled to a
+// temporary file first, as tar headers hold the size of the file.
+func (b *builder) add(ctx context.Context, r io.Reader, name string) error {
+	switch {
+	case b.closed:
+		return os.ErrClosed
+	case b.err != nil:
+		return b.err
+	}
+	if _, ok := b.names[name]; ok {
+		return storage.ErrAlreadyExists
+	}
+
+	spool, err := ioutil.TempFile("", "go-storage-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}()
+	size, err := io.Copy(spool, util.ContextReader(ctx, r))
+	if err != nil {
+		return err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if b.zw != nil {
+		var w io.Writer
+		w, err = b.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+		if err == nil {
+			_, err = io.Copy(w, spool)
+		}
+	} else {
+		err = b.tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     size,
+			Mode:     0644,
+			ModTime:  now,
+		})
+		if err == nil {
+			_, err = io.Copy(b.tw, spool)
+		}
+	}
+	if err != nil {
+		b.err = err
+		return err
+	}
+	b.names[name] = struct{}{}
+	return nil
+}
+
+// close finalizes the archive, and moves it to dst. A corrupt archive is
+// discarded. Closing twice is a noop.
+func (b *builder) close(dst string) error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	err := b.err
+	if err == nil {
+		if b.zw != nil {
+			err = b.zw.Close()
+		} else if err = b.tw.Close(); err == nil && b.gz != nil {
+			err = b.gz.Close()
+		}
+	}
+	if err == nil {
+		err = b.file.Sync()
+	}
+	if cerr := b.file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(b.file.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(b.file.Name())
+		return err
+	}
+	return nil
+}
@@ -1,29 +1,24 @@
 package awss3
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io"
 	"net/url"
-	"path"
-	"path/filepath"
 	"regexp"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3iface"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 
 	"github.com/djangulo/go-storage"
 	"github.com/djangulo/go-storage/internal/util"
 )
 
 type S3Storage struct {
-	session *session.Session
-	client  s3iface.S3API
-	config  *Config
+	*util.Bucket
+	config *Config
 }
 
 type Config struct {
@@ -35,6 +30,20 @@ type Config struct {
 	accept           map[string]struct{}
 }
 
+// compile-time checks of the optional interfaces S3Storage implements.
+var (
+	_ storage.DangerDriver  = (*S3Storage)(nil)
+	_ storage.ContextDriver = (*S3Storage)(nil)
+	_ storage.StatDriver    = (*S3Storage)(nil)
+	_ storage.PutDriver     = (*S3Storage)(nil)
+	_ storage.CopyDriver    = (*S3Storage)(nil)
+	_ storage.ListDriver    = (*S3Storage)(nil)
+	_ storage.WalkDriver    = (*S3Storage)(nil)
+	_ storage.RangeDriver   = (*S3Storage)(nil)
+	_ storage.WriterDriver  = (*S3Storage)(nil)
+	_ storage.PresignDriver = (*S3Storage)(nil)
+)
+
 func init() {
 	storage.Register("awss3", &S3Storage{})
 }
@@ -48,14 +57,8 @@ func (s *S3Storage) Path() string {
 	)
 }
 
-func (s *S3Storage) Accepts(ext string) (accepts bool) {
-	_, accepts = s.config.accept[ext]
-	return
-}
-
 func (s *S3Storage) NormalizePath(entries ...string) string {
-	entries = append([]string{s.Path()}, entries...)
-	return path.Join(entries...)
+	return util.JoinURL(s.Path(), entries...)
 }
 
 var (
@@ -65,7 +68,7 @@ var (
 		"public-read":               {},
 		"public-read-write":         {},
 		"aws-exec-read":             {},
-		"authenticated-read ":       {},
+		"authenticated-read":        {},
 		"bucket-owner-read":         {},
 		"bucket-owner-full-control": {},
 		"log-delivery-write":        {},
@@ -78,7 +81,7 @@ var (
 		"none":    {},
 		"off":     {},
 	}
-	ErrURLParse = errors.New("awss3: error parsing url")
+	ErrURLParse = errors.New("error parsing url")
 )
 
 func parseURL(urlString string) (*Config, error) {
@@ -158,6 +161,12 @@ func parseURL(urlString string) (*Config, error) {
 //     https://docs.aws.amazon.com/AmazonS3/latest/dev/acl-overview.html#CannedACL
 //     for details. Default "public-read".
 func (s *S3Storage) Open(urlString string) (storage.Driver, error) {
+	return s.OpenContext(context.Background(), urlString)
+}
+
+// OpenContext is the context-aware version of Open. ctx governs the bucket
+// lookup and creation.
+func (s *S3Storage) OpenContext(ctx context.Context, urlString string) (storage.Driver, error) {
 	var err error
 
 	// create a new object, return as many instances as need be
@@ -165,112 +174,53 @@ func (s *S3Storage) Open(urlString string) (storage.Driver, error) {
 
 	ns.config, err = parseURL(urlString)
 	if err != nil {
-		return nil, err
+		return nil, s.error("open", "", err)
+	}
+	sess, err := session.NewSession(&aws.Config{Region: &ns.config.Region})
+	if err != nil {
+		return nil, s.error("open", "", err)
 	}
-	ns.session, err = session.NewSession(&aws.Config{Region: &ns.config.Region})
-	ns.client = s3.New(ns.session)
+	client := s3.New(sess)
 
-	var exists = util.BucketExists(ns.client, ns.config.Bucket)
+	var exists = util.BucketExists(ctx, client, ns.config.Bucket)
 	if !exists && !ns.config.AutoBucketCreate {
-		return nil, fmt.Errorf("awss3: bucket does not exist; AutoBucketCreation is off")
+		return nil, s.error("open", "", errors.New("bucket does not exist; AutoBucketCreation is off"))
 	}
 	if !exists && ns.config.AutoBucketCreate {
-		_, err := ns.client.CreateBucket(&s3.CreateBucketInput{
+		_, err := client.CreateBucketWithContext(ctx, &s3.CreateBucketInput{
 			Bucket: &ns.config.Bucket,
 			CreateBucketConfiguration: &s3.CreateBucketConfiguration{
 				LocationConstraint: &ns.config.Region,
 			},
 		})
 		if err != nil {
-			return nil, err
+			return nil, s.error("open", "", err)
 		}
 	}
 
-	return ns, nil
-}
-
-// Close noop
-func (s *S3Storage) Close() error {
-	// allow itself to be garbage collected
-	s = nil
-	return nil
-}
-
-func (s *S3Storage) AddFile(r io.Reader, p string) (string, error) {
-	if ext := filepath.Ext(p); !s.Accepts(ext) {
-		return "", fmt.Errorf("%w %s", storage.ErrInvalidExtension, ext)
-	}
-	key := path.Join(s.config.Prefix, p)
-	_, err := s.client.HeadObject(&s3.HeadObjectInput{
-		Bucket: &s.config.Bucket,
-		Key:    &key,
-	})
-	if err == nil {
-		// file exists
-		return "", fmt.Errorf("%w at %s", storage.ErrAlreadyExists, key)
-	}
-	uploader := s3manager.NewUploader(s.session)
-	_, err = uploader.Upload(&s3manager.UploadInput{
-		Bucket:      &s.config.Bucket,
-		Key:         &key,
-		Body:        r,
-		ACL:         &s.config.FileACL,
-		ContentType: aws.String(storage.ResolveContentType(p)),
-	})
-	if err != nil {
-		return "", err
+	ns.Bucket = &util.Bucket{
+		Driver:      "awss3",
+		Session:     sess,
+		Client:      client,
+		Name:        ns.config.Bucket,
+		Prefix:      ns.config.Prefix,
+		FileACL:     ns.config.FileACL,
+		ACLs:        acceptableACL,
+		Conditional: true,
+		URL:         ns.Path(),
+		Accept:      ns.config.accept,
 	}
 
-	return path.Join(s.Path(), p), nil
+	return ns, nil
 }
 
-func (s *S3Storage) RemoveFile(p string) error {
-	key := path.Join(s.config.Prefix, p)
-	_, err := s.client.DeleteObject(&s3.DeleteObjectInput{
-		Bucket: &s.config.Bucket,
-		Key:    &key,
-	})
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func (s *S3Storage) GetFile(p string) (io.ReadCloser, error) {
-	key := path.Join(s.config.Prefix, p)
-	file, err := s.client.GetObject(&s3.GetObjectInput{
-		Bucket: &s.config.Bucket,
-		Key:    &key,
-	})
-	if err != nil {
-		return nil, err
-	}
-	return file.Body, nil
+func (s *S3Storage) error(op, p string, err error) error {
+	return &storage.Error{Op: op, Driver: "awss3", Path: p, Err: util.AWSError(err)}
 }
 
+// EmtpyContainer is EmptyContainer.
+//
+// Deprecated: use EmptyContainer, which satisfies storage.DangerDriver.
 func (s *S3Storage) EmtpyContainer() error {
-	iter := s3manager.NewDeleteListIterator(s.client, &s3.ListObjectsInput{
-		Bucket: &s.config.Bucket,
-	})
-	if err := s3manager.NewBatchDeleteWithClient(s.client).Delete(aws.BackgroundContext(), iter); err != nil {
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-func (s *S3Storage) DeleteContainer() (err error) {
-	err = s.EmtpyContainer()
-	if err != nil {
-		return err
-	}
-	_, err = s.client.DeleteBucket(&s3.DeleteBucketInput{
-		Bucket: &s.config.Bucket,
-	})
-	if err != nil {
-		return err
-	}
-	return nil
+	return s.EmptyContainer()
 }
@@ -93,3 +93,11 @@ func TestParseURL(t *testing.T) {
 		})
 	}
 }
+
+func TestNormalizePath(t *testing.T) {
+	s := &S3Storage{config: &Config{Bucket: "bucket", Region: "us-east-2", Prefix: "/assets"}}
+	want := "https://bucket.s3.us-east-2.amazonaws.com/assets/a/b.txt"
+	if got := s.NormalizePath("a", "b.txt"); got != want {
+		t.Errorf("expected %q got %q", want, got)
+	}
+}
@@ -0,0 +1,670 @@
+// Package azblob implements a storage.Driver for Azure Blob Storage.
+package azblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	azblobsdk "github.com/Azure/azure-storage-blob-go/azblob"
+
+	"github.com/djangulo/go-storage"
+	"github.com/djangulo/go-storage/internal/util"
+)
+
+// Storage is a storage.Driver for an Azure Blob Storage container.
+type Storage struct {
+	container azblobsdk.ContainerURL
+	config    *Config
+}
+
+type Config struct {
+	AutoContainerCreate bool
+	Account             string
+	Container           string
+	Prefix              string
+	// Endpoint is the URL of the blob service of the account. Default
+	// https://<account>.blob.core.windows.net.
+	Endpoint string
+	// Tier is the access tier of uploaded blobs: Hot, Cool or Archive.
+	// Default none, which applies the account default.
+	Tier   azblobsdk.AccessTierType
+	key    string
+	sas    string
+	accept map[string]struct{}
+}
+
+// compile-time checks of the optional interfaces Storage implements.
+var (
+	_ storage.DangerDriver  = (*Storage)(nil)
+	_ storage.ContextDriver = (*Storage)(nil)
+	_ storage.StatDriver    = (*Storage)(nil)
+	_ storage.PutDriver     = (*Storage)(nil)
+	_ storage.CopyDriver    = (*Storage)(nil)
+	_ storage.ListDriver    = (*Storage)(nil)
+	_ storage.WalkDriver    = (*Storage)(nil)
+	_ storage.RangeDriver   = (*Storage)(nil)
+	_ storage.WriterDriver  = (*Storage)(nil)
+)
+
+func init() {
+	storage.Register("azblob", &Storage{})
+}
+
+// Path returns the URL of the prefix.
+func (s *Storage) Path() string {
+	return util.JoinURL(s.config.Endpoint, s.config.Container, s.config.Prefix)
+}
+
+func (s *Storage) Accepts(ext string) (accepts bool) {
+	_, accepts = s.config.accept[ext]
+	return
+}
+
+func (s *Storage) NormalizePath(entries ...string) string {
+	return util.JoinURL(s.Path(), entries...)
+}
+
+var (
+	acceptableTier = map[string]azblobsdk.AccessTierType{
+		"hot":     azblobsdk.AccessTierHot,
+		"cool":    azblobsdk.AccessTierCool,
+		"archive": azblobsdk.AccessTierArchive,
+	}
+	acceptableAutoCreate = map[string]struct{}{
+		"0":       {},
+		"false":   {},
+		"nil":     {},
+		"disable": {},
+		"none":    {},
+		"off":     {},
+	}
+	ErrURLParse = errors.New("error parsing url")
+)
+
+func parseURL(urlString string) (*Config, error) {
+	u, err := url.Parse(urlString)
+	if err != nil {
+		// *url.Error would print the url, key included
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrURLParse, err)
+	}
+	parts := strings.SplitN(strings.Trim(u.Path, "/"), "/", 2)
+	if u.Scheme != "azblob" || u.Host == "" || parts[0] == "" {
+		return nil, fmt.Errorf(
+			"%w: %s://%s%s does not match \"azblob://account/container/prefix\" format",
+			ErrURLParse,
+			u.Scheme,
+			u.Host,
+			u.Path,
+		)
+	}
+	c := &Config{
+		AutoContainerCreate: true,
+		Account:             u.Host,
+		Container:           parts[0],
+		Prefix:              "/",
+		Endpoint:            fmt.Sprintf("https://%s.blob.core.windows.net", u.Host),
+	}
+	if len(parts) == 2 {
+		c.Prefix += parts[1]
+	}
+
+	q := u.Query()
+	if endpoint := q.Get("endpoint"); endpoint != "" {
+		if e, err := url.Parse(endpoint); err != nil || e.Scheme == "" || e.Host == "" {
+			return nil, fmt.Errorf("%w: invalid endpoint: %s", ErrURLParse, endpoint)
+		}
+		c.Endpoint = strings.TrimSuffix(endpoint, "/")
+	}
+	if tier := q.Get("tier"); tier != "" {
+		t, ok := acceptableTier[strings.ToLower(tier)]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown tier: %s", ErrURLParse, tier)
+		}
+		c.Tier = t
+	}
+	if ac := q.Get("auto-create"); ac != "" {
+		ac = strings.ToLower(ac)
+		if _, ok := acceptableAutoCreate[ac]; ok {
+			c.AutoContainerCreate = false
+		} else {
+			return nil, fmt.Errorf("%w: unknown auto-create value: %s", ErrURLParse, ac)
+		}
+	}
+	c.key = q.Get("key")
+	if c.key == "" {
+		c.key = os.Getenv("AZURE_STORAGE_KEY")
+	}
+	c.sas = strings.TrimPrefix(q.Get("sas"), "?")
+	if c.sas == "" {
+		c.sas = strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?")
+	}
+	if c.sas != "" {
+		if _, err := url.ParseQuery(c.sas); err != nil {
+			return nil, fmt.Errorf("%w: invalid sas token", ErrURLParse)
+		}
+	}
+	c.accept = util.ParseCommaSeparatedQuery(q, "accept", ".jpeg", ".jpg", ".png", ".svg")
+	return c, nil
+}
+
+// Open creates a *Storage. The urlString should be in the form
+// azblob://account/container/prefix?key=&sas=&endpoint=&tier=&accept=&auto-create=
+// The account is authenticated with a shared key, or with a SAS token. Without
+// either, they are read from the AZURE_STORAGE_KEY and AZURE_STORAGE_SAS_TOKEN
+// environment variables, and failing that, requests are anonymous, which
+// only works for reading public containers.
+// The URL parameters accepted are as follows:
+//   - key: base64-encoded shared key of the account.
+//   - sas: URL-encoded SAS token, with or without the leading "?".
+//   - endpoint: URL of the blob service, e.g.
+//     http://127.0.0.1:10000/devstoreaccount1 for a local Azurite. Default
+//     https://<account>.blob.core.windows.net.
+//   - tier: access tier of uploaded blobs, one of hot, cool or archive.
+//     Default none, which applies the default tier of the account.
+//   - accept: comma-separated list of file extensions to accept. Could be
+//     repeated. e.g. url://account/container/prefix?accept=.jpeg,.svg&accept=.png
+//     would accept .jpeg, .svg and .png files. Default .jpeg,.jpg,.png,.svg
+//   - auto-create: will NOT create the container automatically if this value
+//     is any of: 0, off, disable, false.
+func (s *Storage) Open(urlString string) (storage.Driver, error) {
+	return s.OpenContext(context.Background(), urlString)
+}
+
+// OpenContext is the context-aware version of Open. ctx governs the
+// container lookup and creation.
+func (s *Storage) OpenContext(ctx context.Context, urlString string) (storage.Driver, error) {
+	var err error
+
+	ns := new(Storage)
+
+	ns.config, err = parseURL(urlString)
+	if err != nil {
+		return nil, s.error("open", "", err)
+	}
+	var credential azblobsdk.Credential = azblobsdk.NewAnonymousCredential()
+	if ns.config.key != "" {
+		credential, err = azblobsdk.NewSharedKeyCredential(ns.config.Account, ns.config.key)
+		if err != nil {
+			return nil, s.error("open", "", err)
+		}
+	}
+	u, _ := url.Parse(util.JoinURL(ns.config.Endpoint, ns.config.Container))
+	u.RawQuery = ns.config.sas
+	ns.container = azblobsdk.NewContainerURL(*u, azblobsdk.NewPipeline(credential, azblobsdk.PipelineOptions{}))
+
+	_, err = ns.container.GetProperties(ctx, azblobsdk.LeaseAccessConditions{})
+	if isServiceCode(err, azblobsdk.ServiceCodeContainerNotFound) {
+		if !ns.config.AutoContainerCreate {
+			return nil, s.error("open", "", errors.New("container does not exist; AutoContainerCreate is off"))
+		}
+		_, err = ns.container.Create(ctx, nil, azblobsdk.PublicAccessNone)
+	}
+	if err != nil {
+		return nil, s.error("open", "", err)
+	}
+
+	return ns, nil
+}
+
+// key returns the blob name of p, which has no leading slash.
+func (s *Storage) key(p string) string {
+	return strings.TrimPrefix(path.Join(s.config.Prefix, p), "/")
+}
+
+// root is the name prefix of the blobs of s.
+func (s *Storage) root() string {
+	return strings.TrimPrefix(s.config.Prefix, "/")
+}
+
+func (s *Storage) blob(p string) azblobsdk.BlockBlobURL {
+	return s.container.NewBlockBlobURL(s.key(p))
+}
+
+func (s *Storage) error(op, p string, err error) error {
+	return &storage.Error{Op: op, Driver: "azblob", Path: p, Err: azError(err)}
+}
+
+// Close noop
+func (s *Storage) Close() error {
+	return nil
+}
+
+func (s *Storage) AddFile(r io.Reader, p string) (string, error) {
+	return s.addFile(context.Background(), r, p, nil)
+}
+
+// AddFileContext is the context-aware version of AddFile. Cancelling ctx
+// aborts the upload.
+func (s *Storage) AddFileContext(ctx context.Context, r io.Reader, p string) (string, error) {
+	return s.addFile(ctx, r, p, nil)
+}
+
+// AddFileWithOptions is AddFile, configured through opts. The write mode is
+// sent as If-None-Match/If-Match headers, which Azure enforces atomically.
+// Azure has no per-blob ACLs, so opts.ACL is ignored.
+func (s *Storage) AddFileWithOptions(r io.Reader, p string, opts *storage.PutOptions) (string, error) {
+	return s.addFile(context.Background(), r, p, opts)
+}
+
+func (s *Storage) addFile(ctx context.Context, r io.Reader, p string, opts *storage.PutOptions) (string, error) {
+	if opts == nil {
+		opts = &storage.PutOptions{}
+	}
+	if ext := filepath.Ext(p); !s.Accepts(ext) {
+		return "", s.error("add", p, fmt.Errorf("%w %s", storage.ErrInvalidExtension, ext))
+	}
+	var metadata azblobsdk.Metadata
+	if len(opts.Metadata) > 0 {
+		metadata = make(azblobsdk.Metadata, len(opts.Metadata))
+		for k, v := range opts.Metadata {
+			metadata[strings.ToLower(k)] = v
+		}
+	}
+	_, err := azblobsdk.UploadStreamToBlockBlob(ctx, util.ContextReader(ctx, r), s.blob(p), azblobsdk.UploadStreamToBlockBlobOptions{
+		BlobHTTPHeaders: azblobsdk.BlobHTTPHeaders{
+			ContentType:        opts.ResolveContentType(p),
+			CacheControl:       opts.CacheControl,
+			ContentDisposition: opts.ContentDisposition,
+			ContentEncoding:    opts.ContentEncoding,
+		},
+		Metadata:         metadata,
+		AccessConditions: accessConditions(opts.Mode),
+		BlobAccessTier:   s.config.Tier,
+	})
+	if err != nil {
+		return "", s.error("add", p, writeError(err, opts.Mode))
+	}
+	return s.NormalizePath(p), nil
+}
+
+// accessConditions returns the conditions that enforce mode.
+func accessConditions(mode storage.WriteMode) azblobsdk.BlobAccessConditions {
+	var ac azblobsdk.BlobAccessConditions
+	switch {
+	case mode.Overwrites():
+	case mode.ETag() != "":
+		ac.IfMatch = azblobsdk.ETag(`"` + mode.ETag() + `"`)
+	default:
+		ac.IfNoneMatch = azblobsdk.ETagAny
+	}
+	return ac
+}
+
+// writeError maps the errors of a conditional write with mode to the error
+// of its write mode.
+func writeError(err error, mode storage.WriteMode) error {
+	err = azError(err)
+	switch {
+	case mode.Overwrites():
+		return err
+	case mode.ETag() != "":
+		// there is no blob to match against
+		if errors.Is(err, storage.ErrNotFound) {
+			return fmt.Errorf("%w: %v", storage.ErrPreconditionFailed, err)
+		}
+		return err
+	case errors.Is(err, storage.ErrPreconditionFailed):
+		return storage.ErrAlreadyExists
+	default:
+		return err
+	}
+}
+
+// OpenWriter returns a storage.Writer that feeds a block blob upload through
+// a pipe. Blocks are staged as they are written, but the blob is only
+// committed on Close; CloseWithError aborts the upload, and Azure discards
+// the staged blocks.
+func (s *Storage) OpenWriter(p string, opts *storage.PutOptions) (storage.Writer, error) {
+	if ext := filepath.Ext(p); !s.Accepts(ext) {
+		return nil, s.error("add", p, fmt.Errorf("%w %s", storage.ErrInvalidExtension, ext))
+	}
+	return storage.NewPipeWriter(func(r io.Reader) error {
+		_, err := s.addFile(context.Background(), r, p, opts)
+		return err
+	}), nil
+}
+
+func (s *Storage) RemoveFile(p string) error {
+	return s.RemoveFileContext(context.Background(), p)
+}
+
+// RemoveFileContext is the context-aware version of RemoveFile. Snapshots of
+// the blob are removed along with it.
+func (s *Storage) RemoveFileContext(ctx context.Context, p string) error {
+	_, err := s.blob(p).Delete(ctx, azblobsdk.DeleteSnapshotsOptionInclude, azblobsdk.BlobAccessConditions{})
+	if err != nil && !isServiceCode(err, azblobsdk.ServiceCodeBlobNotFound) {
+		return s.error("remove", p, err)
+	}
+	return nil
+}
+
+// Stat returns the FileInfo of the blob on p, through a Get Blob Properties
+// call.
+func (s *Storage) Stat(p string) (*storage.FileInfo, error) {
+	props, err := s.blob(p).GetProperties(context.Background(), azblobsdk.BlobAccessConditions{}, azblobsdk.ClientProvidedKeyOptions{})
+	if err != nil {
+		return nil, s.error("stat", p, err)
+	}
+	fi := &storage.FileInfo{
+		Path:               p,
+		Size:               props.ContentLength(),
+		ModTime:            props.LastModified(),
+		ContentType:        props.ContentType(),
+		CacheControl:       props.CacheControl(),
+		ContentDisposition: props.ContentDisposition(),
+		ContentEncoding:    props.ContentEncoding(),
+		ETag:               strings.Trim(string(props.ETag()), `"`),
+	}
+	if md := props.NewMetadata(); len(md) > 0 {
+		fi.Metadata = make(map[string]string, len(md))
+		for k, v := range md {
+			fi.Metadata[strings.ToLower(k)] = v
+		}
+	}
+	return fi, nil
+}
+
+// Exists reports whether there is a blob on p.
+func (s *Storage) Exists(p string) (bool, error) {
+	_, err := s.Stat(p)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// List returns a page of the blobs under prefix, through List Blobs.
+func (s *Storage) List(prefix string, opts *storage.ListOptions) (*storage.ListPage, error) {
+	if opts == nil {
+		opts = &storage.ListOptions{}
+	}
+	var (
+		ctx     = context.Background()
+		marker  = azblobsdk.Marker{}
+		options = azblobsdk.ListBlobsSegmentOptions{
+			Prefix:     util.ObjectKey(s.root(), prefix),
+			MaxResults: int32(opts.MaxResults),
+		}
+		page = &storage.ListPage{Files: make([]*storage.FileInfo, 0)}
+	)
+	if opts.Token != "" {
+		marker.Val = &opts.Token
+	}
+	if opts.Delimiter == "" {
+		out, err := s.container.ListBlobsFlatSegment(ctx, marker, options)
+		if err != nil {
+			return nil, s.error("list", prefix, err)
+		}
+		for i := range out.Segment.BlobItems {
+			page.Files = append(page.Files, s.blobInfo(&out.Segment.BlobItems[i]))
+		}
+		page.NextToken = markerValue(out.NextMarker)
+		return page, nil
+	}
+	out, err := s.container.ListBlobsHierarchySegment(ctx, marker, opts.Delimiter, options)
+	if err != nil {
+		return nil, s.error("list", prefix, err)
+	}
+	for i := range out.Segment.BlobItems {
+		page.Files = append(page.Files, s.blobInfo(&out.Segment.BlobItems[i]))
+	}
+	for _, bp := range out.Segment.BlobPrefixes {
+		page.Prefixes = append(page.Prefixes, relativePath(s.root(), bp.Name))
+	}
+	page.NextToken = markerValue(out.NextMarker)
+	return page, nil
+}
+
+// Walk calls fn for every blob under prefix, in lexicographical order.
+func (s *Storage) Walk(prefix string, fn storage.WalkFunc) error {
+	var (
+		ctx     = context.Background()
+		options = azblobsdk.ListBlobsSegmentOptions{Prefix: util.ObjectKey(s.root(), prefix)}
+	)
+	for marker := (azblobsdk.Marker{}); marker.NotDone(); {
+		out, err := s.container.ListBlobsFlatSegment(ctx, marker, options)
+		if err != nil {
+			return s.error("walk", prefix, err)
+		}
+		for i := range out.Segment.BlobItems {
+			if err := fn(s.blobInfo(&out.Segment.BlobItems[i])); err != nil {
+				return err
+			}
+		}
+		marker = out.NextMarker
+	}
+	return nil
+}
+
+func (s *Storage) blobInfo(item *azblobsdk.BlobItemInternal) *storage.FileInfo {
+	fi := &storage.FileInfo{
+		Path:    relativePath(s.root(), item.Name),
+		ModTime: item.Properties.LastModified,
+		ETag:    strings.Trim(string(item.Properties.Etag), `"`),
+	}
+	if item.Properties.ContentLength != nil {
+		fi.Size = *item.Properties.ContentLength
+	}
+	if item.Properties.ContentType != nil {
+		fi.ContentType = *item.Properties.ContentType
+	}
+	return fi
+}
+
+func relativePath(root, name string) string {
+	if root == "" {
+		return name
+	}
+	return strings.TrimPrefix(name, root+"/")
+}
+
+func markerValue(m azblobsdk.Marker) string {
+	if m.Val == nil {
+		return ""
+	}
+	return *m.Val
+}
+
+func (s *Storage) GetFile(p string) (io.ReadCloser, error) {
+	return s.GetFileContext(context.Background(), p)
+}
+
+// GetFileContext is the context-aware version of GetFile. ctx also governs
+// reads from the returned body.
+func (s *Storage) GetFileContext(ctx context.Context, p string) (io.ReadCloser, error) {
+	return s.download(ctx, "get", p, 0, azblobsdk.CountToEnd)
+}
+
+func (s *Storage) download(ctx context.Context, op, p string, offset, count int64) (io.ReadCloser, error) {
+	res, err := s.blob(p).Download(ctx, offset, count, azblobsdk.BlobAccessConditions{}, false, azblobsdk.ClientProvidedKeyOptions{})
+	if err != nil {
+		return nil, s.error(op, p, err)
+	}
+	return res.Body(azblobsdk.RetryReaderOptions{MaxRetryRequests: 3}), nil
+}
+
+// GetFileRange returns length bytes of the blob on p starting at offset, or
+// the rest of the blob if length is negative, through a ranged Get Blob.
+// Combined with Stat, it makes storage.GetFileSeeker work.
+func (s *Storage) GetFileRange(p string, offset, length int64) (io.ReadCloser, error) {
+	empty := ioutil.NopCloser(strings.NewReader(""))
+	if length == 0 {
+		// a zero count reads the whole blob
+		if _, err := s.Stat(p); err != nil {
+			var se *storage.Error
+			if errors.As(err, &se) {
+				se.Op = "get"
+			}
+			return nil, err
+		}
+		return empty, nil
+	}
+	if length < 0 {
+		length = azblobsdk.CountToEnd
+	}
+	rc, err := s.download(context.Background(), "get", p, offset, length)
+	if errors.Is(err, errInvalidRange) {
+		// offset is past the end of the blob
+		return empty, nil
+	}
+	return rc, err
+}
+
+// Copy copies the blob on src to dst, along with its properties and
+// metadata, through Copy Blob, without downloading it. It fails with
+// storage.ErrAlreadyExists if dst exists.
+func (s *Storage) Copy(src, dst string) error {
+	if ext := filepath.Ext(dst); !s.Accepts(ext) {
+		return s.error("copy", dst, fmt.Errorf("%w %s", storage.ErrInvalidExtension, ext))
+	}
+	ctx := context.Background()
+	if _, err := s.Stat(src); err != nil {
+		var se *storage.Error
+		if errors.As(err, &se) {
+			se.Op = "copy"
+		}
+		return err
+	}
+	dstBlob := s.blob(dst)
+	res, err := dstBlob.StartCopyFromURL(
+		ctx,
+		s.blob(src).URL(),
+		nil,
+		azblobsdk.ModifiedAccessConditions{},
+		azblobsdk.BlobAccessConditions{ModifiedAccessConditions: azblobsdk.ModifiedAccessConditions{IfNoneMatch: azblobsdk.ETagAny}},
+		s.config.Tier,
+		nil,
+	)
+	if err != nil {
+		return s.error("copy", dst, writeError(err, storage.CreateOnly))
+	}
+	// copies within an account are usually synchronous
+	for status := res.CopyStatus(); status == azblobsdk.CopyStatusPending; {
+		time.Sleep(100 * time.Millisecond)
+		props, err := dstBlob.GetProperties(ctx, azblobsdk.BlobAccessConditions{}, azblobsdk.ClientProvidedKeyOptions{})
+		if err != nil {
+			return s.error("copy", dst, err)
+		}
+		if status = props.CopyStatus(); status != azblobsdk.CopyStatusPending && status != azblobsdk.CopyStatusSuccess {
+			return s.error("copy", dst, fmt.Errorf("copy %s: %s", status, props.CopyStatusDescription()))
+		}
+	}
+	return nil
+}
+
+// Move is Copy, followed by removing src.
+func (s *Storage) Move(src, dst string) error {
+	if err := s.Copy(src, dst); err != nil {
+		return err
+	}
+	if err := s.RemoveFile(src); err != nil {
+		var se *storage.Error
+		if errors.As(err, &se) {
+			se.Op = "move"
+		}
+		return err
+	}
+	return nil
+}
+
+// EmptyContainer removes every blob in the container, including those
+// outside of the prefix.
+func (s *Storage) EmptyContainer() error {
+	ctx := context.Background()
+	for marker := (azblobsdk.Marker{}); marker.NotDone(); {
+		out, err := s.container.ListBlobsFlatSegment(ctx, marker, azblobsdk.ListBlobsSegmentOptions{})
+		if err != nil {
+			return s.error("empty", "", err)
+		}
+		for _, item := range out.Segment.BlobItems {
+			_, err := s.container.NewBlobURL(item.Name).Delete(ctx, azblobsdk.DeleteSnapshotsOptionInclude, azblobsdk.BlobAccessConditions{})
+			if err != nil && !isServiceCode(err, azblobsdk.ServiceCodeBlobNotFound) {
+				return s.error("empty", item.Name, err)
+			}
+		}
+		marker = out.NextMarker
+	}
+	return nil
+}
+
+// DeleteContainer deletes the container, along with its blobs. Azure does
+// not allow creating a container with the same name for a while after.
+func (s *Storage) DeleteContainer() error {
+	_, err := s.container.Delete(context.Background(), azblobsdk.ContainerAccessConditions{})
+	if err != nil {
+		return s.error("delete", "", err)
+	}
+	return nil
+}
+
+var errInvalidRange = errors.New("invalid range")
+
+func isServiceCode(err error, code azblobsdk.ServiceCodeType) bool {
+	var serr azblobsdk.StorageError
+	return errors.As(err, &serr) && serr.ServiceCode() == code
+}
+
+// azError wraps err so that it matches the sentinel errors of storage, and
+// reports the errors Azure asks to retry as retryable.
+func azError(err error) error {
+	var serr azblobsdk.StorageError
+	if !errors.As(err, &serr) {
+		return err
+	}
+	e := &apiError{err: err, code: serr.ServiceCode()}
+	if res := serr.Response(); res != nil {
+		e.status = res.StatusCode
+	}
+	switch {
+	case e.code == azblobsdk.ServiceCodeBlobAlreadyExists:
+		e.kind = storage.ErrAlreadyExists
+	case e.code == azblobsdk.ServiceCodeInvalidRange:
+		e.kind = errInvalidRange
+	case e.status == http.StatusNotFound:
+		e.kind = storage.ErrNotFound
+	case e.status == http.StatusPreconditionFailed:
+		e.kind = storage.ErrPreconditionFailed
+	}
+	return e
+}
+
+type apiError struct {
+	err    error
+	kind   error
+	code   azblobsdk.ServiceCodeType
+	status int
+}
+
+// Error is a one-line summary of the error, as the error of the SDK dumps
+// the whole request and response.
+func (e *apiError) Error() string {
+	if e.code == "" {
+		return fmt.Sprintf("%d %s", e.status, http.StatusText(e.status))
+	}
+	return fmt.Sprintf("%d %s", e.status, e.code)
+}
+
+func (e *apiError) Unwrap() error {
+	return e.err
+}
+
+func (e *apiError) Is(target error) bool {
+	return e.kind != nil && target == e.kind
+}
+
+func (e *apiError) Retryable() bool {
+	return e.status == http.StatusTooManyRequests || e.status >= 500
+}
@@ -0,0 +1,252 @@
+package azblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	azblobsdk "github.com/Azure/azure-storage-blob-go/azblob"
+
+	"github.com/djangulo/go-storage"
+	storagetest "github.com/djangulo/go-storage/testing"
+)
+
+// The well-known development account of the Azurite emulator.
+const (
+	azuriteAccount = "devstoreaccount1"
+	azuriteKey     = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+)
+
+// azurite returns the blob endpoint of an Azurite emulator, taken from
+// AZURITE_BLOB_ENDPOINT, and skips the test if it is not running. Start one
+// with:
+//
+//	docker run -p 10000:10000 mcr.microsoft.com/azure-storage/azurite azurite-blob --blobHost 0.0.0.0
+func azurite(t *testing.T) string {
+	t.Helper()
+	endpoint := os.Getenv("AZURITE_BLOB_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "http://127.0.0.1:10000/" + azuriteAccount
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.DialTimeout("tcp", u.Host, time.Second)
+	if err != nil {
+		t.Skipf("azurite is not running on %s: %v", u.Host, err)
+	}
+	conn.Close()
+	return endpoint
+}
+
+func TestAzblob(t *testing.T) {
+	endpoint := azurite(t)
+	base := "azblob://" + azuriteAccount + "/test-container/assets?accept=.txt&key=" +
+		url.QueryEscape(azuriteKey) + "&endpoint=" + url.QueryEscape(endpoint)
+
+	drv, err := storage.Open(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storagetest.Test(t, drv)
+
+	t.Run("path", func(t *testing.T) {
+		want := endpoint + "/test-container/assets/a.txt"
+		if got := drv.NormalizePath("a.txt"); got != want {
+			t.Errorf("expected %q got %q", want, got)
+		}
+	})
+	t.Run("tier", func(t *testing.T) {
+		drv, err := storage.Open(base + "&tier=cool")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := drv.AddFile(strings.NewReader("hello world"), "tier.txt"); err != nil {
+			t.Fatal(err)
+		}
+		defer drv.RemoveFile("tier.txt")
+		props, err := drv.(*Storage).blob("tier.txt").GetProperties(
+			context.Background(),
+			azblobsdk.BlobAccessConditions{},
+			azblobsdk.ClientProvidedKeyOptions{},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := props.AccessTier(); got != string(azblobsdk.AccessTierCool) {
+			t.Errorf("expected tier %q got %q", azblobsdk.AccessTierCool, got)
+		}
+	})
+	t.Run("delete container", func(t *testing.T) {
+		if err := drv.(storage.DangerDriver).DeleteContainer(); err != nil {
+			t.Fatal(err)
+		}
+		_, err := storage.Open(base + "&auto-create=false")
+		if err == nil {
+			t.Error("expected an error opening a deleted container")
+		}
+	})
+}
+
+func TestParseURL(t *testing.T) {
+	os.Unsetenv("AZURE_STORAGE_KEY")
+	os.Unsetenv("AZURE_STORAGE_SAS_TOKEN")
+	for _, tt := range []struct {
+		in   string
+		want *Config
+		err  error
+	}{
+		{
+			"azblob://myaccount/mycontainer/assets?accept=.txt&key=czNjcjN0",
+			&Config{
+				AutoContainerCreate: true,
+				Account:             "myaccount",
+				Container:           "mycontainer",
+				Prefix:              "/assets",
+				Endpoint:            "https://myaccount.blob.core.windows.net",
+				key:                 "czNjcjN0",
+				accept:              map[string]struct{}{".txt": {}},
+			},
+			nil,
+		},
+		{
+			"azblob://devstoreaccount1/mycontainer?endpoint=http://127.0.0.1:10000/devstoreaccount1/&tier=Cool&auto-create=off&sas=" +
+				url.QueryEscape("?sv=2020-08-04&ss=b&sig=abc%3D"),
+			&Config{
+				Account:   "devstoreaccount1",
+				Container: "mycontainer",
+				Prefix:    "/",
+				Endpoint:  "http://127.0.0.1:10000/devstoreaccount1",
+				Tier:      azblobsdk.AccessTierCool,
+				sas:       "sv=2020-08-04&ss=b&sig=abc%3D",
+				accept:    map[string]struct{}{".jpeg": {}, ".jpg": {}, ".png": {}, ".svg": {}},
+			},
+			nil,
+		},
+		{"azblob://myaccount/mycontainer?tier=lukewarm", nil, ErrURLParse},
+		{"azblob://myaccount/mycontainer?endpoint=localhost", nil, ErrURLParse},
+		{"azblob://myaccount/mycontainer?auto-create=maybe", nil, ErrURLParse},
+		{"azblob://myaccount/mycontainer?sas=" + url.QueryEscape("sig=%zz"), nil, ErrURLParse},
+		{"azblob://myaccount", nil, ErrURLParse},
+		{"s3://myaccount/mycontainer", nil, ErrURLParse},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseURL(tt.in)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("expected %v got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nexpected\t%+v\ngot\t\t%+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseURLEnv(t *testing.T) {
+	defer os.Unsetenv("AZURE_STORAGE_KEY")
+	defer os.Unsetenv("AZURE_STORAGE_SAS_TOKEN")
+	os.Setenv("AZURE_STORAGE_KEY", "ZW52")
+	os.Setenv("AZURE_STORAGE_SAS_TOKEN", "?sv=2020-08-04&sig=env")
+
+	c, err := parseURL("azblob://myaccount/mycontainer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.key != "ZW52" || c.sas != "sv=2020-08-04&sig=env" {
+		t.Errorf("expected the credentials of the environment, got key %q sas %q", c.key, c.sas)
+	}
+	c, err = parseURL("azblob://myaccount/mycontainer?key=dXJs&sas=sig%3Durl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.key != "dXJs" || c.sas != "sig=url" {
+		t.Errorf("expected the credentials of the url, got key %q sas %q", c.key, c.sas)
+	}
+}
+
+func TestPath(t *testing.T) {
+	for _, tt := range []struct {
+		config *Config
+		key    string
+		want   string
+	}{
+		{
+			&Config{Endpoint: "https://a.blob.core.windows.net", Container: "c", Prefix: "/assets"},
+			"assets/a.txt",
+			"https://a.blob.core.windows.net/c/assets/a.txt",
+		},
+		{
+			&Config{Endpoint: "http://127.0.0.1:10000/devstoreaccount1", Container: "c", Prefix: "/"},
+			"a.txt",
+			"http://127.0.0.1:10000/devstoreaccount1/c/a.txt",
+		},
+	} {
+		s := &Storage{config: tt.config}
+		if got := s.key("a.txt"); got != tt.key {
+			t.Errorf("expected key %q got %q", tt.key, got)
+		}
+		if got := s.NormalizePath("a.txt"); got != tt.want {
+			t.Errorf("expected %q got %q", tt.want, got)
+		}
+		if got := relativePath(s.root(), tt.key); got != "a.txt" {
+			t.Errorf("expected %q got %q", "a.txt", got)
+		}
+	}
+}
+
+func TestAccessConditions(t *testing.T) {
+	for _, tt := range []struct {
+		mode        storage.WriteMode
+		ifMatch     azblobsdk.ETag
+		ifNoneMatch azblobsdk.ETag
+	}{
+		{storage.Overwrite, azblobsdk.ETagNone, azblobsdk.ETagNone},
+		{storage.CreateOnly, azblobsdk.ETagNone, azblobsdk.ETagAny},
+		{storage.IfMatch("0x8D9"), `"0x8D9"`, azblobsdk.ETagNone},
+	} {
+		t.Run(tt.mode.String(), func(t *testing.T) {
+			ac := accessConditions(tt.mode)
+			if ac.IfMatch != tt.ifMatch {
+				t.Errorf("expected If-Match %q got %q", tt.ifMatch, ac.IfMatch)
+			}
+			if ac.IfNoneMatch != tt.ifNoneMatch {
+				t.Errorf("expected If-None-Match %q got %q", tt.ifNoneMatch, ac.IfNoneMatch)
+			}
+		})
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	other := errors.New("other")
+	for _, tt := range []struct {
+		err  error
+		mode storage.WriteMode
+		want error
+	}{
+		{storage.ErrPreconditionFailed, storage.CreateOnly, storage.ErrAlreadyExists},
+		{storage.ErrNotFound, storage.IfMatch("0x8D9"), storage.ErrPreconditionFailed},
+		{storage.ErrPreconditionFailed, storage.IfMatch("0x8D9"), storage.ErrPreconditionFailed},
+		{storage.ErrNotFound, storage.Overwrite, storage.ErrNotFound},
+		{other, storage.CreateOnly, other},
+	} {
+		t.Run(fmt.Sprintf("%v %s", tt.err, tt.mode), func(t *testing.T) {
+			if got := writeError(tt.err, tt.mode); !errors.Is(got, tt.want) {
+				t.Errorf("expected %v got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,739 @@
+// Package bolt implements a storage.Driver for a bucket of a bbolt
+// database, an embedded key-value store held in a single file. Files are
+// split into chunks, stored one per key, so they are read without being
+// loaded whole.
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/djangulo/go-storage"
+	"github.com/djangulo/go-storage/internal/util"
+)
+
+// Storage is a storage.Driver for a bucket of a bbolt database.
+type Storage struct {
+	config *Config
+	db     *bolt.DB
+}
+
+type Config struct {
+	// File is the path of the database.
+	File string
+	// Bucket holds the files, in its files and chunks nested buckets.
+	Bucket string
+	// ChunkSize is the size of the chunks files are split into.
+	ChunkSize int
+	// Timeout waiting for the lock of the database file.
+	Timeout time.Duration
+	accept  map[string]struct{}
+}
+
+// record is the value of a file in the files bucket.
+type record struct {
+	// ID prefixes the keys of the chunks of the file.
+	ID                 string
+	ChunkSize          int64
+	Size               int64
+	ModTime            time.Time
+	ContentType        string
+	CacheControl       string
+	ContentDisposition string
+	ContentEncoding    string
+	ETag               string
+	Metadata           map[string]string
+}
+
+var (
+	filesBucket  = []byte("files")
+	chunksBucket = []byte("chunks")
+)
+
+const (
+	defaultChunkSize = 256 << 10
+	// walkBatch is the number of files Walk reads per transaction.
+	walkBatch = 1000
+)
+
+// compile-time checks of the optional interfaces Storage implements.
+var (
+	_ storage.DangerDriver  = (*Storage)(nil)
+	_ storage.ContextDriver = (*Storage)(nil)
+	_ storage.StatDriver    = (*Storage)(nil)
+	_ storage.PutDriver     = (*Storage)(nil)
+	_ storage.CopyDriver    = (*Storage)(nil)
+	_ storage.ListDriver    = (*Storage)(nil)
+	_ storage.WalkDriver    = (*Storage)(nil)
+	_ storage.RangeDriver   = (*Storage)(nil)
+)
+
+func init() {
+	storage.Register("bolt", &Storage{})
+}
+
+// Path returns the name of the bucket.
+func (s *Storage) Path() string {
+	return s.config.Bucket
+}
+
+func (s *Storage) Accepts(ext string) (accepts bool) {
+	_, accepts = s.config.accept[ext]
+	return
+}
+
+func (s *Storage) NormalizePath(entries ...string) string {
+	entries = append([]string{s.config.Bucket}, entries...)
+	return path.Join(entries...)
+}
+
+var ErrURLParse = errors.New("error parsing url")
+
+func parseURL(urlString string) (*Config, error) {
+	u, err := url.Parse(urlString)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrURLParse, err)
+	}
+	if u.Scheme != "bolt" || u.Host+u.Path == "" {
+		return nil, fmt.Errorf(
+			"%w: %s does not match \"bolt:///path/to/db\" format",
+			ErrURLParse,
+			urlString,
+		)
+	}
+	c := &Config{
+		File:      filepath.FromSlash(u.Host + u.Path),
+		Bucket:    "assets",
+		ChunkSize: defaultChunkSize,
+		Timeout:   time.Second,
+	}
+
+	q := u.Query()
+	if v := q.Get("bucket"); v != "" {
+		c.Bucket = v
+	}
+	if v := q.Get("chunk-size"); v != "" {
+		if c.ChunkSize, err = strconv.Atoi(v); err != nil || c.ChunkSize <= 0 {
+			return nil, fmt.Errorf("%w: invalid chunk-size %q", ErrURLParse, v)
+		}
+	}
+	if v := q.Get("timeout"); v != "" {
+		if c.Timeout, err = time.ParseDuration(v); err != nil || c.Timeout < 0 {
+			return nil, fmt.Errorf("%w: invalid timeout %q", ErrURLParse, v)
+		}
+	}
+	c.accept = util.ParseCommaSeparatedQuery(q, "accept", ".jpeg", ".jpg", ".png", ".svg")
+	return c, nil
+}
+
+// Open creates a *Storage. The urlString should be in the form
+// bolt:///path/to/db?bucket=&chunk-size=&timeout=&accept=
+// Relative paths are written as bolt://./db. The database and the bucket
+// are created if missing. bbolt locks the database file while it is open,
+// so a file can only be opened by one Storage at a time, until Close.
+// The URL parameters accepted are as follows:
+//   - bucket: name of the bucket holding the files. Default assets.
+//   - chunk-size: size in bytes of the chunks files are split into. Default
+//     262144 (256KiB).
+//   - timeout: time to wait for the lock of the database file, as parsed by
+//     time.ParseDuration. Zero waits forever. Default 1s.
+//   - accept: comma-separated list of file extensions to accept. Could be
+//     repeated. e.g. url://host/path?accept=.jpeg,.svg&accept=.png would
+//     accept .jpeg, .svg and .png files. Default .jpeg,.jpg,.png,.svg
+func (s *Storage) Open(urlString string) (storage.Driver, error) {
+	return s.OpenContext(context.Background(), urlString)
+}
+
+// OpenContext is the context-aware version of Open. The context is only
+// checked before opening the database.
+func (s *Storage) OpenContext(ctx context.Context, urlString string) (storage.Driver, error) {
+	var err error
+
+	if err := ctx.Err(); err != nil {
+		return nil, s.error("open", "", err)
+	}
+
+	ns := &Storage{}
+
+	ns.config, err = parseURL(urlString)
+	if err != nil {
+		return nil, s.error("open", "", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(ns.config.File), 0777); err != nil {
+		return nil, s.error("open", "", err)
+	}
+	ns.db, err = bolt.Open(ns.config.File, 0600, &bolt.Options{Timeout: ns.config.Timeout})
+	if err != nil {
+		return nil, s.error("open", "", err)
+	}
+	if err := ns.db.Update(ns.createBuckets); err != nil {
+		ns.db.Close()
+		return nil, s.error("open", "", err)
+	}
+
+	return ns, nil
+}
+
+func (s *Storage) createBuckets(tx *bolt.Tx) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(s.config.Bucket))
+	if err != nil {
+		return err
+	}
+	if _, err := b.CreateBucketIfNotExists(filesBucket); err != nil {
+		return err
+	}
+	_, err = b.CreateBucketIfNotExists(chunksBucket)
+	return err
+}
+
+func (s *Storage) error(op, p string, err error) error {
+	return &storage.Error{Op: op, Driver: "bolt", Path: p, Err: err}
+}
+
+// Close closes the database, releasing its file. Calls made afterwards fail
+// with bbolt.ErrDatabaseNotOpen.
+func (s *Storage) Close() error {
+	if err := s.db.Close(); err != nil {
+		return s.error("close", "", err)
+	}
+	return nil
+}
+
+// buckets returns the files and chunks buckets, which DeleteContainer
+// removes.
+func (s *Storage) buckets(tx *bolt.Tx) (files, chunks *bolt.Bucket, err error) {
+	b := tx.Bucket([]byte(s.config.Bucket))
+	if b == nil {
+		return nil, nil, fmt.Errorf("bucket %s: %w", s.config.Bucket, bolt.ErrBucketNotFound)
+	}
+	return b.Bucket(filesBucket), b.Bucket(chunksBucket), nil
+}
+
+// clean returns the key of path in the files bucket.
+func clean(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+// chunkKey returns the key of the chunk seq of the file id.
+func chunkKey(id string, seq int64) []byte {
+	k := make([]byte, len(id)+8)
+	copy(k, id)
+	binary.BigEndian.PutUint64(k[len(id):], uint64(seq))
+	return k
+}
+
+// getRecord returns the record of the file on key, or nil.
+func getRecord(files *bolt.Bucket, key string) (*record, error) {
+	v := files.Get([]byte(key))
+	if v == nil {
+		return nil, nil
+	}
+	var rec record
+	if err := json.Unmarshal(v, &rec); err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+	return &rec, nil
+}
+
+func putRecord(files *bolt.Bucket, key string, rec *record) error {
+	v, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return files.Put([]byte(key), v)
+}
+
+// deleteChunks removes the chunks of the file id.
+func deleteChunks(chunks *bolt.Bucket, id string) error {
+	prefix := []byte(id)
+	c := chunks.Cursor()
+	// deleting moves the cursor, so seek again every time
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *record) fileInfo(p string) *storage.FileInfo {
+	return &storage.FileInfo{
+		Path:               p,
+		Size:               r.Size,
+		ModTime:            r.ModTime,
+		ContentType:        r.ContentType,
+		CacheControl:       r.CacheControl,
+		ContentDisposition: r.ContentDisposition,
+		ContentEncoding:    r.ContentEncoding,
+		ETag:               r.ETag,
+		Metadata:           r.Metadata,
+	}
+}
+
+func (s *Storage) AddFile(r io.Reader, p string) (string, error) {
+	return s.addFile(context.Background(), r, p, nil)
+}
+
+// AddFileContext is the context-aware version of AddFile. Nothing is stored
+// if ctx is done before r is fully read.
+func (s *Storage) AddFileContext(ctx context.Context, r io.Reader, p string) (string, error) {
+	return s.addFile(ctx, r, p, nil)
+}
+
+// AddFileWithOptions is AddFile, configured through opts. The chunks are
+// written in transactions of their own, so writers do not hold the
+// database while reading r, and the file is only stored once they are all
+// written. Every write mode is atomic.
+func (s *Storage) AddFileWithOptions(r io.Reader, p string, opts *storage.PutOptions) (string, error) {
+	return s.addFile(context.Background(), r, p, opts)
+}
+
+func (s *Storage) addFile(ctx context.Context, r io.Reader, p string, opts *storage.PutOptions) (string, error) {
+	p = clean(p)
+	if opts == nil {
+		opts = &storage.PutOptions{}
+	}
+	if ext := filepath.Ext(p); !s.Accepts(ext) {
+		return "", s.error("add", p, fmt.Errorf("%w %s", storage.ErrInvalidExtension, ext))
+	}
+	if err := ctx.Err(); err != nil {
+		return "", s.error("add", p, err)
+	}
+
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", s.error("add", p, err)
+	}
+	rec := &record{
+		ID:                 hex.EncodeToString(b[:]),
+		ChunkSize:          int64(s.config.ChunkSize),
+		ContentType:        opts.ResolveContentType(p),
+		CacheControl:       opts.CacheControl,
+		ContentDisposition: opts.ContentDisposition,
+		ContentEncoding:    opts.ContentEncoding,
+	}
+	if len(opts.Metadata) > 0 {
+		rec.Metadata = make(map[string]string, len(opts.Metadata))
+		for k, v := range opts.Metadata {
+			rec.Metadata[strings.ToLower(k)] = v
+		}
+	}
+	err := s.writeChunks(ctx, rec, r)
+	if err == nil {
+		err = s.commit(p, rec, opts.Mode)
+	}
+	if err != nil {
+		s.db.Update(func(tx *bolt.Tx) error {
+			_, chunks, err := s.buckets(tx)
+			if err != nil {
+				return err
+			}
+			return deleteChunks(chunks, rec.ID)
+		})
+		return "", s.error("add", p, err)
+	}
+	return s.NormalizePath(p), nil
+}
+
+// writeChunks stores the contents of r as the chunks of rec, and sets its
+// size and ETag, the MD5 hash of the contents.
+func (s *Storage) writeChunks(ctx context.Context, rec *record, r io.Reader) error {
+	h := md5.New()
+	buf := make([]byte, s.config.ChunkSize)
+	r = io.TeeReader(util.ContextReader(ctx, r), h)
+	for seq := int64(0); ; seq++ {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			err := s.db.Update(func(tx *bolt.Tx) error {
+				_, chunks, err := s.buckets(tx)
+				if err != nil {
+					return err
+				}
+				return chunks.Put(chunkKey(rec.ID, seq), buf[:n])
+			})
+			if err != nil {
+				return err
+			}
+			rec.Size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	rec.ModTime = time.Now()
+	rec.ETag = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
+
+// commit stores rec on key, if mode allows it. The chunks of the file it
+// replaces are removed.
+func (s *Storage) commit(key string, rec *record, mode storage.WriteMode) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		files, chunks, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+		old, err := getRecord(files, key)
+		if err != nil {
+			return err
+		}
+		var existing *storage.FileInfo
+		if old != nil {
+			existing = old.fileInfo(key)
+		}
+		if err := mode.Check(existing); err != nil {
+			return err
+		}
+		if old != nil {
+			if err := deleteChunks(chunks, old.ID); err != nil {
+				return err
+			}
+		}
+		return putRecord(files, key, rec)
+	})
+}
+
+func (s *Storage) RemoveFile(p string) error {
+	return s.RemoveFileContext(context.Background(), p)
+}
+
+// RemoveFileContext is the context-aware version of RemoveFile. The context
+// is only checked before removing the file.
+func (s *Storage) RemoveFileContext(ctx context.Context, p string) error {
+	p = clean(p)
+	if err := ctx.Err(); err != nil {
+		return s.error("remove", p, err)
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		files, chunks, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+		rec, err := getRecord(files, p)
+		if err != nil || rec == nil {
+			return err
+		}
+		if err := deleteChunks(chunks, rec.ID); err != nil {
+			return err
+		}
+		return files.Delete([]byte(p))
+	})
+	if err != nil {
+		return s.error("remove", p, err)
+	}
+	return nil
+}
+
+// view returns the record of the file on p.
+func (s *Storage) view(p string) (*record, error) {
+	var rec *record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		files, _, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+		rec, err = getRecord(files, p)
+		return err
+	})
+	if err == nil && rec == nil {
+		err = storage.ErrNotFound
+	}
+	return rec, err
+}
+
+func (s *Storage) GetFile(p string) (io.ReadCloser, error) {
+	return s.GetFileContext(context.Background(), p)
+}
+
+// GetFileContext is the context-aware version of GetFile. ctx also governs
+// reads from the returned io.ReadCloser, which reads one chunk per
+// transaction.
+func (s *Storage) GetFileContext(ctx context.Context, p string) (io.ReadCloser, error) {
+	return s.open(ctx, clean(p), 0, -1)
+}
+
+// GetFileRange returns length bytes of the file on path starting at offset,
+// or the rest of the file if length is negative. Only the chunks holding the
+// range are read.
+func (s *Storage) GetFileRange(p string, offset, length int64) (io.ReadCloser, error) {
+	return s.open(context.Background(), clean(p), offset, length)
+}
+
+func (s *Storage) open(ctx context.Context, p string, offset, length int64) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, s.error("get", p, err)
+	}
+	rec, err := s.view(p)
+	if err != nil {
+		return nil, s.error("get", p, err)
+	}
+	offset, length, err = util.ClampRange(rec.Size, offset, length)
+	if err != nil {
+		return nil, s.error("get", p, err)
+	}
+	return &reader{
+		ctx:       ctx,
+		s:         s,
+		path:      p,
+		id:        rec.ID,
+		seq:       offset / rec.ChunkSize,
+		skip:      offset % rec.ChunkSize,
+		remaining: length,
+	}, nil
+}
+
+// reader reads a file one chunk at a time.
+type reader struct {
+	ctx  context.Context
+	s    *Storage
+	path string
+	id   string
+	// seq of the next chunk to read.
+	seq int64
+	// skip is the number of bytes of the next chunk before the range.
+	skip      int64
+	remaining int64
+	buf       []byte
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if err := r.ctx.Err(); err != nil {
+		return 0, r.s.error("get", r.path, err)
+	}
+	if len(r.buf) == 0 {
+		var data []byte
+		err := r.s.db.View(func(tx *bolt.Tx) error {
+			_, chunks, err := r.s.buckets(tx)
+			if err != nil {
+				return err
+			}
+			// values are only valid during the transaction
+			data = append(data, chunks.Get(chunkKey(r.id, r.seq))...)
+			return nil
+		})
+		if err == nil && len(data) == 0 {
+			// the file was replaced or removed since it was opened
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return 0, r.s.error("get", r.path, err)
+		}
+		if r.skip > int64(len(data)) {
+			r.skip = int64(len(data))
+		}
+		r.buf, r.skip = data[r.skip:], 0
+		r.seq++
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.remaining -= int64(n)
+	return n, nil
+}
+
+func (r *reader) Close() error {
+	r.remaining = 0
+	r.buf = nil
+	return nil
+}
+
+// Stat returns the FileInfo of the file on path. The ETag is the MD5 hash of
+// its contents.
+func (s *Storage) Stat(p string) (*storage.FileInfo, error) {
+	p = clean(p)
+	rec, err := s.view(p)
+	if err != nil {
+		return nil, s.error("stat", p, err)
+	}
+	return rec.fileInfo(p), nil
+}
+
+// Exists reports whether there is a file on path.
+func (s *Storage) Exists(p string) (bool, error) {
+	_, err := s.Stat(p)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Copy copies the file on src to dst, along with its content type and
+// metadata. It is atomic.
+func (s *Storage) Copy(src, dst string) error {
+	return s.copy("copy", src, dst, false)
+}
+
+// Move renames the file on src to dst, without copying its contents. It is
+// atomic.
+func (s *Storage) Move(src, dst string) error {
+	return s.copy("move", src, dst, true)
+}
+
+func (s *Storage) copy(op, src, dst string, move bool) error {
+	src, dst = clean(src), clean(dst)
+	if ext := filepath.Ext(dst); !s.Accepts(ext) {
+		return s.error(op, dst, fmt.Errorf("%w %s", storage.ErrInvalidExtension, ext))
+	}
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return s.error(op, dst, err)
+	}
+	// the path errors are reported on
+	errPath := dst
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		files, chunks, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+		rec, err := getRecord(files, src)
+		if err == nil && rec == nil {
+			err = storage.ErrNotFound
+		}
+		if err != nil {
+			errPath = src
+			return err
+		}
+		if files.Get([]byte(dst)) != nil {
+			return storage.ErrAlreadyExists
+		}
+		rec.ModTime = time.Now()
+		if move {
+			if err := files.Delete([]byte(src)); err != nil {
+				return err
+			}
+		} else if rec.ID, err = copyChunks(chunks, rec.ID, hex.EncodeToString(b[:])); err != nil {
+			return err
+		}
+		return putRecord(files, dst, rec)
+	})
+	if err != nil {
+		return s.error(op, errPath, err)
+	}
+	return nil
+}
+
+// copyChunks copies the chunks of the file src to the file dst, and
+// returns dst.
+func copyChunks(chunks *bolt.Bucket, src, dst string) (string, error) {
+	prefix := []byte(src)
+	var keys, values [][]byte
+	c := chunks.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		keys, values = append(keys, k), append(values, v)
+	}
+	// writing while iterating would move the cursor
+	for i, k := range keys {
+		seq := int64(binary.BigEndian.Uint64(k[len(src):]))
+		if err := chunks.Put(chunkKey(dst, seq), values[i]); err != nil {
+			return "", err
+		}
+	}
+	return dst, nil
+}
+
+// List returns a page of the files under prefix. Every key under prefix
+// is read to group the files by delimiter, so large prefixes are better
+// walked.
+func (s *Storage) List(prefix string, opts *storage.ListOptions) (*storage.ListPage, error) {
+	return util.ListFromWalk(s, prefix, opts)
+}
+
+// Walk calls fn for every file under prefix, in lexical order. The files
+// are read in batches, and fn is only called between transactions, so it
+// may use the driver.
+func (s *Storage) Walk(prefix string, fn storage.WalkFunc) error {
+	after := ""
+	for {
+		files, err := s.walkBatch(prefix, after)
+		if err != nil {
+			return s.error("walk", prefix, err)
+		}
+		for _, fi := range files {
+			if err := fn(fi); err != nil {
+				return err
+			}
+		}
+		if len(files) < walkBatch {
+			return nil
+		}
+		after = files[len(files)-1].Path
+	}
+}
+
+// walkBatch returns the files under prefix whose path sorts after after.
+func (s *Storage) walkBatch(prefix, after string) ([]*storage.FileInfo, error) {
+	var fis []*storage.FileInfo
+	err := s.db.View(func(tx *bolt.Tx) error {
+		files, _, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+		c := files.Cursor()
+		k, v := c.Seek([]byte(prefix))
+		if after != "" {
+			k, v = c.Seek([]byte(after))
+			if string(k) == after {
+				k, v = c.Next()
+			}
+		}
+		for ; k != nil && bytes.HasPrefix(k, []byte(prefix)) && len(fis) < walkBatch; k, v = c.Next() {
+			var rec record
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+			fis = append(fis, rec.fileInfo(string(k)))
+		}
+		return nil
+	})
+	return fis, err
+}
+
+// EmptyContainer removes every file.
+func (s *Storage) EmptyContainer() error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket([]byte(s.config.Bucket)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		return s.createBuckets(tx)
+	})
+	if err != nil {
+		return s.error("empty", "", err)
+	}
+	return nil
+}
+
+// DeleteContainer removes the bucket. The database file is left in place.
+func (s *Storage) DeleteContainer() error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte(s.config.Bucket))
+	})
+	if err != nil && err != bolt.ErrBucketNotFound {
+		return s.error("delete", "", err)
+	}
+	return nil
+}
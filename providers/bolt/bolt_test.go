@@ -0,0 +1,185 @@
+package bolt
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/djangulo/go-storage"
+	storagetest "github.com/djangulo/go-storage/testing"
+)
+
+func TestBolt(t *testing.T) {
+	db := filepath.ToSlash(filepath.Join(t.TempDir(), "data", "files.db"))
+	base := "bolt://" + db + "?accept=.txt&timeout=100ms"
+
+	drv, err := storage.Open(base + "&chunk-size=4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer drv.Close()
+	storagetest.Test(t, drv)
+
+	s := drv.(*Storage)
+	t.Run("path", func(t *testing.T) {
+		if got := drv.NormalizePath("a/b.txt"); got != "assets/a/b.txt" {
+			t.Errorf("expected %q got %q", "assets/a/b.txt", got)
+		}
+	})
+	t.Run("chunks", func(t *testing.T) {
+		if _, err := drv.AddFile(strings.NewReader("0123456789"), "chunked.txt"); err != nil {
+			t.Fatal(err)
+		}
+		defer drv.RemoveFile("chunked.txt")
+		if n := s.countChunks(t); n != 3 {
+			t.Errorf("expected 3 chunks got %d", n)
+		}
+		// a length past the end of the file stops at its last chunk
+		rc, err := s.GetFileRange("chunked.txt", 3, math.MaxInt64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if b, err := ioutil.ReadAll(rc); string(b) != "3456789" || err != nil {
+			t.Errorf("expected %q, <nil> got %q, %v", "3456789", string(b), err)
+		}
+	})
+	t.Run("no chunks left behind", func(t *testing.T) {
+		if n := s.countChunks(t); n != 0 {
+			t.Errorf("expected no chunks got %d", n)
+		}
+	})
+	t.Run("walk batches", func(t *testing.T) {
+		for i := 0; i < walkBatch+1; i++ {
+			if _, err := drv.AddFile(strings.NewReader("x"), fmt.Sprintf("batch/%04d.txt", i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		defer drv.(storage.DangerDriver).EmptyContainer()
+		n := 0
+		err := storage.Walk(drv, "batch/", func(fi *storage.FileInfo) error {
+			n++
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != walkBatch+1 {
+			t.Errorf("expected %d files got %d", walkBatch+1, n)
+		}
+	})
+	t.Run("locked", func(t *testing.T) {
+		_, err := storage.Open(base)
+		if !errors.Is(err, bolt.ErrTimeout) {
+			t.Errorf("expected %v got %v", bolt.ErrTimeout, err)
+		}
+	})
+	t.Run("close", func(t *testing.T) {
+		if _, err := drv.AddFile(strings.NewReader("hello"), "kept.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if err := drv.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := drv.GetFile("kept.txt"); !errors.Is(err, bolt.ErrDatabaseNotOpen) {
+			t.Errorf("expected %v got %v", bolt.ErrDatabaseNotOpen, err)
+		}
+		drv, err = storage.Open(base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := storage.Exists(drv, "kept.txt"); !ok || err != nil {
+			t.Errorf("expected true, <nil> got %v, %v", ok, err)
+		}
+	})
+	t.Run("delete container", func(t *testing.T) {
+		if err := drv.(storage.DangerDriver).DeleteContainer(); err != nil {
+			t.Fatal(err)
+		}
+		err := drv.(*Storage).db.View(func(tx *bolt.Tx) error {
+			if tx.Bucket([]byte("assets")) != nil {
+				t.Error("expected the bucket to be removed")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func (s *Storage) countChunks(t *testing.T) int {
+	t.Helper()
+	var n int
+	err := s.db.View(func(tx *bolt.Tx) error {
+		_, chunks, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+		n = chunks.Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestParseURL(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want *Config
+		err  error
+	}{
+		{
+			"bolt:///var/lib/app/files.db?bucket=avatars&accept=.txt&chunk-size=1024&timeout=5s",
+			&Config{
+				File:      filepath.FromSlash("/var/lib/app/files.db"),
+				Bucket:    "avatars",
+				ChunkSize: 1024,
+				Timeout:   5 * time.Second,
+				accept:    map[string]struct{}{".txt": {}},
+			},
+			nil,
+		},
+		{
+			"bolt://./files.db",
+			&Config{
+				File:      filepath.FromSlash("./files.db"),
+				Bucket:    "assets",
+				ChunkSize: defaultChunkSize,
+				Timeout:   time.Second,
+				accept:    map[string]struct{}{".jpeg": {}, ".jpg": {}, ".png": {}, ".svg": {}},
+			},
+			nil,
+		},
+		{"bolt:///files.db?chunk-size=-1", nil, ErrURLParse},
+		{"bolt:///files.db?timeout=forever", nil, ErrURLParse},
+		{"bolt://", nil, ErrURLParse},
+		{"boltdb:///files.db", nil, ErrURLParse},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseURL(tt.in)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("expected %v got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nexpected\t%+v\ngot\t\t%+v", tt.want, got)
+			}
+		})
+	}
+}
@@ -1,12 +1,10 @@
 package dospace
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io"
 	"net/url"
-	"path"
-	"path/filepath"
 	"regexp"
 	"strings"
 
@@ -14,21 +12,31 @@ import (
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3iface"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/djangulo/go-storage"
 	"github.com/djangulo/go-storage/internal/util"
 )
 
+// compile-time checks of the optional interfaces DOSpace implements.
+var (
+	_ storage.DangerDriver  = (*DOSpace)(nil)
+	_ storage.ContextDriver = (*DOSpace)(nil)
+	_ storage.StatDriver    = (*DOSpace)(nil)
+	_ storage.PutDriver     = (*DOSpace)(nil)
+	_ storage.CopyDriver    = (*DOSpace)(nil)
+	_ storage.ListDriver    = (*DOSpace)(nil)
+	_ storage.WalkDriver    = (*DOSpace)(nil)
+	_ storage.RangeDriver   = (*DOSpace)(nil)
+	_ storage.WriterDriver  = (*DOSpace)(nil)
+	_ storage.PresignDriver = (*DOSpace)(nil)
+)
+
 func init() {
 	storage.Register("do", &DOSpace{})
 }
 
 type DOSpace struct {
-	client s3iface.S3API
+	*util.Bucket
 	config *Config
-
-	session *session.Session
 }
 
 type Config struct {
@@ -67,7 +75,7 @@ var (
 		"sfo1": {},
 		"sgp1": {},
 	}
-	ErrURLParse = errors.New("do: error parsing url")
+	ErrURLParse = errors.New("error parsing url")
 )
 
 func parseURL(urlString string) (*Config, error) {
@@ -97,7 +105,7 @@ func parseURL(urlString string) (*Config, error) {
 		return nil, fmt.Errorf(
 			"%w: failed to parse %s into \"do://key:secret@space/prefix\": missing %q",
 			ErrURLParse,
-			urlString,
+			util.RedactURL(urlString),
 			strings.Join(missing, ","),
 		)
 
@@ -118,6 +126,10 @@ func parseURL(urlString string) (*Config, error) {
 
 	u, err := url.Parse(urlString)
 	if err != nil {
+		// *url.Error would print the url, secret included
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
 		return nil, fmt.Errorf("%w: %v", ErrURLParse, err)
 	}
 
@@ -141,7 +153,7 @@ func parseURL(urlString string) (*Config, error) {
 	}
 	if region := q.Get("region"); region != "" {
 		region = strings.ToLower(region)
-		if _, ok := acceptableAutoCreate[region]; ok {
+		if _, ok := acceptableRegions[region]; ok {
 			c.Region = region
 		} else {
 			return nil, fmt.Errorf("%w: unknown region value: %s", ErrURLParse, region)
@@ -167,42 +179,63 @@ func parseURL(urlString string) (*Config, error) {
 //     https://developers.digitalocean.com/documentation/v2/#list-all-regions
 //     for listing. Default "nyc3"
 func (do *DOSpace) Open(urlString string) (storage.Driver, error) {
+	return do.OpenContext(context.Background(), urlString)
+}
+
+// OpenContext is the context-aware version of Open. ctx governs the space
+// lookup and creation.
+func (do *DOSpace) OpenContext(ctx context.Context, urlString string) (storage.Driver, error) {
 	var err error
 
 	ndo := new(DOSpace)
 
 	ndo.config, err = parseURL(urlString)
 	if err != nil {
-		return nil, err
+		return nil, do.error("open", "", err)
 	}
-	ndo.session, err = session.NewSession(&aws.Config{
+	sess, err := session.NewSession(&aws.Config{
 		Credentials: credentials.NewStaticCredentials(ndo.config.key, ndo.config.secret, ""),
-		Endpoint:    aws.String("https://nyc3.digitaloceanspaces.com"),
+		Endpoint:    aws.String(fmt.Sprintf("https://%s.digitaloceanspaces.com", ndo.config.Region)),
 		Region:      aws.String("us-east-1"),
 	})
+	if err != nil {
+		return nil, do.error("open", "", err)
+	}
 
-	ndo.client = s3.New(ndo.session)
+	client := s3.New(sess)
 
-	var exists = util.BucketExists(ndo.client, ndo.config.Space)
+	var exists = util.BucketExists(ctx, client, ndo.config.Space)
 	if !exists && !ndo.config.AutoSpaceCreate {
-		return nil, fmt.Errorf("do: space does not exist; AutoSpaceCreate is off")
+		return nil, do.error("open", "", errors.New("space does not exist; AutoSpaceCreate is off"))
 	}
 	if !exists && ndo.config.AutoSpaceCreate {
-		_, err := ndo.client.CreateBucket(&s3.CreateBucketInput{
+		_, err := client.CreateBucketWithContext(ctx, &s3.CreateBucketInput{
 			Bucket: &ndo.config.Space,
 		})
 		if err != nil {
-			return nil, err
+			return nil, do.error("open", "", err)
 		}
 	}
 
+	ndo.Bucket = &util.Bucket{
+		Driver:  "do",
+		Session: sess,
+		Client:  client,
+		Name:    ndo.config.Space,
+		Prefix:  ndo.config.Prefix,
+		FileACL: ndo.config.FileACL,
+		ACLs:    acceptableACL,
+		// Spaces does not support conditional writes
+		Conditional: false,
+		URL:         ndo.Path(),
+		Accept:      ndo.config.accept,
+	}
+
 	return ndo, nil
 }
 
-func (do *DOSpace) Close() error {
-	// allow itself to be garbage collected
-	do = nil
-	return nil
+func (do *DOSpace) error(op, p string, err error) error {
+	return &storage.Error{Op: op, Driver: "do", Path: p, Err: util.AWSError(err)}
 }
 
 func (do *DOSpace) Path() string {
@@ -214,90 +247,13 @@ func (do *DOSpace) Path() string {
 	)
 }
 
-func (do *DOSpace) Accepts(ext string) (accepts bool) {
-	_, accepts = do.config.accept[ext]
-	return
-}
-
 func (do *DOSpace) NormalizePath(entries ...string) string {
-	entries = append([]string{do.Path()}, entries...)
-	return path.Join(entries...)
+	return util.JoinURL(do.Path(), entries...)
 }
 
+// EmtpyContainer is EmptyContainer.
+//
+// Deprecated: use EmptyContainer, which satisfies storage.DangerDriver.
 func (do *DOSpace) EmtpyContainer() error {
-	iter := s3manager.NewDeleteListIterator(do.client, &s3.ListObjectsInput{
-		Bucket: &do.config.Space,
-	})
-	if err := s3manager.NewBatchDeleteWithClient(do.client).Delete(aws.BackgroundContext(), iter); err != nil {
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-func (do *DOSpace) DeleteContainer() (err error) {
-	if err != nil {
-		return err
-	}
-	_, err = do.client.DeleteBucket(&s3.DeleteBucketInput{
-		Bucket: &do.config.Space,
-	})
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func (do *DOSpace) RemoveFile(p string) error {
-	key := path.Join(do.config.Prefix, p)
-	_, err := do.client.DeleteObject(&s3.DeleteObjectInput{
-		Bucket: &do.config.Space,
-		Key:    &key,
-	})
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func (do *DOSpace) GetFile(p string) (io.ReadCloser, error) {
-	key := path.Join(do.config.Prefix, p)
-	file, err := do.client.GetObject(&s3.GetObjectInput{
-		Bucket: &do.config.Space,
-		Key:    &key,
-	})
-	if err != nil {
-		return nil, err
-	}
-	return file.Body, nil
-}
-
-func (do *DOSpace) AddFile(r io.Reader, p string) (string, error) {
-	if ext := filepath.Ext(p); !do.Accepts(ext) {
-		return "", fmt.Errorf("%w %s", storage.ErrInvalidExtension, ext)
-	}
-	key := path.Join(do.config.Prefix, p)
-	_, err := do.client.HeadObject(&s3.HeadObjectInput{
-		Bucket: &do.config.Space,
-		Key:    &key,
-	})
-	if err == nil {
-		// file exists
-		return "", fmt.Errorf("%w at %s", storage.ErrAlreadyExists, key)
-	}
-	uploader := s3manager.NewUploader(do.session)
-	_, err = uploader.Upload(&s3manager.UploadInput{
-		Bucket:      &do.config.Space,
-		Key:         &key,
-		Body:        r,
-		ACL:         &do.config.FileACL,
-		ContentType: aws.String(storage.ResolveContentType(p)),
-	})
-	if err != nil {
-		return "", err
-	}
-
-	return path.Join(do.Path(), p), nil
+	return do.EmptyContainer()
 }
@@ -85,6 +85,25 @@ func TestParseURL(t *testing.T) {
 			},
 			nil,
 		},
+		{
+			"do://mykey:mysecret@test-space/assets?accept=.txt&region=AMS3",
+			&Config{
+				Space:           "test-space",
+				Prefix:          "/assets",
+				Region:          "ams3",
+				AutoSpaceCreate: true,
+				accept:          map[string]struct{}{".txt": {}},
+				FileACL:         "public-read",
+				key:             "mykey",
+				secret:          "mysecret",
+			},
+			nil,
+		},
+		{
+			"do://mykey:mysecret@test-space/assets?accept=.txt&region=off",
+			nil,
+			ErrURLParse,
+		},
 		{
 			"do://mysecret@test-space/assets?accept=.txt",
 			nil,
@@ -116,3 +135,11 @@ func TestParseURL(t *testing.T) {
 		})
 	}
 }
+
+func TestNormalizePath(t *testing.T) {
+	do := &DOSpace{config: &Config{Space: "space", Region: "ams3", Prefix: "/assets"}}
+	want := "https://space.ams3.digitaloceanspaces.com/assets/a/b.txt"
+	if got := do.NormalizePath("a", "b.txt"); got != want {
+		t.Errorf("expected %q got %q", want, got)
+	}
+}
@@ -2,8 +2,11 @@
 package fs
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -21,6 +24,23 @@ type Filesystem struct {
 	accept map[string]struct{}
 }
 
+// tempPattern names the temporary files of in-progress writes, which Walk
+// and List skip.
+const tempPattern = ".go-storage-*.tmp"
+
+// compile-time checks of the optional interfaces Filesystem implements.
+var (
+	_ storage.ContextDriver = (*Filesystem)(nil)
+	_ storage.StatDriver    = (*Filesystem)(nil)
+	_ storage.PutDriver     = (*Filesystem)(nil)
+	_ storage.CopyDriver    = (*Filesystem)(nil)
+	_ storage.ListDriver    = (*Filesystem)(nil)
+	_ storage.WalkDriver    = (*Filesystem)(nil)
+	_ storage.RangeDriver   = (*Filesystem)(nil)
+	_ storage.SeekDriver    = (*Filesystem)(nil)
+	_ storage.WriterDriver  = (*Filesystem)(nil)
+)
+
 func init() {
 	fs := &Filesystem{}
 	storage.Register("fs", fs)
@@ -47,9 +67,18 @@ func (fs *Filesystem) NormalizePath(entries ...string) string {
 // Open creates a filesystem object rooted at the path of the urlString.
 // 'accept' querystring is a crude validation for the acceptable filetypes.
 func (fs *Filesystem) Open(urlString string) (storage.Driver, error) {
+	return fs.OpenContext(context.Background(), urlString)
+}
+
+// OpenContext is the context-aware version of Open. The context is only
+// checked before creating the root directory.
+func (fs *Filesystem) OpenContext(ctx context.Context, urlString string) (storage.Driver, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fs.error("open", "", err)
+	}
 	u, err := url.Parse(urlString)
 	if err != nil {
-		return nil, err
+		return nil, fs.error("open", "", err)
 	}
 
 	q := u.Query()
@@ -60,75 +89,382 @@ func (fs *Filesystem) Open(urlString string) (storage.Driver, error) {
 	if _, err := os.Stat(root); os.IsNotExist(err) {
 		err := os.MkdirAll(root, 0777)
 		if err != nil {
-			panic(err)
+			return nil, fs.error("open", "", err)
 		}
 	}
-	fs.root = root
-	fs.path = u.Path
-	fs.accept = util.ParseCommaSeparatedQuery(q, "accept", ".jpeg", ".jpg", ".png", ".svg")
+	// create a new object, return as many instances as need be
+	nfs := &Filesystem{
+		root:   root,
+		path:   u.Path,
+		accept: util.ParseCommaSeparatedQuery(q, "accept", ".jpeg", ".jpg", ".png", ".svg"),
+	}
 
-	return fs, nil
+	return nfs, nil
 }
 
 func (fs *Filesystem) appendRoot(path string) string {
 	return filepath.Join(fs.root, path)
 }
 
+func (fs *Filesystem) error(op, path string, err error) error {
+	return &storage.Error{Op: op, Driver: "fs", Path: path, Err: err}
+}
+
 // Close noop
 func (fs *Filesystem) Close() error {
 	return nil
 }
 
 func (fs *Filesystem) AddFile(r io.Reader, path string) (string, error) {
+	return fs.addFile(context.Background(), r, path, nil)
+}
+
+// AddFileContext is the context-aware version of AddFile. The copy stops as
+// soon as ctx is done, and the partially written file is removed.
+func (fs *Filesystem) AddFileContext(ctx context.Context, r io.Reader, path string) (string, error) {
+	return fs.addFile(ctx, r, path, nil)
+}
+
+// AddFileWithOptions is AddFile, configured through opts. CreateOnly is
+// atomic; Overwrite and IfMatch write to a temporary file that is renamed
+// over the destination once complete, although the IfMatch check itself is
+// not atomic.
+func (fs *Filesystem) AddFileWithOptions(r io.Reader, path string, opts *storage.PutOptions) (string, error) {
+	return fs.addFile(context.Background(), r, path, opts)
+}
+
+func (fs *Filesystem) addFile(ctx context.Context, r io.Reader, path string, opts *storage.PutOptions) (string, error) {
+	if opts == nil {
+		opts = &storage.PutOptions{}
+	}
 	path = strings.TrimPrefix(path, fs.path)
-	absPath := filepath.Join(fs.root, path)
-	dir := filepath.Dir(absPath)
-	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		err := os.MkdirAll(dir, 0777)
-		if err != nil {
-			return "", fmt.Errorf("go-storage: fs: %w", err)
-		}
+	if err := ctx.Err(); err != nil {
+		return "", fs.error("add", path, err)
+	}
+	absPath, err := fs.prepare(path)
+	if err != nil {
+		return "", fs.error("add", path, err)
 	}
 
-	if _, err := os.Stat(absPath); os.IsExist(err) {
-		return "", fmt.Errorf("%w at %s", storage.ErrAlreadyExists, path)
+	if mode := opts.Mode; mode == storage.CreateOnly {
+		err = fs.create(ctx, r, absPath)
+	} else {
+		err = fs.replace(ctx, r, absPath, mode)
+	}
+	if err != nil {
+		return "", fs.error("add", path, err)
+	}
+	if err := fs.writeMeta(path, newMeta(opts)); err != nil {
+		return "", fs.error("add", path, err)
 	}
 
-	if ext := filepath.Ext(absPath); !fs.Accepts(ext) {
-		return "", fmt.Errorf("%w %s", storage.ErrInvalidExtension, ext)
+	return fs.NormalizePath(path), nil
+}
+
+// create writes r to absPath, failing if it exists.
+func (fs *Filesystem) create(ctx context.Context, r io.Reader, absPath string) error {
+	file, err := os.OpenFile(absPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if os.IsExist(err) {
+		return storage.ErrAlreadyExists
+	}
+	if err != nil {
+		return err
 	}
 
-	file, err := os.Create(absPath)
+	_, err = io.Copy(file, util.ContextReader(ctx, r))
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
-		return "", fmt.Errorf("go-storage: fs: %w", err)
+		os.Remove(absPath)
+		return err
+	}
+	return nil
+}
+
+// replace writes r to a temporary file and renames it to absPath, if mode
+// allows it.
+func (fs *Filesystem) replace(ctx context.Context, r io.Reader, absPath string, mode storage.WriteMode) error {
+	if err := checkMode(absPath, mode); err != nil {
+		return err
 	}
-	defer file.Close()
 
-	_, err = io.Copy(file, r)
+	file, err := ioutil.TempFile(filepath.Dir(absPath), tempPattern)
 	if err != nil {
-		return "", fmt.Errorf("go-storage: fs: %w", err)
+		return err
+	}
+	// TempFile creates files readable only by the owner
+	err = file.Chmod(0644)
+	if err == nil {
+		_, err = io.Copy(file, util.ContextReader(ctx, r))
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
 	}
+	if err == nil {
+		err = os.Rename(file.Name(), absPath)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return nil
+}
 
-	return fs.NormalizePath(path), nil
+// checkMode checks the file on absPath against mode. It is not atomic.
+func checkMode(absPath string, mode storage.WriteMode) error {
+	if mode.Overwrites() {
+		return nil
+	}
+	var existing *storage.FileInfo
+	if fi, err := os.Stat(absPath); err == nil && !fi.IsDir() {
+		existing = &storage.FileInfo{ETag: etag(fi)}
+	}
+	return mode.Check(existing)
+}
+
+// Copy copies the file on src to dst, along with its stored metadata.
+func (fs *Filesystem) Copy(src, dst string) error {
+	src, dst = strings.TrimPrefix(src, fs.path), strings.TrimPrefix(dst, fs.path)
+	absDst, err := fs.prepare(dst)
+	if err != nil {
+		return fs.error("copy", dst, err)
+	}
+	fh, err := os.Open(filepath.Join(fs.root, src))
+	if os.IsNotExist(err) {
+		return fs.error("copy", src, storage.ErrNotFound)
+	}
+	if err != nil {
+		return fs.error("copy", src, err)
+	}
+	defer fh.Close()
+	if err := fs.create(context.Background(), fh, absDst); err != nil {
+		return fs.error("copy", dst, err)
+	}
+	if err := fs.copyMeta(src, dst); err != nil {
+		return fs.error("copy", dst, err)
+	}
+	return nil
+}
+
+// Move moves the file on src to dst, along with its stored metadata. It
+// hard-links dst to src, which fails atomically if dst exists, and falls
+// back to a rename where links are not supported.
+func (fs *Filesystem) Move(src, dst string) error {
+	src, dst = strings.TrimPrefix(src, fs.path), strings.TrimPrefix(dst, fs.path)
+	absDst, err := fs.prepare(dst)
+	if err != nil {
+		return fs.error("move", dst, err)
+	}
+	absSrc := filepath.Join(fs.root, src)
+	if fi, err := os.Stat(absSrc); os.IsNotExist(err) || (err == nil && fi.IsDir()) {
+		return fs.error("move", src, storage.ErrNotFound)
+	}
+
+	err = os.Link(absSrc, absDst)
+	switch {
+	case os.IsExist(err):
+		return fs.error("move", dst, storage.ErrAlreadyExists)
+	case err == nil:
+		err = os.Remove(absSrc)
+	default:
+		if _, serr := os.Stat(absDst); serr == nil {
+			return fs.error("move", dst, storage.ErrAlreadyExists)
+		}
+		err = os.Rename(absSrc, absDst)
+	}
+	if err != nil {
+		return fs.error("move", src, err)
+	}
+	if err := fs.copyMeta(src, dst); err != nil {
+		return fs.error("move", dst, err)
+	}
+	if err := fs.writeMeta(src, nil); err != nil {
+		return fs.error("move", src, err)
+	}
+	return nil
+}
+
+// prepare checks that path has an accepted extension, creates its parent
+// directories, and returns its absolute path.
+func (fs *Filesystem) prepare(path string) (string, error) {
+	if ext := filepath.Ext(path); !fs.Accepts(ext) {
+		return "", fmt.Errorf("%w %s", storage.ErrInvalidExtension, ext)
+	}
+	absPath := filepath.Join(fs.root, path)
+	if err := os.MkdirAll(filepath.Dir(absPath), 0777); err != nil {
+		return "", err
+	}
+	return absPath, nil
 }
 
 func (fs *Filesystem) RemoveFile(path string) error {
-	if err := os.Remove(filepath.Join(fs.root, path)); err != nil {
-		return fmt.Errorf("go-storage: fs: %w", err)
+	return fs.RemoveFileContext(context.Background(), path)
+}
+
+// RemoveFileContext is the context-aware version of RemoveFile.
+func (fs *Filesystem) RemoveFileContext(ctx context.Context, path string) error {
+	path = strings.TrimPrefix(path, fs.path)
+	if err := ctx.Err(); err != nil {
+		return fs.error("remove", path, err)
+	}
+	if err := os.Remove(filepath.Join(fs.root, path)); err != nil && !os.IsNotExist(err) {
+		return fs.error("remove", path, err)
+	}
+	if err := fs.writeMeta(path, nil); err != nil {
+		return fs.error("remove", path, err)
 	}
 	return nil
 }
 
 func (fs *Filesystem) GetFile(path string) (io.ReadCloser, error) {
+	return fs.GetFileContext(context.Background(), path)
+}
+
+// GetFileContext is the context-aware version of GetFile. ctx also governs
+// reads from the returned file.
+func (fs *Filesystem) GetFileContext(ctx context.Context, path string) (io.ReadCloser, error) {
 	path = strings.TrimPrefix(path, fs.path)
-	path = filepath.Join(fs.root, path)
-	fh, err := os.Open(path)
+	if err := ctx.Err(); err != nil {
+		return nil, fs.error("get", path, err)
+	}
+	fh, err := fs.open("get", path)
 	if err != nil {
-		return nil, fmt.Errorf("go-storage: fs: %w", err)
+		return nil, err
+	}
+	return util.ContextReadCloser(ctx, fh), nil
+}
+
+// GetFileRange returns length bytes of the file on path starting at offset,
+// or the rest of the file if length is negative.
+func (fs *Filesystem) GetFileRange(path string, offset, length int64) (io.ReadCloser, error) {
+	path = strings.TrimPrefix(path, fs.path)
+	fh, err := fs.open("get", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fh.Seek(offset, io.SeekStart); err != nil {
+		fh.Close()
+		return nil, fs.error("get", path, err)
+	}
+	if length < 0 {
+		return fh, nil
+	}
+	return &limitedFile{Reader: io.LimitReader(fh, length), Closer: fh}, nil
+}
+
+// GetFileSeeker returns the *os.File on path.
+func (fs *Filesystem) GetFileSeeker(path string) (storage.ReadSeekCloser, error) {
+	path = strings.TrimPrefix(path, fs.path)
+	return fs.open("get", path)
+}
+
+func (fs *Filesystem) open(op, path string) (*os.File, error) {
+	fh, err := os.Open(filepath.Join(fs.root, path))
+	if os.IsNotExist(err) {
+		return nil, fs.error(op, path, storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fs.error(op, path, err)
 	}
 	return fh, nil
 }
 
+type limitedFile struct {
+	io.Reader
+	io.Closer
+}
+
+// Stat returns the FileInfo of the file on path. The ETag is derived from the
+// size and modification time of the file. The content type is derived from
+// its extension, unless it was added with storage.PutOptions, which are
+// read back from the metadata directory.
+func (fs *Filesystem) Stat(path string) (*storage.FileInfo, error) {
+	path = strings.TrimPrefix(path, fs.path)
+	fi, err := os.Stat(filepath.Join(fs.root, path))
+	if os.IsNotExist(err) || (err == nil && fi.IsDir()) {
+		return nil, fs.error("stat", path, storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fs.error("stat", path, err)
+	}
+	info := fileInfo(path, fi)
+	m, err := fs.readMeta(path)
+	if err != nil {
+		return nil, fs.error("stat", path, err)
+	}
+	if m != nil {
+		m.apply(info)
+	}
+	return info, nil
+}
+
+// Exists reports whether there is a file on path.
+func (fs *Filesystem) Exists(path string) (bool, error) {
+	_, err := fs.Stat(path)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func fileInfo(path string, fi os.FileInfo) *storage.FileInfo {
+	return &storage.FileInfo{
+		Path:        path,
+		Size:        fi.Size(),
+		ModTime:     fi.ModTime(),
+		ContentType: storage.ResolveContentType(path),
+		ETag:        etag(fi),
+	}
+}
+
+func etag(fi os.FileInfo) string {
+	return fmt.Sprintf("%x-%x", fi.ModTime().UnixNano(), fi.Size())
+}
+
+// List returns a page of the files under prefix, out of a walk of every
+// directory under it.
+func (fs *Filesystem) List(prefix string, opts *storage.ListOptions) (*storage.ListPage, error) {
+	return util.ListFromWalk(fs, strings.TrimPrefix(prefix, fs.path), opts)
+}
+
+// Walk calls fn for every file under prefix, in the order of filepath.Walk.
+func (fs *Filesystem) Walk(prefix string, fn storage.WalkFunc) error {
+	prefix = strings.TrimPrefix(prefix, fs.path)
+	// only descend from the deepest directory the prefix names
+	start := fs.root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		start = filepath.Join(fs.root, filepath.FromSlash(prefix[:i]))
+	}
+	// errors from fn are returned as is, only wrap our own
+	return filepath.Walk(start, func(abs string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && abs == start {
+				return nil
+			}
+			return fs.error("walk", prefix, err)
+		}
+		if fi.IsDir() {
+			if abs == filepath.Join(fs.root, metaDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ok, _ := filepath.Match(tempPattern, fi.Name()); ok {
+			return nil
+		}
+		rel, err := filepath.Rel(fs.root, abs)
+		if err != nil {
+			return fs.error("walk", prefix, err)
+		}
+		rel = filepath.ToSlash(rel)
+		if !strings.HasPrefix(rel, prefix) {
+			return nil
+		}
+		return fn(fileInfo(rel, fi))
+	})
+}
+
 func parseAccept(q url.Values) []string {
 	accept, ok := q["accept"]
 	if !ok {
@@ -1,8 +1,13 @@
 package fs
 
 import (
+	"context"
+	"errors"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/djangulo/go-storage"
@@ -20,6 +25,90 @@ func TestFilesystem(t *testing.T) {
 	storagetest.Test(t, driver)
 }
 
+func TestOpenRoots(t *testing.T) {
+	a, cleanupA := createTempDir(t, "fs_tests")
+	defer cleanupA()
+	b, cleanupB := createTempDir(t, "fs_tests")
+	defer cleanupB()
+	da, err := storage.Open("fs://irrelevant/?accept=.txt&root=" + a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Open("fs://irrelevant/?accept=.txt&root=" + b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := da.AddFile(strings.NewReader("hello world"), "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(a, "a.txt")); err != nil {
+		t.Errorf("expected a.txt under the first root, got %v", err)
+	}
+}
+
+// cancelReader cancels its context after the first Read.
+type cancelReader struct {
+	r      io.Reader
+	cancel context.CancelFunc
+}
+
+func (cr *cancelReader) Read(p []byte) (int, error) {
+	defer cr.cancel()
+	return cr.r.Read(p[:1])
+}
+
+func TestAddFileContextCancelled(t *testing.T) {
+	tmp, cleanup := createTempDir(t, "fs_tests")
+	defer cleanup()
+	driver, err := storage.Open("fs://irrelevant/?accept=.txt&root=" + tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := driver.(*Filesystem)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &cancelReader{r: strings.NewReader("hello world"), cancel: cancel}
+	_, err = fs.AddFileContext(ctx, r, "partial.txt")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v got %v", context.Canceled, err)
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "partial.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected partial file to be removed, got %v", err)
+	}
+}
+
+func TestCopyBetweenRoots(t *testing.T) {
+	srcDir, cleanupSrc := createTempDir(t, "fs_tests_src")
+	defer cleanupSrc()
+	dstDir, cleanupDst := createTempDir(t, "fs_tests_dst")
+	defer cleanupDst()
+	src, err := storage.Open("fs://irrelevant/?accept=.txt&root=" + srcDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, err := storage.Open("fs://irrelevant/?accept=.txt&root=" + dstDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &storage.PutOptions{Metadata: map[string]string{"owner": "bob"}}
+	if _, err := src.(storage.PutDriver).AddFileWithOptions(strings.NewReader("hello world"), "a.txt", opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Move(dst, "b.txt", src, "a.txt"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(srcDir, "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected source to be removed, got %v", err)
+	}
+	fi, err := dst.(storage.StatDriver).Stat("b.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fi.Size != int64(len("hello world")) || fi.Metadata["owner"] != "bob" {
+		t.Errorf("expected copied file with metadata, got %+v", fi)
+	}
+}
+
 func createTempDir(t *testing.T, name string) (string, func()) {
 	t.Helper()
 
@@ -0,0 +1,108 @@
+package fs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/djangulo/go-storage"
+)
+
+// metaDir is the directory under root holding the metadata of the files
+// added with storage.PutOptions, one JSON file per file. Walk and List skip
+// it.
+const metaDir = ".go-storage-meta"
+
+// meta is the part of storage.PutOptions that is stored along a file.
+type meta struct {
+	ContentType        string            `json:"content_type,omitempty"`
+	CacheControl       string            `json:"cache_control,omitempty"`
+	ContentDisposition string            `json:"content_disposition,omitempty"`
+	ContentEncoding    string            `json:"content_encoding,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+}
+
+// newMeta returns the meta to store for opts, or nil if there is none.
+func newMeta(opts *storage.PutOptions) *meta {
+	if opts == nil {
+		return nil
+	}
+	m := &meta{
+		ContentType:        opts.ContentType,
+		CacheControl:       opts.CacheControl,
+		ContentDisposition: opts.ContentDisposition,
+		ContentEncoding:    opts.ContentEncoding,
+	}
+	if len(opts.Metadata) > 0 {
+		m.Metadata = make(map[string]string, len(opts.Metadata))
+		for k, v := range opts.Metadata {
+			m.Metadata[strings.ToLower(k)] = v
+		}
+	}
+	if m.ContentType == "" && m.CacheControl == "" && m.ContentDisposition == "" &&
+		m.ContentEncoding == "" && m.Metadata == nil {
+		return nil
+	}
+	return m
+}
+
+func (m *meta) apply(fi *storage.FileInfo) {
+	if m.ContentType != "" {
+		fi.ContentType = m.ContentType
+	}
+	fi.CacheControl = m.CacheControl
+	fi.ContentDisposition = m.ContentDisposition
+	fi.ContentEncoding = m.ContentEncoding
+	fi.Metadata = m.Metadata
+}
+
+func (fs *Filesystem) metaPath(path string) string {
+	return filepath.Join(fs.root, metaDir, filepath.FromSlash(path)+".json")
+}
+
+// writeMeta stores m for the file on path. A nil m removes any stored meta.
+func (fs *Filesystem) writeMeta(path string, m *meta) error {
+	mp := fs.metaPath(path)
+	if m == nil {
+		if err := os.Remove(mp); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(mp), 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(mp, b, 0644)
+}
+
+// readMeta returns the meta stored for the file on path, or nil if there is
+// none.
+func (fs *Filesystem) readMeta(path string) (*meta, error) {
+	b, err := ioutil.ReadFile(fs.metaPath(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := new(meta)
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// copyMeta copies the meta stored for src, if any, to dst.
+func (fs *Filesystem) copyMeta(src, dst string) error {
+	m, err := fs.readMeta(src)
+	if err != nil {
+		return err
+	}
+	return fs.writeMeta(dst, m)
+}
@@ -0,0 +1,110 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/djangulo/go-storage"
+)
+
+// OpenWriter returns a storage.Writer that writes to a temporary file next to
+// path, which Close moves to path. With CreateOnly, the file is hard-linked
+// into place, which fails atomically if path exists.
+func (fs *Filesystem) OpenWriter(path string, opts *storage.PutOptions) (storage.Writer, error) {
+	if opts == nil {
+		opts = &storage.PutOptions{}
+	}
+	path = strings.TrimPrefix(path, fs.path)
+	absPath, err := fs.prepare(path)
+	if err != nil {
+		return nil, fs.error("add", path, err)
+	}
+	file, err := ioutil.TempFile(filepath.Dir(absPath), tempPattern)
+	if err != nil {
+		return nil, fs.error("add", path, err)
+	}
+	// TempFile creates files readable only by the owner
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, fs.error("add", path, err)
+	}
+	return &writer{fs: fs, file: file, path: path, absPath: absPath, opts: opts}, nil
+}
+
+type writer struct {
+	fs      *Filesystem
+	file    *os.File
+	path    string
+	absPath string
+	opts    *storage.PutOptions
+	closed  bool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, w.fs.error("add", w.path, storage.ErrWriterClosed)
+	}
+	n, err := w.file.Write(p)
+	if err != nil {
+		return n, w.fs.error("add", w.path, err)
+	}
+	return n, nil
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return w.fs.error("add", w.path, storage.ErrWriterClosed)
+	}
+	w.closed = true
+	err := w.file.Close()
+	if err == nil {
+		err = w.commit()
+	}
+	if err != nil {
+		os.Remove(w.file.Name())
+		return w.fs.error("add", w.path, err)
+	}
+	if err := w.fs.writeMeta(w.path, newMeta(w.opts)); err != nil {
+		return w.fs.error("add", w.path, err)
+	}
+	return nil
+}
+
+// commit moves the temporary file to absPath, if the write mode allows it.
+func (w *writer) commit() error {
+	tmp := w.file.Name()
+	if mode := w.opts.Mode; mode != storage.CreateOnly {
+		if err := checkMode(w.absPath, mode); err != nil {
+			return err
+		}
+		return os.Rename(tmp, w.absPath)
+	}
+
+	err := os.Link(tmp, w.absPath)
+	if os.IsExist(err) {
+		return storage.ErrAlreadyExists
+	}
+	if err != nil {
+		// links are not supported everywhere; this fallback is not atomic
+		if _, serr := os.Stat(w.absPath); serr == nil {
+			return storage.ErrAlreadyExists
+		}
+		return os.Rename(tmp, w.absPath)
+	}
+	return os.Remove(tmp)
+}
+
+func (w *writer) CloseWithError(err error) error {
+	if w.closed {
+		return w.fs.error("add", w.path, storage.ErrWriterClosed)
+	}
+	w.closed = true
+	w.file.Close()
+	if err := os.Remove(w.file.Name()); err != nil {
+		return w.fs.error("add", w.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,751 @@
+// Package ftp implements a storage.Driver for a directory on an FTP or FTPS
+// server.
+package ftp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+
+	"github.com/djangulo/go-storage"
+	"github.com/djangulo/go-storage/internal/util"
+)
+
+// Storage is a storage.Driver for a directory on an FTP server. FTP
+// connections run one command at a time, so Storage keeps a pool of idle
+// connections, which Close releases.
+type Storage struct {
+	config *Config
+
+	mu   sync.Mutex
+	idle []idleConn
+}
+
+type idleConn struct {
+	c     *ftp.ServerConn
+	since time.Time
+}
+
+type Config struct {
+	User string
+	// Host of the server, as host:port.
+	Host string
+	// Root is the directory the files are stored in. Relative roots are
+	// relative to the login directory of User.
+	Root string
+	// TLS is the FTPS mode: "" for plain FTP, "explicit" or "implicit".
+	TLS string
+	// InsecureSkipVerify accepts any certificate. Only meant for tests.
+	InsecureSkipVerify bool
+	// DisableEPSV makes passive transfers use PASV, for servers that
+	// mishandle EPSV.
+	DisableEPSV bool
+	password    string
+	accept      map[string]struct{}
+}
+
+const (
+	// tempPattern names the temporary files of in-progress writes, which
+	// Walk and List skip.
+	tempPattern = ".go-storage-*.tmp"
+	// maxIdle is the number of idle connections kept open.
+	maxIdle = 4
+	// idleCheck is how long a connection can stay idle before being checked
+	// with a NOOP, as servers drop idle connections.
+	idleCheck = 15 * time.Second
+)
+
+// compile-time checks of the optional interfaces Storage implements.
+var (
+	_ storage.DangerDriver  = (*Storage)(nil)
+	_ storage.ContextDriver = (*Storage)(nil)
+	_ storage.StatDriver    = (*Storage)(nil)
+	_ storage.CopyDriver    = (*Storage)(nil)
+	_ storage.ListDriver    = (*Storage)(nil)
+	_ storage.WalkDriver    = (*Storage)(nil)
+	_ storage.RangeDriver   = (*Storage)(nil)
+)
+
+func init() {
+	storage.Register("ftp", &Storage{})
+	storage.Register("ftps", &Storage{})
+}
+
+// Path returns the URL of the root directory, without credentials.
+func (s *Storage) Path() string {
+	u := url.URL{Scheme: "ftp", Host: s.config.Host, Path: s.config.Root}
+	if s.config.TLS == "implicit" {
+		u.Scheme = "ftps"
+	}
+	if !path.IsAbs(s.config.Root) {
+		u.Path = path.Join("/~", s.config.Root)
+	}
+	return u.String()
+}
+
+func (s *Storage) Accepts(ext string) (accepts bool) {
+	_, accepts = s.config.accept[ext]
+	return
+}
+
+func (s *Storage) NormalizePath(entries ...string) string {
+	return util.JoinURL(s.Path(), entries...)
+}
+
+var (
+	acceptableTLS = map[string]struct{}{
+		"explicit": {},
+		"implicit": {},
+	}
+	ErrURLParse = errors.New("error parsing url")
+)
+
+func parseURL(urlString string) (*Config, error) {
+	u, err := url.Parse(urlString)
+	if err != nil {
+		// *url.Error would print the url, password included
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrURLParse, err)
+	}
+	if (u.Scheme != "ftp" && u.Scheme != "ftps") || u.Host == "" {
+		return nil, fmt.Errorf(
+			"%w: %s does not match \"ftp://[user:password@]host[:port]/path\" format",
+			ErrURLParse,
+			util.RedactURL(urlString),
+		)
+	}
+	c := &Config{
+		User: "anonymous",
+		Host: u.Host,
+		Root: ".",
+	}
+	if u.User != nil {
+		c.User = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if u.Path == "/~" || strings.HasPrefix(u.Path, "/~/") {
+		c.Root = path.Join(".", u.Path[2:])
+	} else if u.Path != "" {
+		c.Root = path.Clean(u.Path)
+	}
+
+	q := u.Query()
+	if u.Scheme == "ftps" {
+		c.TLS = "implicit"
+	}
+	if mode := strings.ToLower(q.Get("tls")); mode != "" && mode != "none" {
+		if _, ok := acceptableTLS[mode]; !ok {
+			return nil, fmt.Errorf("%w: unknown tls value: %s", ErrURLParse, mode)
+		}
+		c.TLS = mode
+	}
+	if u.Port() == "" {
+		port := "21"
+		if c.TLS == "implicit" {
+			port = "990"
+		}
+		c.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	if c.InsecureSkipVerify, err = util.ParseBoolQuery(q, "insecure-skip-verify", false); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrURLParse, err)
+	}
+	if c.DisableEPSV, err = util.ParseBoolQuery(q, "disable-epsv", false); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrURLParse, err)
+	}
+	c.accept = util.ParseCommaSeparatedQuery(q, "accept", ".jpeg", ".jpg", ".png", ".svg")
+	return c, nil
+}
+
+// Open creates a *Storage, and connects to the server. The urlString
+// should be in the form
+// ftp://[user:password@]host[:port]/path?tls=&insecure-skip-verify=&disable-epsv=&accept=
+// Without a user, it logs in as anonymous. The path is absolute; paths
+// starting with /~/ are relative to the login directory, which is the
+// default. ftps:// is a shorthand for tls=implicit. Transfers always use
+// passive mode.
+// The URL parameters accepted are as follows:
+//   - tls: FTPS mode, either explicit (AUTH TLS on the FTP port) or implicit
+//     (TLS from the start, on port 990 by default). Default none.
+//   - insecure-skip-verify: accept any TLS certificate if true. Only meant
+//     for tests. Default false.
+//   - disable-epsv: use PASV rather than EPSV for passive transfers if true,
+//     for servers behind NATs that mishandle EPSV. Default false, although
+//     PASV is used anyway if EPSV fails.
+//   - accept: comma-separated list of file extensions to accept. Could be
+//     repeated. e.g. url://host/path?accept=.jpeg,.svg&accept=.png would
+//     accept .jpeg, .svg and .png files. Default .jpeg,.jpg,.png,.svg
+func (s *Storage) Open(urlString string) (storage.Driver, error) {
+	return s.OpenContext(context.Background(), urlString)
+}
+
+// OpenContext is the context-aware version of Open. ctx governs the
+// connection to the server and the creation of the root directory.
+func (s *Storage) OpenContext(ctx context.Context, urlString string) (storage.Driver, error) {
+	var err error
+
+	ns := new(Storage)
+
+	ns.config, err = parseURL(urlString)
+	if err != nil {
+		return nil, s.error("open", "", err)
+	}
+	c, err := ns.conn(ctx)
+	if err != nil {
+		return nil, s.error("open", "", err)
+	}
+	err = mkdirAll(c, ns.config.Root)
+	ns.release(c, err)
+	if err != nil {
+		ns.Close()
+		return nil, s.error("open", "", err)
+	}
+
+	return ns, nil
+}
+
+// conn returns an idle connection of s, or a new one.
+func (s *Storage) conn(ctx context.Context) (*ftp.ServerConn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	for len(s.idle) > 0 {
+		ic := s.idle[len(s.idle)-1]
+		s.idle = s.idle[:len(s.idle)-1]
+		if time.Since(ic.since) < idleCheck {
+			s.mu.Unlock()
+			return ic.c, nil
+		}
+		s.mu.Unlock()
+		if err := ic.c.NoOp(); err == nil {
+			return ic.c, nil
+		}
+		ic.c.Quit()
+		s.mu.Lock()
+	}
+	s.mu.Unlock()
+	return s.dial(ctx)
+}
+
+func (s *Storage) dial(ctx context.Context) (*ftp.ServerConn, error) {
+	opts := []ftp.DialOption{
+		ftp.DialWithContext(ctx),
+		ftp.DialWithTimeout(30 * time.Second),
+		ftp.DialWithDisabledEPSV(s.config.DisableEPSV),
+	}
+	if s.config.TLS != "" {
+		host, _, _ := net.SplitHostPort(s.config.Host)
+		cfg := &tls.Config{ServerName: host, InsecureSkipVerify: s.config.InsecureSkipVerify}
+		if s.config.TLS == "explicit" {
+			opts = append(opts, ftp.DialWithExplicitTLS(cfg))
+		} else {
+			opts = append(opts, ftp.DialWithTLS(cfg))
+		}
+	}
+	c, err := ftp.Dial(s.config.Host, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Login(s.config.User, s.config.password); err != nil {
+		c.Quit()
+		return nil, err
+	}
+	return c, nil
+}
+
+// release returns c to the pool, unless err, the result of its last
+// command, means the connection may be broken.
+func (s *Storage) release(c *ftp.ServerConn, err error) {
+	if !reusable(err) {
+		c.Quit()
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.idle) >= maxIdle {
+		c.Quit()
+		return
+	}
+	s.idle = append(s.idle, idleConn{c: c, since: time.Now()})
+}
+
+// reusable reports whether a connection whose last command failed with err
+// can run further commands.
+func reusable(err error) bool {
+	var terr *textproto.Error
+	return err == nil || errors.As(err, &terr) ||
+		errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrAlreadyExists)
+}
+
+// remote returns the path of p on the server.
+func (s *Storage) remote(p string) string {
+	return path.Join(s.config.Root, p)
+}
+
+// rel returns the path, relative to the root, of the remote path p.
+func (s *Storage) rel(p string) string {
+	if s.config.Root == "." {
+		return p
+	}
+	return strings.TrimPrefix(p, strings.TrimSuffix(s.config.Root, "/")+"/")
+}
+
+func (s *Storage) error(op, p string, err error) error {
+	var terr *textproto.Error
+	if errors.As(err, &terr) {
+		err = &replyError{terr}
+	}
+	return &storage.Error{Op: op, Driver: "ftp", Path: p, Err: err}
+}
+
+// replyError is an error reply of the server. Replies in the 4xx range are
+// transient, as per RFC 959.
+type replyError struct {
+	err *textproto.Error
+}
+
+func (e *replyError) Error() string   { return e.err.Error() }
+func (e *replyError) Unwrap() error   { return e.err }
+func (e *replyError) Retryable() bool { return e.err.Code >= 400 && e.err.Code < 500 }
+
+// unavailable reports whether err is a "file unavailable" reply, which
+// servers send for missing files.
+func unavailable(err error) bool {
+	var terr *textproto.Error
+	return errors.As(err, &terr) && terr.Code == ftp.StatusFileUnavailable
+}
+
+// Close closes the idle connections of s. s remains usable, and opens new
+// connections as needed.
+func (s *Storage) Close() error {
+	s.mu.Lock()
+	idle := s.idle
+	s.idle = nil
+	s.mu.Unlock()
+	var err error
+	for _, ic := range idle {
+		if qerr := ic.c.Quit(); qerr != nil && err == nil {
+			err = s.error("close", "", qerr)
+		}
+	}
+	return err
+}
+
+// AddFile saves the contents of r to a temporary file, which is renamed to
+// path once complete, so readers never see a partial file. FTP cannot fail
+// a rename if its target exists, so the check for an existing file is not
+// atomic. Missing directories are created.
+func (s *Storage) AddFile(r io.Reader, p string) (string, error) {
+	return s.AddFileContext(context.Background(), r, p)
+}
+
+// AddFileContext is the context-aware version of AddFile. The upload stops
+// as soon as ctx is done, and the temporary file is removed.
+func (s *Storage) AddFileContext(ctx context.Context, r io.Reader, p string) (string, error) {
+	if ext := filepath.Ext(p); !s.Accepts(ext) {
+		return "", s.error("add", p, fmt.Errorf("%w %s", storage.ErrInvalidExtension, ext))
+	}
+	c, err := s.conn(ctx)
+	if err != nil {
+		return "", s.error("add", p, err)
+	}
+	err = s.store(ctx, c, util.ContextReader(ctx, r), s.remote(p))
+	s.release(c, err)
+	if err != nil {
+		return "", s.error("add", p, err)
+	}
+	return s.NormalizePath(p), nil
+}
+
+// store uploads r to a temporary file next to dst, and renames it to dst
+// unless dst exists.
+func (s *Storage) store(ctx context.Context, c *ftp.ServerConn, r io.Reader, dst string) error {
+	if _, err := stat(c, dst); err == nil {
+		return storage.ErrAlreadyExists
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	dir := path.Dir(dst)
+	if err := mkdirAll(c, dir); err != nil {
+		return err
+	}
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return err
+	}
+	tmp := path.Join(dir, strings.Replace(tempPattern, "*", hex.EncodeToString(b[:]), 1))
+
+	err := c.Stor(tmp, r)
+	if err == nil {
+		if _, serr := stat(c, dst); serr == nil {
+			err = storage.ErrAlreadyExists
+		} else {
+			err = c.Rename(tmp, dst)
+		}
+	}
+	if err != nil {
+		c.Delete(tmp)
+		return err
+	}
+	return nil
+}
+
+// mkdirAll creates dir and its missing parents.
+func mkdirAll(c *ftp.ServerConn, dir string) error {
+	if dir == "." || dir == "/" {
+		return nil
+	}
+	if _, err := c.List(dir); err == nil {
+		return nil
+	}
+	if err := mkdirAll(c, path.Dir(dir)); err != nil {
+		return err
+	}
+	if err := c.MakeDir(dir); err != nil {
+		// created concurrently, maybe
+		if _, lerr := c.List(dir); lerr != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copy copies the file on src to dst. FTP has no server-side copy, so the
+// contents go through the client, over a second connection.
+func (s *Storage) Copy(src, dst string) error {
+	rc, err := s.GetFile(src)
+	if err != nil {
+		var se *storage.Error
+		if errors.As(err, &se) {
+			se.Op = "copy"
+		}
+		return err
+	}
+	defer rc.Close()
+	if _, err := s.AddFile(rc, dst); err != nil {
+		var se *storage.Error
+		if errors.As(err, &se) {
+			se.Op = "copy"
+		}
+		return err
+	}
+	return nil
+}
+
+// Move renames the file on src to dst, on the server. As with AddFile, the
+// check for an existing dst is not atomic.
+func (s *Storage) Move(src, dst string) error {
+	if ext := filepath.Ext(dst); !s.Accepts(ext) {
+		return s.error("move", dst, fmt.Errorf("%w %s", storage.ErrInvalidExtension, ext))
+	}
+	c, err := s.conn(context.Background())
+	if err != nil {
+		return s.error("move", src, err)
+	}
+	err = s.move(c, s.remote(src), s.remote(dst))
+	s.release(c, err)
+	if err != nil {
+		return s.error("move", src, err)
+	}
+	return nil
+}
+
+func (s *Storage) move(c *ftp.ServerConn, src, dst string) error {
+	if _, err := stat(c, src); err != nil {
+		return err
+	}
+	if _, err := stat(c, dst); err == nil {
+		return storage.ErrAlreadyExists
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	if err := mkdirAll(c, path.Dir(dst)); err != nil {
+		return err
+	}
+	return c.Rename(src, dst)
+}
+
+func (s *Storage) RemoveFile(p string) error {
+	return s.RemoveFileContext(context.Background(), p)
+}
+
+// RemoveFileContext is the context-aware version of RemoveFile. The context
+// is only checked before the request.
+func (s *Storage) RemoveFileContext(ctx context.Context, p string) error {
+	c, err := s.conn(ctx)
+	if err != nil {
+		return s.error("remove", p, err)
+	}
+	err = c.Delete(s.remote(p))
+	s.release(c, err)
+	if err != nil && !unavailable(err) {
+		return s.error("remove", p, err)
+	}
+	return nil
+}
+
+func (s *Storage) GetFile(p string) (io.ReadCloser, error) {
+	return s.GetFileContext(context.Background(), p)
+}
+
+// GetFileContext is the context-aware version of GetFile. ctx also governs
+// reads from the returned body, through its deadline.
+func (s *Storage) GetFileContext(ctx context.Context, p string) (io.ReadCloser, error) {
+	return s.retr(ctx, "get", p, 0, -1)
+}
+
+// GetFileRange returns length bytes of the file on path starting at offset,
+// or the rest of the file if length is negative.
+func (s *Storage) GetFileRange(p string, offset, length int64) (io.ReadCloser, error) {
+	return s.retr(context.Background(), "get", p, offset, length)
+}
+
+// retr opens the file on p from offset, through REST. Servers differ on
+// offsets past the end, so those are answered without a transfer.
+func (s *Storage) retr(ctx context.Context, op, p string, offset, length int64) (io.ReadCloser, error) {
+	c, err := s.conn(ctx)
+	if err != nil {
+		return nil, s.error(op, p, err)
+	}
+	if offset > 0 || length == 0 {
+		fi, err := stat(c, s.remote(p))
+		if err == nil && (offset >= fi.Size || length == 0) {
+			s.release(c, nil)
+			return ioutil.NopCloser(strings.NewReader("")), nil
+		}
+		if err != nil {
+			s.release(c, err)
+			return nil, s.error(op, p, err)
+		}
+	}
+	resp, err := c.RetrFrom(s.remote(p), uint64(offset))
+	if err != nil {
+		s.release(c, err)
+		if unavailable(err) {
+			err = storage.ErrNotFound
+		}
+		return nil, s.error(op, p, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		resp.SetDeadline(deadline)
+	}
+	body := &response{Reader: resp, resp: resp, c: c, s: s}
+	if length >= 0 {
+		body.Reader = io.LimitReader(resp, length)
+		body.limited = true
+	}
+	return util.ContextReadCloser(ctx, body), nil
+}
+
+// response is the body of a transfer, which releases its connection on
+// Close.
+type response struct {
+	io.Reader
+	resp    *ftp.Response
+	c       *ftp.ServerConn
+	s       *Storage
+	limited bool
+	closed  bool
+}
+
+func (r *response) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	err := r.resp.Close()
+	r.s.release(r.c, err)
+	// closing a ranged transfer before its end aborts it, which servers
+	// report as an error
+	if err != nil && !r.limited {
+		return err
+	}
+	return nil
+}
+
+// Stat returns the FileInfo of the file on path, out of the listing of its
+// directory. The ETag is derived from the size and modification time of the
+// file, whose precision depends on the server. The content type is derived
+// from its extension.
+func (s *Storage) Stat(p string) (*storage.FileInfo, error) {
+	c, err := s.conn(context.Background())
+	if err != nil {
+		return nil, s.error("stat", p, err)
+	}
+	fi, err := stat(c, s.remote(p))
+	s.release(c, err)
+	if err != nil {
+		return nil, s.error("stat", p, err)
+	}
+	fi.Path = p
+	fi.ContentType = storage.ResolveContentType(p)
+	return fi, nil
+}
+
+// stat returns the FileInfo of the remote path p, without Path and
+// ContentType.
+func stat(c *ftp.ServerConn, p string) (*storage.FileInfo, error) {
+	entries, err := c.List(path.Dir(p))
+	if unavailable(err) {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	name := path.Base(p)
+	for _, e := range entries {
+		if e.Name == name && e.Type == ftp.EntryTypeFile {
+			return fileInfo("", e), nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+// Exists reports whether there is a file on path.
+func (s *Storage) Exists(p string) (bool, error) {
+	_, err := s.Stat(p)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func fileInfo(p string, e *ftp.Entry) *storage.FileInfo {
+	return &storage.FileInfo{
+		Path:        p,
+		Size:        int64(e.Size),
+		ModTime:     e.Time,
+		ContentType: storage.ResolveContentType(p),
+		ETag:        fmt.Sprintf("%x-%x", e.Time.UnixNano(), e.Size),
+	}
+}
+
+// List returns a page of the files under prefix, out of a walk of every
+// directory under it, as FTP servers cannot page through a listing.
+func (s *Storage) List(prefix string, opts *storage.ListOptions) (*storage.ListPage, error) {
+	return util.ListFromWalk(s, prefix, opts)
+}
+
+// Walk calls fn for every file under prefix, listing directories
+// depth-first. fn runs while a connection is held for the walk, so calls
+// on s from fn use another one.
+func (s *Storage) Walk(prefix string, fn storage.WalkFunc) error {
+	c, err := s.conn(context.Background())
+	if err != nil {
+		return s.error("walk", prefix, err)
+	}
+	err = s.walk(c, prefix, fn)
+	if ferr, ok := err.(*util.FnError); ok {
+		s.release(c, nil)
+		return ferr.Err
+	}
+	s.release(c, err)
+	if err != nil {
+		return s.error("walk", prefix, err)
+	}
+	return nil
+}
+
+func (s *Storage) walk(c *ftp.ServerConn, prefix string, fn storage.WalkFunc) error {
+	// only descend from the deepest directory the prefix names
+	start := s.config.Root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		start = s.remote(prefix[:i])
+	}
+	walker := c.Walk(start)
+	for walker.Next() {
+		e := walker.Stat()
+		if e.Type != ftp.EntryTypeFile {
+			continue
+		}
+		if ok, _ := path.Match(tempPattern, e.Name); ok {
+			continue
+		}
+		rel := s.rel(walker.Path())
+		if !strings.HasPrefix(rel, prefix) {
+			continue
+		}
+		if err := fn(fileInfo(rel, e)); err != nil {
+			return &util.FnError{Err: err}
+		}
+	}
+	if err := walker.Err(); err != nil {
+		// the walker reports the start directory with a trailing slash
+		if unavailable(err) && strings.TrimSuffix(walker.Path(), "/") == start {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// EmptyContainer removes every file and directory under the root.
+func (s *Storage) EmptyContainer() error {
+	if err := s.removeAll(false); err != nil {
+		return s.error("empty", "", err)
+	}
+	return nil
+}
+
+// DeleteContainer removes the root directory, along with its contents.
+func (s *Storage) DeleteContainer() error {
+	if err := s.removeAll(true); err != nil {
+		return s.error("delete", "", err)
+	}
+	return nil
+}
+
+// removeAll removes the contents of the root, and the root itself if self
+// is set.
+func (s *Storage) removeAll(self bool) error {
+	c, err := s.conn(context.Background())
+	if err != nil {
+		return err
+	}
+	err = func() error {
+		var dirs []string
+		walker := c.Walk(s.config.Root)
+		for walker.Next() {
+			switch walker.Stat().Type {
+			case ftp.EntryTypeFolder:
+				dirs = append(dirs, walker.Path())
+			default:
+				if err := c.Delete(walker.Path()); err != nil {
+					return err
+				}
+			}
+		}
+		if err := walker.Err(); err != nil {
+			return err
+		}
+		if self {
+			dirs = append([]string{s.config.Root}, dirs...)
+		}
+		// the walk is depth-first, so children come after their parents
+		for i := len(dirs) - 1; i >= 0; i-- {
+			if err := c.RemoveDir(dirs[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	s.release(c, err)
+	return err
+}
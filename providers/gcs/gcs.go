@@ -0,0 +1,712 @@
+// Package gcs implements a storage.Driver for Google Cloud Storage.
+package gcs
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	gcssdk "cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+
+	"github.com/djangulo/go-storage"
+	"github.com/djangulo/go-storage/internal/util"
+)
+
+// Storage is a storage.Driver for a Google Cloud Storage bucket.
+type Storage struct {
+	client *gcssdk.Client
+	bucket *gcssdk.BucketHandle
+	config *Config
+}
+
+type Config struct {
+	AutoBucketCreate bool
+	Bucket           string
+	Prefix           string
+	// Project the bucket is created in, if it does not exist.
+	Project string
+	// FileACL is the predefined ACL of uploaded files, e.g. "publicRead".
+	FileACL string
+	// CredentialsFile is the path to a service account JSON key. Empty for
+	// Application Default Credentials.
+	CredentialsFile string
+	// EmulatorHost is the host[:port] of a GCS emulator, served over plain
+	// HTTP.
+	EmulatorHost string
+	accept       map[string]struct{}
+}
+
+// compile-time checks of the optional interfaces Storage implements.
+var (
+	_ storage.DangerDriver  = (*Storage)(nil)
+	_ storage.ContextDriver = (*Storage)(nil)
+	_ storage.StatDriver    = (*Storage)(nil)
+	_ storage.PutDriver     = (*Storage)(nil)
+	_ storage.CopyDriver    = (*Storage)(nil)
+	_ storage.ListDriver    = (*Storage)(nil)
+	_ storage.WalkDriver    = (*Storage)(nil)
+	_ storage.RangeDriver   = (*Storage)(nil)
+	_ storage.WriterDriver  = (*Storage)(nil)
+)
+
+func init() {
+	storage.Register("gcs", &Storage{})
+}
+
+// Path returns the public URL of the prefix.
+func (s *Storage) Path() string {
+	return util.JoinURL(s.config.baseURL(), s.config.Prefix)
+}
+
+func (c *Config) baseURL() string {
+	if c.EmulatorHost != "" {
+		return fmt.Sprintf("http://%s/%s", c.EmulatorHost, c.Bucket)
+	}
+	return fmt.Sprintf("https://storage.googleapis.com/%s", c.Bucket)
+}
+
+func (s *Storage) Accepts(ext string) (accepts bool) {
+	_, accepts = s.config.accept[ext]
+	return
+}
+
+func (s *Storage) NormalizePath(entries ...string) string {
+	return util.JoinURL(s.Path(), entries...)
+}
+
+var (
+	// predefinedACL maps the lower-cased predefined ACLs to the form GCS
+	// expects.
+	predefinedACL = map[string]string{
+		"authenticatedread":      "authenticatedRead",
+		"bucketownerfullcontrol": "bucketOwnerFullControl",
+		"bucketownerread":        "bucketOwnerRead",
+		"private":                "private",
+		"projectprivate":         "projectPrivate",
+		"publicread":             "publicRead",
+	}
+	acceptableAutoCreate = map[string]struct{}{
+		"0":       {},
+		"false":   {},
+		"nil":     {},
+		"disable": {},
+		"none":    {},
+		"off":     {},
+	}
+	ErrURLParse = errors.New("error parsing url")
+)
+
+// parseACL returns the predefined ACL named acl, which is case-insensitive
+// and may be dashed, so both "publicRead" and "public-read" work.
+func parseACL(acl string) (string, bool) {
+	acl, ok := predefinedACL[strings.ToLower(strings.Replace(acl, "-", "", -1))]
+	return acl, ok
+}
+
+func parseURL(urlString string) (*Config, error) {
+	u, err := url.Parse(urlString)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrURLParse, err)
+	}
+	if u.Scheme != "gcs" || u.Host == "" {
+		return nil, fmt.Errorf(
+			"%w: %s does not match \"gcs://bucket/prefix\" format",
+			ErrURLParse,
+			urlString,
+		)
+	}
+	c := &Config{
+		AutoBucketCreate: true,
+		Bucket:           u.Host,
+		Prefix:           "/" + strings.Trim(u.Path, "/"),
+	}
+
+	q := u.Query()
+	if acl := q.Get("acl"); acl != "" {
+		var ok bool
+		if c.FileACL, ok = parseACL(acl); !ok {
+			return nil, fmt.Errorf("%w: unknown acl: %s", ErrURLParse, acl)
+		}
+	}
+	if ac := q.Get("auto-create"); ac != "" {
+		ac = strings.ToLower(ac)
+		if _, ok := acceptableAutoCreate[ac]; ok {
+			c.AutoBucketCreate = false
+		} else {
+			return nil, fmt.Errorf("%w: unknown auto-create value: %s", ErrURLParse, ac)
+		}
+	}
+	c.Project = q.Get("project")
+	c.CredentialsFile = q.Get("credentials")
+	if host := q.Get("emulator-host"); host != "" {
+		if i := strings.Index(host, "://"); i >= 0 {
+			host = host[i+3:]
+		}
+		c.EmulatorHost = strings.TrimSuffix(host, "/")
+	}
+	c.accept = util.ParseCommaSeparatedQuery(q, "accept", ".jpeg", ".jpg", ".png", ".svg")
+	return c, nil
+}
+
+// Open creates a *Storage. The urlString should be in the form
+// gcs://bucket/prefix?credentials=&project=&emulator-host=&accept=&auto-create=&acl=
+// Without credentials, Application Default Credentials are used, i.e. the
+// GOOGLE_APPLICATION_CREDENTIALS environment variable, the gcloud SDK
+// credentials or the metadata server.
+// The URL parameters accepted are as follows:
+//   - credentials: path to a service account JSON key file.
+//   - project: ID of the project the bucket is created in by auto-create.
+//   - emulator-host: host[:port] of a GCS emulator, such as
+//     fake-gcs-server, reached over plain HTTP without credentials. The
+//     STORAGE_EMULATOR_HOST environment variable works as well.
+//   - accept: comma-separated list of file extensions to accept. Could be
+//     repeated. e.g. url://bucket/prefix?accept=.jpeg,.svg&accept=.png would
+//     accept .jpeg, .svg and .png files. Default .jpeg,.jpg,.png,.svg
+//   - auto-create: will NOT create the bucket automatically if this value is
+//     any of: 0, off, disable, false.
+//   - acl: predefined ACL for file uploads, one of authenticatedRead,
+//     bucketOwnerFullControl, bucketOwnerRead, private, projectPrivate or
+//     publicRead. Default none, which applies the bucket default.
+func (s *Storage) Open(urlString string) (storage.Driver, error) {
+	return s.OpenContext(context.Background(), urlString)
+}
+
+// OpenContext is the context-aware version of Open. ctx governs the bucket
+// lookup and creation.
+func (s *Storage) OpenContext(ctx context.Context, urlString string) (storage.Driver, error) {
+	var err error
+
+	ns := new(Storage)
+
+	ns.config, err = parseURL(urlString)
+	if err != nil {
+		return nil, s.error("open", "", err)
+	}
+	var opts []option.ClientOption
+	if ns.config.EmulatorHost != "" {
+		opts = append(opts,
+			option.WithEndpoint("http://"+ns.config.EmulatorHost+"/storage/v1/"),
+			option.WithHTTPClient(&http.Client{Transport: emulatorTransport{}}),
+		)
+	} else if ns.config.CredentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(ns.config.CredentialsFile))
+	}
+	// the client outlives ctx
+	ns.client, err = gcssdk.NewClient(context.Background(), opts...)
+	if err != nil {
+		return nil, s.error("open", "", err)
+	}
+	ns.bucket = ns.client.Bucket(ns.config.Bucket)
+
+	_, err = ns.bucket.Attrs(ctx)
+	if errors.Is(err, gcssdk.ErrBucketNotExist) {
+		if !ns.config.AutoBucketCreate {
+			ns.client.Close()
+			return nil, s.error("open", "", errors.New("bucket does not exist; AutoBucketCreation is off"))
+		}
+		err = ns.bucket.Create(ctx, ns.config.Project, nil)
+	}
+	if err != nil {
+		ns.client.Close()
+		return nil, s.error("open", "", err)
+	}
+
+	return ns, nil
+}
+
+// emulatorTransport sends every request over plain HTTP. The client reads
+// objects through https://<endpoint host>/bucket/object, regardless of the
+// scheme of the endpoint.
+type emulatorTransport struct{}
+
+func (emulatorTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.URL.Scheme == "https" {
+		u := *r.URL
+		u.Scheme = "http"
+		r = r.Clone(r.Context())
+		r.URL = &u
+	}
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// key returns the object name of p, which has no leading slash.
+func (s *Storage) key(p string) string {
+	return strings.TrimPrefix(path.Join(s.config.Prefix, p), "/")
+}
+
+// root is the name prefix of the objects of s.
+func (s *Storage) root() string {
+	return strings.TrimPrefix(s.config.Prefix, "/")
+}
+
+func (s *Storage) object(p string) *gcssdk.ObjectHandle {
+	return s.bucket.Object(s.key(p))
+}
+
+func (s *Storage) error(op, p string, err error) error {
+	return &storage.Error{Op: op, Driver: "gcs", Path: p, Err: gcsError(err)}
+}
+
+// Close closes the underlying client.
+func (s *Storage) Close() error {
+	if s.client == nil {
+		return nil
+	}
+	return s.client.Close()
+}
+
+func (s *Storage) AddFile(r io.Reader, p string) (string, error) {
+	return s.addFile(context.Background(), r, p, nil)
+}
+
+// AddFileContext is the context-aware version of AddFile. Cancelling ctx
+// aborts the upload.
+func (s *Storage) AddFileContext(ctx context.Context, r io.Reader, p string) (string, error) {
+	return s.addFile(ctx, r, p, nil)
+}
+
+// AddFileWithOptions is AddFile, configured through opts. The write mode is
+// sent as a generation precondition, which GCS enforces atomically.
+func (s *Storage) AddFileWithOptions(r io.Reader, p string, opts *storage.PutOptions) (string, error) {
+	return s.addFile(context.Background(), r, p, opts)
+}
+
+func (s *Storage) addFile(ctx context.Context, r io.Reader, p string, opts *storage.PutOptions) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := s.newWriter(ctx, p, opts)
+	if err != nil {
+		return "", s.error("add", p, err)
+	}
+	if _, err := io.Copy(w, util.ContextReader(ctx, r)); err != nil {
+		// cancelling ctx aborts the upload
+		cancel()
+		w.Close()
+		return "", s.error("add", p, err)
+	}
+	if err := w.Close(); err != nil {
+		return "", s.error("add", p, writeError(err, opts))
+	}
+	return s.NormalizePath(p), nil
+}
+
+// newWriter returns a writer for the object on p, conditioned on the write
+// mode of opts. The write mode is checked beforehand as well, as IfMatch
+// compares ETags, and GCS preconditions compare generations.
+func (s *Storage) newWriter(ctx context.Context, p string, opts *storage.PutOptions) (*gcssdk.Writer, error) {
+	if opts == nil {
+		opts = &storage.PutOptions{}
+	}
+	acl, err := s.validate(p, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	obj := s.object(p)
+	if !opts.Mode.Overwrites() {
+		var existing *storage.FileInfo
+		attrs, err := obj.Attrs(ctx)
+		switch {
+		case err == nil:
+			existing = s.fileInfo(attrs)
+		case !errors.Is(err, gcssdk.ErrObjectNotExist):
+			return nil, err
+		}
+		if err := opts.Mode.Check(existing); err != nil {
+			return nil, err
+		}
+		if existing == nil {
+			obj = obj.If(gcssdk.Conditions{DoesNotExist: true})
+		} else {
+			obj = obj.If(gcssdk.Conditions{GenerationMatch: attrs.Generation})
+		}
+	}
+
+	w := obj.NewWriter(ctx)
+	w.ContentType = opts.ResolveContentType(p)
+	w.CacheControl = opts.CacheControl
+	w.ContentDisposition = opts.ContentDisposition
+	w.ContentEncoding = opts.ContentEncoding
+	w.PredefinedACL = acl
+	if len(opts.Metadata) > 0 {
+		w.Metadata = make(map[string]string, len(opts.Metadata))
+		for k, v := range opts.Metadata {
+			w.Metadata[strings.ToLower(k)] = v
+		}
+	}
+	return w, nil
+}
+
+// validate checks the extension of p and the ACL of opts, and returns the
+// predefined ACL to upload p with.
+func (s *Storage) validate(p string, opts *storage.PutOptions) (string, error) {
+	if ext := filepath.Ext(p); !s.Accepts(ext) {
+		return "", fmt.Errorf("%w %s", storage.ErrInvalidExtension, ext)
+	}
+	if opts.ACL == "" {
+		return s.config.FileACL, nil
+	}
+	acl, ok := parseACL(opts.ACL)
+	if !ok {
+		return "", fmt.Errorf("unknown acl: %s", opts.ACL)
+	}
+	return acl, nil
+}
+
+// writeError maps a failed precondition on a write with opts to the error
+// of its write mode.
+func writeError(err error, opts *storage.PutOptions) error {
+	if !errors.Is(gcsError(err), storage.ErrPreconditionFailed) {
+		return err
+	}
+	if opts == nil || opts.Mode == storage.CreateOnly {
+		return storage.ErrAlreadyExists
+	}
+	return fmt.Errorf("%w: %s", storage.ErrPreconditionFailed, opts.Mode)
+}
+
+// OpenWriter returns a storage.Writer that uploads to the object on p as it
+// is written. The write mode is checked on the first Write, and the object
+// is only created on Close; CloseWithError aborts the upload.
+func (s *Storage) OpenWriter(p string, opts *storage.PutOptions) (storage.Writer, error) {
+	if opts == nil {
+		opts = &storage.PutOptions{}
+	}
+	if _, err := s.validate(p, opts); err != nil {
+		return nil, s.error("add", p, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &writer{s: s, ctx: ctx, cancel: cancel, path: p, opts: opts}, nil
+}
+
+type writer struct {
+	s      *Storage
+	w      *gcssdk.Writer
+	ctx    context.Context
+	cancel context.CancelFunc
+	path   string
+	opts   *storage.PutOptions
+	// err is the error of opening w, returned by every call after it.
+	err    error
+	closed bool
+}
+
+// open opens the underlying writer, if it is not open yet.
+func (w *writer) open() error {
+	if w.w == nil && w.err == nil {
+		w.w, w.err = w.s.newWriter(w.ctx, w.path, w.opts)
+	}
+	return w.err
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, w.s.error("add", w.path, storage.ErrWriterClosed)
+	}
+	if err := w.open(); err != nil {
+		return 0, w.s.error("add", w.path, err)
+	}
+	n, err := w.w.Write(p)
+	if err != nil {
+		return n, w.s.error("add", w.path, err)
+	}
+	return n, nil
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return w.s.error("add", w.path, storage.ErrWriterClosed)
+	}
+	w.closed = true
+	defer w.cancel()
+	if err := w.open(); err != nil {
+		return w.s.error("add", w.path, err)
+	}
+	if err := w.w.Close(); err != nil {
+		return w.s.error("add", w.path, writeError(err, w.opts))
+	}
+	return nil
+}
+
+func (w *writer) CloseWithError(err error) error {
+	if w.closed {
+		return w.s.error("add", w.path, storage.ErrWriterClosed)
+	}
+	w.closed = true
+	w.cancel()
+	if w.w != nil {
+		w.w.Close()
+	}
+	return nil
+}
+
+func (s *Storage) RemoveFile(p string) error {
+	return s.RemoveFileContext(context.Background(), p)
+}
+
+// RemoveFileContext is the context-aware version of RemoveFile.
+func (s *Storage) RemoveFileContext(ctx context.Context, p string) error {
+	err := s.object(p).Delete(ctx)
+	if err != nil && !errors.Is(err, gcssdk.ErrObjectNotExist) {
+		return s.error("remove", p, err)
+	}
+	return nil
+}
+
+// Stat returns the FileInfo of the object on p.
+func (s *Storage) Stat(p string) (*storage.FileInfo, error) {
+	attrs, err := s.object(p).Attrs(context.Background())
+	if err != nil {
+		return nil, s.error("stat", p, err)
+	}
+	return s.fileInfo(attrs), nil
+}
+
+// fileInfo converts attrs into a FileInfo. The ETag is the hex-encoded MD5
+// of the contents, like on S3, as the ETag GCS reports also changes with the
+// metadata. Composite objects, which have no MD5, report their CRC32C.
+func (s *Storage) fileInfo(attrs *gcssdk.ObjectAttrs) *storage.FileInfo {
+	fi := &storage.FileInfo{
+		Path:               relativePath(s.root(), attrs.Name),
+		Size:               attrs.Size,
+		ModTime:            attrs.Updated,
+		ContentType:        attrs.ContentType,
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentEncoding:    attrs.ContentEncoding,
+		ETag:               hex.EncodeToString(attrs.MD5),
+	}
+	if len(attrs.MD5) == 0 {
+		fi.ETag = fmt.Sprintf("%08x", attrs.CRC32C)
+	}
+	if len(attrs.Metadata) > 0 {
+		fi.Metadata = make(map[string]string, len(attrs.Metadata))
+		for k, v := range attrs.Metadata {
+			fi.Metadata[strings.ToLower(k)] = v
+		}
+	}
+	return fi
+}
+
+func relativePath(root, name string) string {
+	if root == "" {
+		return name
+	}
+	return strings.TrimPrefix(name, root+"/")
+}
+
+// Exists reports whether there is an object on p.
+func (s *Storage) Exists(p string) (bool, error) {
+	_, err := s.Stat(p)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// List returns a page of the objects under prefix.
+func (s *Storage) List(prefix string, opts *storage.ListOptions) (*storage.ListPage, error) {
+	if opts == nil {
+		opts = &storage.ListOptions{}
+	}
+	max := opts.MaxResults
+	if max <= 0 {
+		max = util.DefaultMaxResults
+	}
+	it := s.bucket.Objects(context.Background(), &gcssdk.Query{
+		Prefix:    util.ObjectKey(s.root(), prefix),
+		Delimiter: opts.Delimiter,
+	})
+	var attrs []*gcssdk.ObjectAttrs
+	token, err := iterator.NewPager(it, max, opts.Token).NextPage(&attrs)
+	if err != nil {
+		return nil, s.error("list", prefix, err)
+	}
+	page := &storage.ListPage{
+		Files:     make([]*storage.FileInfo, 0, len(attrs)),
+		NextToken: token,
+	}
+	for _, a := range attrs {
+		// prefixes come as attributes with only the Prefix set
+		if a.Prefix != "" {
+			page.Prefixes = append(page.Prefixes, relativePath(s.root(), a.Prefix))
+			continue
+		}
+		page.Files = append(page.Files, s.fileInfo(a))
+	}
+	return page, nil
+}
+
+// Walk calls fn for every object under prefix, in lexicographical order.
+func (s *Storage) Walk(prefix string, fn storage.WalkFunc) error {
+	it := s.bucket.Objects(context.Background(), &gcssdk.Query{
+		Prefix: util.ObjectKey(s.root(), prefix),
+	})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return s.error("walk", prefix, err)
+		}
+		if err := fn(s.fileInfo(attrs)); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Storage) GetFile(p string) (io.ReadCloser, error) {
+	return s.GetFileContext(context.Background(), p)
+}
+
+// GetFileContext is the context-aware version of GetFile. ctx also governs
+// reads from the returned body.
+func (s *Storage) GetFileContext(ctx context.Context, p string) (io.ReadCloser, error) {
+	r, err := s.object(p).NewReader(ctx)
+	if err != nil {
+		return nil, s.error("get", p, err)
+	}
+	return util.ContextReadCloser(ctx, r), nil
+}
+
+// GetFileRange returns length bytes of the object on p starting at offset,
+// or the rest of the object if length is negative. Combined with Stat, it
+// makes storage.GetFileSeeker work.
+func (s *Storage) GetFileRange(p string, offset, length int64) (io.ReadCloser, error) {
+	if length > math.MaxInt64-offset {
+		// the client computes the end of the range, read to the end instead
+		length = -1
+	}
+	r, err := s.object(p).NewRangeReader(context.Background(), offset, length)
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) && gerr.Code == http.StatusRequestedRangeNotSatisfiable {
+		// offset is past the end of the object
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	if err != nil {
+		return nil, s.error("get", p, err)
+	}
+	return r, nil
+}
+
+// Copy copies the object on src to dst, without downloading it. It fails
+// with storage.ErrAlreadyExists if dst exists.
+func (s *Storage) Copy(src, dst string) error {
+	if ext := filepath.Ext(dst); !s.Accepts(ext) {
+		return s.error("copy", dst, fmt.Errorf("%w %s", storage.ErrInvalidExtension, ext))
+	}
+	ctx := context.Background()
+	if _, err := s.object(dst).Attrs(ctx); err == nil {
+		return s.error("copy", dst, storage.ErrAlreadyExists)
+	} else if !errors.Is(err, gcssdk.ErrObjectNotExist) {
+		return s.error("copy", dst, err)
+	}
+	c := s.object(dst).If(gcssdk.Conditions{DoesNotExist: true}).CopierFrom(s.object(src))
+	c.PredefinedACL = s.config.FileACL
+	if _, err := c.Run(ctx); err != nil {
+		return s.error("copy", src, writeError(err, nil))
+	}
+	return nil
+}
+
+// Move is Copy, followed by removing src.
+func (s *Storage) Move(src, dst string) error {
+	if err := s.Copy(src, dst); err != nil {
+		return err
+	}
+	if err := s.RemoveFile(src); err != nil {
+		var se *storage.Error
+		if errors.As(err, &se) {
+			se.Op = "move"
+		}
+		return err
+	}
+	return nil
+}
+
+// EmptyContainer removes every object in the bucket, including those
+// outside of the prefix.
+func (s *Storage) EmptyContainer() error {
+	ctx := context.Background()
+	it := s.bucket.Objects(ctx, nil)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return s.error("empty", "", err)
+		}
+		err = s.bucket.Object(attrs.Name).Delete(ctx)
+		if err != nil && !errors.Is(err, gcssdk.ErrObjectNotExist) {
+			return s.error("empty", attrs.Name, err)
+		}
+	}
+}
+
+// DeleteContainer empties the bucket, and deletes it.
+func (s *Storage) DeleteContainer() error {
+	if err := s.EmptyContainer(); err != nil {
+		return err
+	}
+	if err := s.bucket.Delete(context.Background()); err != nil {
+		return s.error("delete", "", err)
+	}
+	return nil
+}
+
+// gcsError wraps err so that it matches the sentinel errors of storage, and
+// reports the API errors GCS asks to retry as retryable.
+func gcsError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var gerr *googleapi.Error
+	switch {
+	case errors.Is(err, gcssdk.ErrObjectNotExist), errors.Is(err, gcssdk.ErrBucketNotExist):
+		return &apiError{err: err, kind: storage.ErrNotFound}
+	case !errors.As(err, &gerr):
+		return err
+	case gerr.Code == http.StatusNotFound:
+		return &apiError{err: err, kind: storage.ErrNotFound}
+	case gerr.Code == http.StatusPreconditionFailed:
+		return &apiError{err: err, kind: storage.ErrPreconditionFailed}
+	default:
+		return &apiError{err: err, code: gerr.Code}
+	}
+}
+
+type apiError struct {
+	err  error
+	kind error
+	code int
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+func (e *apiError) Unwrap() error {
+	return e.err
+}
+
+func (e *apiError) Is(target error) bool {
+	return e.kind != nil && target == e.kind
+}
+
+func (e *apiError) Retryable() bool {
+	return e.code == http.StatusTooManyRequests || e.code >= 500
+}
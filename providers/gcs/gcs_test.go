@@ -0,0 +1,257 @@
+package gcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+
+	"github.com/djangulo/go-storage"
+	storagetest "github.com/djangulo/go-storage/testing"
+)
+
+// fakeGCS starts an in-process GCS emulator, returning the host:port it is
+// served on over plain HTTP. It patches two gaps between fake-gcs-server and
+// GCS: the emulator drops Cache-Control and Content-Disposition, and panics
+// on ranges starting past the end of an object.
+func fakeGCS(t *testing.T) (string, func()) {
+	t.Helper()
+	server, err := fakestorage.NewServerWithOptions(fakestorage.Options{NoListener: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := server.HTTPClient().Transport
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the emulator serves downloads on its public host only
+		r.URL.Scheme, r.URL.Host, r.Host = "https", "storage.googleapis.com", "storage.googleapis.com"
+		r.RequestURI = ""
+		if pastEnd(server, r) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if r.URL.Query().Get("uploadType") == "multipart" {
+			if err := stashHeaders(r); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		res, err := transport.RoundTrip(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		if strings.HasPrefix(r.URL.Path, "/storage/v1/") && r.URL.Query().Get("alt") != "media" {
+			body = unstashHeaders(body)
+			res.Header.Del("Content-Length")
+		}
+		for k, v := range res.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(res.StatusCode)
+		w.Write(body)
+	}))
+	return strings.TrimPrefix(ts.URL, "http://"), ts.Close
+}
+
+// stashedHeaders are kept in the metadata of the objects, under these keys.
+var stashedHeaders = map[string]string{
+	"cacheControl":       "x-fake-cache-control",
+	"contentDisposition": "x-fake-content-disposition",
+}
+
+// pastEnd reports whether r is a ranged download starting past the end of
+// the object.
+func pastEnd(server *fakestorage.Server, r *http.Request) bool {
+	var start int
+	if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &start); err != nil {
+		return false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	obj, err := server.GetObject(parts[0], parts[1])
+	return err == nil && start >= len(obj.Content)
+}
+
+// stashHeaders moves the stashedHeaders of the object resource of a
+// multipart upload into its metadata.
+func stashHeaders(r *http.Request) error {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	var (
+		body bytes.Buffer
+		mr   = multipart.NewReader(r.Body, params["boundary"])
+		mw   = multipart.NewWriter(&body)
+	)
+	mw.SetBoundary(params["boundary"])
+	for i := 0; ; i++ {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		b, err := ioutil.ReadAll(part)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			var resource map[string]interface{}
+			if err := json.Unmarshal(b, &resource); err != nil {
+				return err
+			}
+			meta, _ := resource["metadata"].(map[string]interface{})
+			if meta == nil {
+				meta = make(map[string]interface{})
+			}
+			for field, key := range stashedHeaders {
+				if v, ok := resource[field]; ok {
+					meta[key] = v
+				}
+			}
+			if len(meta) > 0 {
+				resource["metadata"] = meta
+			}
+			b, _ = json.Marshal(resource)
+		}
+		pw, err := mw.CreatePart(part.Header)
+		if err != nil {
+			return err
+		}
+		pw.Write(b)
+	}
+	mw.Close()
+	r.Body = ioutil.NopCloser(&body)
+	r.ContentLength = int64(body.Len())
+	return nil
+}
+
+// unstashHeaders moves the stashedHeaders of an object resource back out of
+// its metadata.
+func unstashHeaders(b []byte) []byte {
+	var resource map[string]interface{}
+	if err := json.Unmarshal(b, &resource); err != nil {
+		return b
+	}
+	meta, _ := resource["metadata"].(map[string]interface{})
+	for field, key := range stashedHeaders {
+		if v, ok := meta[key]; ok {
+			resource[field] = v
+			delete(meta, key)
+		}
+	}
+	if meta != nil && len(meta) == 0 {
+		delete(resource, "metadata")
+	}
+	out, err := json.Marshal(resource)
+	if err != nil {
+		return b
+	}
+	return out
+}
+
+func TestGCS(t *testing.T) {
+	host, cleanup := fakeGCS(t)
+	defer cleanup()
+
+	drv, err := storage.Open("gcs://test-bucket/assets?accept=.txt&emulator-host=" + host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer drv.Close()
+	storagetest.Test(t, drv)
+
+	t.Run("path", func(t *testing.T) {
+		want := "http://" + host + "/test-bucket/assets/a.txt"
+		if got := drv.NormalizePath("a.txt"); got != want {
+			t.Errorf("expected %q got %q", want, got)
+		}
+	})
+	t.Run("no auto-create", func(t *testing.T) {
+		_, err := storage.Open("gcs://missing-bucket/assets?auto-create=false&emulator-host=" + host)
+		if err == nil {
+			t.Error("expected an error opening a missing bucket")
+		}
+	})
+	// the emulator cannot delete buckets
+	t.Run("empty container", func(t *testing.T) {
+		if _, err := drv.AddFile(strings.NewReader("hello world"), "a.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if err := drv.(storage.DangerDriver).EmptyContainer(); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := storage.Exists(drv, "a.txt"); ok || err != nil {
+			t.Errorf("expected no files, got %v, %v", ok, err)
+		}
+	})
+}
+
+func TestParseURL(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want *Config
+		err  error
+	}{
+		{
+			"gcs://testbucket/assets?accept=.txt",
+			&Config{
+				Bucket:           "testbucket",
+				Prefix:           "/assets",
+				AutoBucketCreate: true,
+				accept:           map[string]struct{}{".txt": {}},
+			},
+			nil,
+		},
+		{
+			"gcs://testbucket/assets/?credentials=/etc/gcs.json&project=my-project&acl=public-read&auto-create=off&emulator-host=http://localhost:4443/",
+			&Config{
+				Bucket:          "testbucket",
+				Prefix:          "/assets",
+				accept:          map[string]struct{}{".jpeg": {}, ".jpg": {}, ".png": {}, ".svg": {}},
+				Project:         "my-project",
+				FileACL:         "publicRead",
+				CredentialsFile: "/etc/gcs.json",
+				EmulatorHost:    "localhost:4443",
+			},
+			nil,
+		},
+		{"gcs://testbucket/assets?acl=everyone", nil, ErrURLParse},
+		{"gcs://testbucket/assets?auto-create=maybe", nil, ErrURLParse},
+		{"gcs:///assets", nil, ErrURLParse},
+		{"s3://testbucket/assets", nil, ErrURLParse},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseURL(tt.in)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("expected %v got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nexpected\t%+v\ngot\t\t%+v", tt.want, got)
+			}
+		})
+	}
+}
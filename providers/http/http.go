@@ -0,0 +1,295 @@
+// Package httpstorage implements a read-only storage.Driver for files served
+// over HTTP or HTTPS, such as a static origin or a CDN.
+package httpstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/djangulo/go-storage"
+	"github.com/djangulo/go-storage/internal/util"
+)
+
+// Storage is a read-only storage.Driver for the files under a base URL.
+type Storage struct {
+	config *Config
+	client *http.Client
+}
+
+type Config struct {
+	// Scheme is either http or https.
+	Scheme string
+	// Host of the server, as host[:port].
+	Host string
+	// Prefix is the path the files are served under.
+	Prefix string
+	User   string
+	// Header is sent along with every request.
+	Header http.Header
+	// Timeout of each request, body included. Zero means no timeout.
+	Timeout  time.Duration
+	password string
+	accept   map[string]struct{}
+}
+
+// compile-time checks of the optional interfaces Storage implements.
+var (
+	_ storage.ContextDriver = (*Storage)(nil)
+	_ storage.StatDriver    = (*Storage)(nil)
+	_ storage.RangeDriver   = (*Storage)(nil)
+)
+
+func init() {
+	storage.Register("http+storage", &Storage{})
+	storage.Register("https+storage", &Storage{})
+}
+
+// Path returns the base URL of the files.
+func (s *Storage) Path() string {
+	return s.url(s.config.Prefix)
+}
+
+// url returns the URL of the remote path p.
+func (s *Storage) url(p string) string {
+	u := url.URL{Scheme: s.config.Scheme, Host: s.config.Host, Path: p}
+	return u.String()
+}
+
+func (s *Storage) Accepts(ext string) (accepts bool) {
+	_, accepts = s.config.accept[ext]
+	return
+}
+
+func (s *Storage) NormalizePath(entries ...string) string {
+	return util.JoinURL(s.Path(), entries...)
+}
+
+var ErrURLParse = errors.New("error parsing url")
+
+func parseURL(urlString string) (*Config, error) {
+	u, err := url.Parse(urlString)
+	if err != nil {
+		// *url.Error would print the url, password included
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrURLParse, err)
+	}
+	if (u.Scheme != "http+storage" && u.Scheme != "https+storage") || u.Host == "" {
+		return nil, fmt.Errorf(
+			"%w: %s does not match \"http[s]+storage://[user:password@]host[:port]/path\" format",
+			ErrURLParse,
+			util.RedactURL(urlString),
+		)
+	}
+	c := &Config{
+		Scheme: strings.TrimSuffix(u.Scheme, "+storage"),
+		Host:   u.Host,
+		Prefix: "/" + strings.Trim(u.Path, "/"),
+		Header: make(http.Header),
+	}
+	if u.User != nil {
+		c.User = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+
+	q := u.Query()
+	for _, h := range q["header"] {
+		parts := strings.SplitN(h, ":", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" {
+			return nil, fmt.Errorf("%w: header %q is not in the \"Name: value\" form", ErrURLParse, h)
+		}
+		c.Header.Add(name, strings.TrimSpace(parts[1]))
+	}
+	if v := q.Get("timeout"); v != "" {
+		if c.Timeout, err = time.ParseDuration(v); err != nil || c.Timeout < 0 {
+			return nil, fmt.Errorf("%w: invalid timeout %q", ErrURLParse, v)
+		}
+	}
+	c.accept = util.ParseCommaSeparatedQuery(q, "accept", ".jpeg", ".jpg", ".png", ".svg")
+	return c, nil
+}
+
+// Open creates a *Storage. The urlString should be in the form
+// http+storage://[user:password@]host[:port]/path?header=&timeout=&accept=
+// or https+storage://... to use HTTPS. Files are read from the URL of path
+// joined with theirs. The user and password, if any, are sent through
+// basic authentication. Open does not connect to the server.
+// The URL parameters accepted are as follows:
+//   - header: header sent along with every request, in the "Name: value"
+//     form. Could be repeated. e.g. url://host/path?header=X-Token:%20abc
+//   - timeout: time limit of each request, body included, as parsed by
+//     time.ParseDuration. Default none.
+//   - accept: comma-separated list of file extensions to accept. Could be
+//     repeated. e.g. url://host/path?accept=.jpeg,.svg&accept=.png would
+//     accept .jpeg, .svg and .png files. Default .jpeg,.jpg,.png,.svg
+func (s *Storage) Open(urlString string) (storage.Driver, error) {
+	return s.OpenContext(context.Background(), urlString)
+}
+
+// OpenContext is the context-aware version of Open. As Open does not
+// connect, ctx is only checked.
+func (s *Storage) OpenContext(ctx context.Context, urlString string) (storage.Driver, error) {
+	var err error
+
+	if err := ctx.Err(); err != nil {
+		return nil, s.error("open", "", err)
+	}
+
+	ns := &Storage{}
+
+	ns.config, err = parseURL(urlString)
+	if err != nil {
+		return nil, s.error("open", "", err)
+	}
+	ns.client = &http.Client{Timeout: ns.config.Timeout}
+
+	return ns, nil
+}
+
+func (s *Storage) error(op, p string, err error) error {
+	return &storage.Error{Op: op, Driver: "http", Path: p, Err: err}
+}
+
+// Close closes the idle connections to the server.
+func (s *Storage) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// do sends a request for the file on p, and returns the response if its
+// status is one of ok. Missing files fail with storage.ErrNotFound.
+func (s *Storage) do(ctx context.Context, method, p string, header http.Header, ok ...int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.url(path.Join(s.config.Prefix, p)), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range s.config.Header {
+		req.Header[k] = v
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if s.config.User != "" {
+		req.SetBasicAuth(s.config.User, s.config.password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err = util.CheckStatus(resp, ok...)
+	switch util.Status(err) {
+	case http.StatusNotFound, http.StatusGone:
+		return nil, storage.ErrNotFound
+	}
+	return resp, err
+}
+
+// AddFile fails with storage.ErrReadOnly.
+func (s *Storage) AddFile(r io.Reader, p string) (string, error) {
+	return s.AddFileContext(context.Background(), r, p)
+}
+
+// AddFileContext fails with storage.ErrReadOnly.
+func (s *Storage) AddFileContext(ctx context.Context, r io.Reader, p string) (string, error) {
+	return "", s.error("add", p, storage.ErrReadOnly)
+}
+
+// RemoveFile fails with storage.ErrReadOnly.
+func (s *Storage) RemoveFile(p string) error {
+	return s.RemoveFileContext(context.Background(), p)
+}
+
+// RemoveFileContext fails with storage.ErrReadOnly.
+func (s *Storage) RemoveFileContext(ctx context.Context, p string) error {
+	return s.error("remove", p, storage.ErrReadOnly)
+}
+
+// GetFile returns the body of a GET request for path. 404 and 410
+// responses fail with storage.ErrNotFound.
+func (s *Storage) GetFile(p string) (io.ReadCloser, error) {
+	return s.GetFileContext(context.Background(), p)
+}
+
+// GetFileContext is the context-aware version of GetFile. ctx also governs
+// reads from the returned body.
+func (s *Storage) GetFileContext(ctx context.Context, p string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, p, nil, http.StatusOK)
+	if err != nil {
+		return nil, s.error("get", p, err)
+	}
+	return util.ContextReadCloser(ctx, resp.Body), nil
+}
+
+// GetFileRange returns length bytes of the file on path starting at offset,
+// or the rest of the file if length is negative, through a Range request.
+// Servers that ignore the range send the whole file, which is skipped
+// through.
+func (s *Storage) GetFileRange(p string, offset, length int64) (io.ReadCloser, error) {
+	ctx := context.Background()
+	if length == 0 {
+		if _, err := s.Stat(p); err != nil {
+			var se *storage.Error
+			if errors.As(err, &se) {
+				se.Op = "get"
+			}
+			return nil, err
+		}
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	rc, err := util.GetRange(func(header http.Header) (*http.Response, error) {
+		return s.do(ctx, http.MethodGet, p, header, http.StatusOK, http.StatusPartialContent)
+	}, offset, length)
+	if err != nil {
+		return nil, s.error("get", p, err)
+	}
+	return rc, nil
+}
+
+// Stat returns the FileInfo of the file on path, from the headers of a
+// HEAD request. The size is zero if the server does not report it.
+func (s *Storage) Stat(p string) (*storage.FileInfo, error) {
+	resp, err := s.do(context.Background(), http.MethodHead, p, nil, http.StatusOK)
+	if err != nil {
+		return nil, s.error("stat", p, err)
+	}
+	resp.Body.Close()
+
+	h := resp.Header
+	fi := &storage.FileInfo{
+		Path:               p,
+		ContentType:        h.Get("Content-Type"),
+		CacheControl:       h.Get("Cache-Control"),
+		ContentDisposition: h.Get("Content-Disposition"),
+		ContentEncoding:    h.Get("Content-Encoding"),
+		ETag:               strings.Trim(strings.TrimPrefix(h.Get("ETag"), "W/"), `"`),
+	}
+	if resp.ContentLength > 0 {
+		fi.Size = resp.ContentLength
+	}
+	if t, err := http.ParseTime(h.Get("Last-Modified")); err == nil {
+		fi.ModTime = t
+	}
+	if fi.ContentType == "" {
+		fi.ContentType = storage.ResolveContentType(p)
+	}
+	return fi, nil
+}
+
+// Exists reports whether there is a file on path.
+func (s *Storage) Exists(p string) (bool, error) {
+	_, err := s.Stat(p)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,322 @@
+// Package iofs implements a read-only storage.Driver for the files of an
+// fs.FS, such as an embed.FS, or of a local directory.
+package iofs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/djangulo/go-storage"
+	"github.com/djangulo/go-storage/internal/util"
+)
+
+// Storage is a read-only storage.Driver for the files of an fs.FS.
+type Storage struct {
+	config *Config
+	fsys   fs.FS
+}
+
+type Config struct {
+	// Dir is the local directory the files are read from, empty for the
+	// Storage returned by New.
+	Dir    string
+	accept map[string]struct{}
+}
+
+// compile-time checks of the optional interfaces Storage implements.
+var (
+	_ storage.ContextDriver = (*Storage)(nil)
+	_ storage.StatDriver    = (*Storage)(nil)
+	_ storage.ListDriver    = (*Storage)(nil)
+	_ storage.WalkDriver    = (*Storage)(nil)
+	_ storage.RangeDriver   = (*Storage)(nil)
+)
+
+func init() {
+	storage.Register("iofs", &Storage{})
+}
+
+// New returns a read-only *Storage for the files of fsys. accept lists the
+// file extensions Accepts reports, as the accept parameter of Open does.
+// Default .jpeg,.jpg,.png,.svg.
+func New(fsys fs.FS, accept ...string) *Storage {
+	q := url.Values{}
+	if len(accept) > 0 {
+		q["accept"] = accept
+	}
+	return &Storage{
+		config: &Config{accept: util.ParseCommaSeparatedQuery(q, "accept", ".jpeg", ".jpg", ".png", ".svg")},
+		fsys:   fsys,
+	}
+}
+
+// Path returns the directory the files are read from, or "." for the
+// Storage returned by New.
+func (s *Storage) Path() string {
+	if s.config.Dir == "" {
+		return "."
+	}
+	return s.config.Dir
+}
+
+func (s *Storage) Accepts(ext string) (accepts bool) {
+	_, accepts = s.config.accept[ext]
+	return
+}
+
+func (s *Storage) NormalizePath(entries ...string) string {
+	return path.Join(append([]string{filepath.ToSlash(s.Path())}, entries...)...)
+}
+
+var ErrURLParse = errors.New("error parsing url")
+
+func parseURL(urlString string) (*Config, error) {
+	u, err := url.Parse(urlString)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrURLParse, err)
+	}
+	if u.Scheme != "iofs" || u.Host+u.Path == "" {
+		return nil, fmt.Errorf(
+			"%w: %s does not match \"iofs:///path/to/dir\" format",
+			ErrURLParse,
+			urlString,
+		)
+	}
+	c := &Config{Dir: filepath.FromSlash(u.Host + u.Path)}
+
+	q := u.Query()
+	c.accept = util.ParseCommaSeparatedQuery(q, "accept", ".jpeg", ".jpg", ".png", ".svg")
+	return c, nil
+}
+
+// Open creates a *Storage reading the files of a local directory, through
+// os.DirFS. The urlString should be in the form iofs:///path/to/dir?accept=
+// Relative paths are written as iofs://./dir. Use New to read the files of
+// any other fs.FS.
+// The URL parameters accepted are as follows:
+//   - accept: comma-separated list of file extensions to accept. Could be
+//     repeated. e.g. url://host/path?accept=.jpeg,.svg&accept=.png would
+//     accept .jpeg, .svg and .png files. Default .jpeg,.jpg,.png,.svg
+func (s *Storage) Open(urlString string) (storage.Driver, error) {
+	return s.OpenContext(context.Background(), urlString)
+}
+
+// OpenContext is the context-aware version of Open. The context is only
+// checked before opening the directory.
+func (s *Storage) OpenContext(ctx context.Context, urlString string) (storage.Driver, error) {
+	var err error
+
+	if err := ctx.Err(); err != nil {
+		return nil, s.error("open", "", err)
+	}
+
+	ns := &Storage{}
+
+	ns.config, err = parseURL(urlString)
+	if err != nil {
+		return nil, s.error("open", "", err)
+	}
+	fi, err := os.Stat(ns.config.Dir)
+	if err != nil {
+		return nil, s.error("open", "", err)
+	}
+	if !fi.IsDir() {
+		return nil, s.error("open", "", fmt.Errorf("%s is not a directory", ns.config.Dir))
+	}
+	ns.fsys = os.DirFS(ns.config.Dir)
+
+	return ns, nil
+}
+
+func (s *Storage) error(op, p string, err error) error {
+	return &storage.Error{Op: op, Driver: "iofs", Path: p, Err: err}
+}
+
+// Close noop
+func (s *Storage) Close() error {
+	return nil
+}
+
+// name returns the name of the file on p in the fs.FS.
+func name(p string) string {
+	if p = strings.TrimPrefix(path.Clean("/"+p), "/"); p == "" {
+		return "."
+	}
+	return p
+}
+
+// notFound turns the fs.ErrNotExist errors of the fs.FS into
+// storage.ErrNotFound.
+func notFound(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return storage.ErrNotFound
+	}
+	return err
+}
+
+// AddFile fails with storage.ErrReadOnly.
+func (s *Storage) AddFile(r io.Reader, p string) (string, error) {
+	return s.AddFileContext(context.Background(), r, p)
+}
+
+// AddFileContext fails with storage.ErrReadOnly.
+func (s *Storage) AddFileContext(ctx context.Context, r io.Reader, p string) (string, error) {
+	return "", s.error("add", p, storage.ErrReadOnly)
+}
+
+// RemoveFile fails with storage.ErrReadOnly.
+func (s *Storage) RemoveFile(p string) error {
+	return s.RemoveFileContext(context.Background(), p)
+}
+
+// RemoveFileContext fails with storage.ErrReadOnly.
+func (s *Storage) RemoveFileContext(ctx context.Context, p string) error {
+	return s.error("remove", p, storage.ErrReadOnly)
+}
+
+// open opens the file on p, which must not be a directory.
+func (s *Storage) open(p string) (fs.File, fs.FileInfo, error) {
+	f, err := s.fsys.Open(name(p))
+	if err != nil {
+		return nil, nil, notFound(err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if fi.IsDir() {
+		f.Close()
+		return nil, nil, storage.ErrNotFound
+	}
+	return f, fi, nil
+}
+
+func (s *Storage) GetFile(p string) (io.ReadCloser, error) {
+	return s.GetFileContext(context.Background(), p)
+}
+
+// GetFileContext is the context-aware version of GetFile. ctx also governs
+// reads from the returned file.
+func (s *Storage) GetFileContext(ctx context.Context, p string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, s.error("get", p, err)
+	}
+	f, _, err := s.open(p)
+	if err != nil {
+		return nil, s.error("get", p, err)
+	}
+	return &limitedFile{Reader: util.ContextReader(ctx, f), Closer: f}, nil
+}
+
+// GetFileRange returns length bytes of the file on path starting at offset,
+// or the rest of the file if length is negative. Files that cannot seek,
+// which the files of os.DirFS and embed.FS can, are skipped through.
+func (s *Storage) GetFileRange(p string, offset, length int64) (io.ReadCloser, error) {
+	f, _, err := s.open(p)
+	if err != nil {
+		return nil, s.error("get", p, err)
+	}
+	if sk, ok := f.(io.Seeker); ok {
+		_, err = sk.Seek(offset, io.SeekStart)
+	} else if _, err = io.CopyN(ioutil.Discard, f, offset); err == io.EOF {
+		err = nil
+	}
+	if err != nil {
+		f.Close()
+		return nil, s.error("get", p, err)
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return &limitedFile{Reader: io.LimitReader(f, length), Closer: f}, nil
+}
+
+type limitedFile struct {
+	io.Reader
+	io.Closer
+}
+
+func fileInfo(p string, fi fs.FileInfo) *storage.FileInfo {
+	return &storage.FileInfo{
+		Path:        p,
+		Size:        fi.Size(),
+		ModTime:     fi.ModTime(),
+		ContentType: storage.ResolveContentType(p),
+		ETag:        fmt.Sprintf("%x-%x", fi.ModTime().UnixNano(), fi.Size()),
+	}
+}
+
+// Stat returns the FileInfo of the file on path. The ETag is derived from
+// the modification time and the size of the file, as the fs provider does;
+// embed.FS files have no modification time.
+func (s *Storage) Stat(p string) (*storage.FileInfo, error) {
+	fi, err := fs.Stat(s.fsys, name(p))
+	if err == nil && fi.IsDir() {
+		err = storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, s.error("stat", p, notFound(err))
+	}
+	return fileInfo(p, fi), nil
+}
+
+// Exists reports whether there is a file on path.
+func (s *Storage) Exists(p string) (bool, error) {
+	_, err := s.Stat(p)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// List returns a page of the files under prefix, out of a walk of the
+// directories of the fs.FS under it.
+func (s *Storage) List(prefix string, opts *storage.ListOptions) (*storage.ListPage, error) {
+	return util.ListFromWalk(s, prefix, opts)
+}
+
+// Walk calls fn for every file under prefix, in lexical order, through
+// fs.WalkDir.
+func (s *Storage) Walk(prefix string, fn storage.WalkFunc) error {
+	// only descend from the deepest directory the prefix names
+	root := "."
+	if i := strings.LastIndex(prefix, "/"); i > 0 {
+		root = name(prefix[:i])
+	}
+	err := fs.WalkDir(s.fsys, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasPrefix(p, prefix) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if err := fn(fileInfo(p, fi)); err != nil {
+			return &util.FnError{Err: err}
+		}
+		return nil
+	})
+	if ferr, ok := err.(*util.FnError); ok {
+		return ferr.Err
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return s.error("walk", prefix, err)
+	}
+	return nil
+}
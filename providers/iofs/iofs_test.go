@@ -0,0 +1,147 @@
+package iofs
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/djangulo/go-storage"
+	storagetest "github.com/djangulo/go-storage/testing"
+)
+
+var files = map[string]string{
+	"a.txt":         "hello world",
+	"dir/b.txt":     "hello there",
+	"dir/sub/c.txt": "nested",
+}
+
+func TestIOFS(t *testing.T) {
+	fsys := make(fstest.MapFS)
+	for p, s := range files {
+		fsys[p] = &fstest.MapFile{Data: []byte(s), ModTime: time.Now()}
+	}
+	drv := New(fsys, ".txt")
+	storagetest.TestReadOnly(t, drv, files)
+
+	t.Run("path", func(t *testing.T) {
+		if got := drv.NormalizePath("dir/b.txt"); got != "dir/b.txt" {
+			t.Errorf("expected %q got %q", "dir/b.txt", got)
+		}
+	})
+	t.Run("accepts", func(t *testing.T) {
+		if !drv.Accepts(".txt") || drv.Accepts(".png") {
+			t.Error("expected only .txt to be accepted")
+		}
+		if !New(fsys).Accepts(".png") {
+			t.Error("expected .png to be accepted by default")
+		}
+	})
+	t.Run("directories", func(t *testing.T) {
+		if _, err := drv.GetFile("dir"); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("expected %v got %v", storage.ErrNotFound, err)
+		}
+		if ok, err := drv.Exists("dir/sub"); ok || err != nil {
+			t.Errorf("expected false, <nil> got %v, %v", ok, err)
+		}
+	})
+	t.Run("list", func(t *testing.T) {
+		page, err := drv.List("dir/", &storage.ListOptions{Delimiter: "/"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Files) != 1 || page.Files[0].Path != "dir/b.txt" {
+			t.Errorf("expected dir/b.txt got %+v", page.Files)
+		}
+		if want := []string{"dir/sub/"}; !reflect.DeepEqual(page.Prefixes, want) {
+			t.Errorf("expected %v got %v", want, page.Prefixes)
+		}
+		page, err = drv.List("dir/s", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Files) != 1 || page.Files[0].Path != "dir/sub/c.txt" {
+			t.Errorf("expected dir/sub/c.txt got %+v", page.Files)
+		}
+		page, err = drv.List("missing/", nil)
+		if err != nil || len(page.Files) != 0 {
+			t.Errorf("expected no files, <nil> got %+v, %v", page.Files, err)
+		}
+	})
+	t.Run("as fs", func(t *testing.T) {
+		if err := fstest.TestFS(storage.AsFS(drv), "a.txt", "dir/b.txt", "dir/sub/c.txt"); err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("directory", func(t *testing.T) {
+		dir := t.TempDir()
+		for p, s := range files {
+			name := filepath.Join(dir, filepath.FromSlash(p))
+			if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(name, []byte(s), 0666); err != nil {
+				t.Fatal(err)
+			}
+		}
+		drv, err := storage.Open("iofs://" + filepath.ToSlash(dir) + "?accept=.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer drv.Close()
+		storagetest.TestReadOnly(t, drv, files)
+		if got := drv.NormalizePath("a.txt"); got != filepath.ToSlash(dir)+"/a.txt" {
+			t.Errorf("expected %q got %q", filepath.ToSlash(dir)+"/a.txt", got)
+		}
+		if _, err := storage.Open("iofs://" + filepath.ToSlash(dir) + "/a.txt"); err == nil {
+			t.Error("expected an error opening a file")
+		}
+	})
+}
+
+func TestParseURL(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want *Config
+		err  error
+	}{
+		{
+			"iofs:///srv/assets?accept=.txt,.html",
+			&Config{
+				Dir:    filepath.FromSlash("/srv/assets"),
+				accept: map[string]struct{}{".txt": {}, ".html": {}},
+			},
+			nil,
+		},
+		{
+			"iofs://./assets",
+			&Config{
+				Dir:    filepath.FromSlash("./assets"),
+				accept: map[string]struct{}{".jpeg": {}, ".jpg": {}, ".png": {}, ".svg": {}},
+			},
+			nil,
+		},
+		{"iofs://", nil, ErrURLParse},
+		{"fs:///srv/assets", nil, ErrURLParse},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseURL(tt.in)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("expected %v got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nexpected\t%+v\ngot\t\t%+v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,452 @@
+// Package mem implements a storage.Driver that keeps files in memory. It is
+// safe for concurrent use, implements every optional interface of storage
+// but PresignDriver, and is meant for tests and ephemeral data.
+package mem
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/djangulo/go-storage"
+	"github.com/djangulo/go-storage/internal/util"
+)
+
+// Memory is a storage.Driver backed by a map.
+type Memory struct {
+	name   string
+	path   string
+	accept map[string]struct{}
+	store  *store
+}
+
+// store holds the files of one or more Memory drivers. Stored data is never
+// modified, only replaced, so readers can hold on to it without locking.
+type store struct {
+	mu    sync.RWMutex
+	files map[string]*object
+}
+
+type object struct {
+	data []byte
+	info storage.FileInfo
+}
+
+var (
+	storesMu sync.Mutex
+	// stores holds the named stores, shared by the drivers opened with the
+	// same host.
+	stores = make(map[string]*store)
+)
+
+// compile-time checks of the optional interfaces Memory implements.
+var (
+	_ storage.DangerDriver  = (*Memory)(nil)
+	_ storage.ContextDriver = (*Memory)(nil)
+	_ storage.StatDriver    = (*Memory)(nil)
+	_ storage.PutDriver     = (*Memory)(nil)
+	_ storage.CopyDriver    = (*Memory)(nil)
+	_ storage.ListDriver    = (*Memory)(nil)
+	_ storage.WalkDriver    = (*Memory)(nil)
+	_ storage.RangeDriver   = (*Memory)(nil)
+	_ storage.SeekDriver    = (*Memory)(nil)
+	_ storage.WriterDriver  = (*Memory)(nil)
+)
+
+func init() {
+	storage.Register("mem", &Memory{})
+}
+
+func (m *Memory) Path() string {
+	return m.path
+}
+
+func (m *Memory) Accepts(ext string) (accepts bool) {
+	_, accepts = m.accept[ext]
+	return
+}
+
+func (m *Memory) NormalizePath(entries ...string) string {
+	entries = append([]string{m.path}, entries...)
+	return path.Join(entries...)
+}
+
+// Open creates a Memory driver. The urlString should be in the form
+// mem://name/path?accept=
+// Drivers opened with the same name share their files, until
+// DeleteContainer is called; without a name, the driver gets its own files.
+// The URL parameters accepted are as follows:
+//   - accept: comma-separated list of file extensions to accept. Could be
+//     repeated. e.g. mem://name/path?accept=.jpeg,.svg&accept=.png would
+//     accept .jpeg, .svg and .png files. Default .jpeg,.jpg,.png,.svg
+func (m *Memory) Open(urlString string) (storage.Driver, error) {
+	return m.OpenContext(context.Background(), urlString)
+}
+
+// OpenContext is the context-aware version of Open.
+func (m *Memory) OpenContext(ctx context.Context, urlString string) (storage.Driver, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, m.error("open", "", err)
+	}
+	u, err := url.Parse(urlString)
+	if err != nil {
+		return nil, m.error("open", "", err)
+	}
+
+	nm := &Memory{
+		name:   u.Host,
+		path:   u.Path,
+		accept: util.ParseCommaSeparatedQuery(u.Query(), "accept", ".jpeg", ".jpg", ".png", ".svg"),
+	}
+	if nm.name == "" {
+		nm.store = newStore()
+		return nm, nil
+	}
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	if stores[nm.name] == nil {
+		stores[nm.name] = newStore()
+	}
+	nm.store = stores[nm.name]
+	return nm, nil
+}
+
+func newStore() *store {
+	return &store{files: make(map[string]*object)}
+}
+
+func (m *Memory) error(op, path string, err error) error {
+	return &storage.Error{Op: op, Driver: "mem", Path: path, Err: err}
+}
+
+// clean returns the key of path in the store.
+func (m *Memory) clean(p string) string {
+	p = strings.TrimPrefix(p, m.path)
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+// Close noop
+func (m *Memory) Close() error {
+	return nil
+}
+
+func (m *Memory) AddFile(r io.Reader, path string) (string, error) {
+	return m.addFile(context.Background(), r, path, nil)
+}
+
+// AddFileContext is the context-aware version of AddFile. Nothing is stored
+// if ctx is done before r is fully read.
+func (m *Memory) AddFileContext(ctx context.Context, r io.Reader, path string) (string, error) {
+	return m.addFile(ctx, r, path, nil)
+}
+
+// AddFileWithOptions is AddFile, configured through opts. Every write mode is
+// atomic.
+func (m *Memory) AddFileWithOptions(r io.Reader, path string, opts *storage.PutOptions) (string, error) {
+	return m.addFile(context.Background(), r, path, opts)
+}
+
+func (m *Memory) addFile(ctx context.Context, r io.Reader, p string, opts *storage.PutOptions) (string, error) {
+	p = m.clean(p)
+	if ext := filepath.Ext(p); !m.Accepts(ext) {
+		return "", m.error("add", p, fmt.Errorf("%w %s", storage.ErrInvalidExtension, ext))
+	}
+	if err := ctx.Err(); err != nil {
+		return "", m.error("add", p, err)
+	}
+	data, err := ioutil.ReadAll(util.ContextReader(ctx, r))
+	if err != nil {
+		return "", m.error("add", p, err)
+	}
+	if err := m.put(p, data, opts); err != nil {
+		return "", m.error("add", p, err)
+	}
+	return m.NormalizePath(p), nil
+}
+
+// put stores data on key, if the write mode of opts allows it.
+func (m *Memory) put(key string, data []byte, opts *storage.PutOptions) error {
+	if opts == nil {
+		opts = &storage.PutOptions{}
+	}
+	obj := &object{
+		data: data,
+		info: storage.FileInfo{
+			Path:               key,
+			Size:               int64(len(data)),
+			ModTime:            time.Now(),
+			ContentType:        opts.ResolveContentType(key),
+			CacheControl:       opts.CacheControl,
+			ContentDisposition: opts.ContentDisposition,
+			ContentEncoding:    opts.ContentEncoding,
+			ETag:               fmt.Sprintf("%x", md5.Sum(data)),
+		},
+	}
+	if len(opts.Metadata) > 0 {
+		obj.info.Metadata = make(map[string]string, len(opts.Metadata))
+		for k, v := range opts.Metadata {
+			obj.info.Metadata[strings.ToLower(k)] = v
+		}
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+	var existing *storage.FileInfo
+	if old, ok := m.store.files[key]; ok {
+		existing = &old.info
+	}
+	if err := opts.Mode.Check(existing); err != nil {
+		return err
+	}
+	m.store.files[key] = obj
+	return nil
+}
+
+// get returns the object on key.
+func (m *Memory) get(key string) (*object, bool) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+	obj, ok := m.store.files[key]
+	return obj, ok
+}
+
+func (m *Memory) GetFile(path string) (io.ReadCloser, error) {
+	return m.GetFileContext(context.Background(), path)
+}
+
+// GetFileContext is the context-aware version of GetFile. The context is only
+// checked before looking the file up.
+func (m *Memory) GetFileContext(ctx context.Context, path string) (io.ReadCloser, error) {
+	f, err := m.open(ctx, "get", path)
+	if err != nil {
+		return nil, err
+	}
+	return util.ContextReadCloser(ctx, f), nil
+}
+
+// GetFileRange returns length bytes of the file on path starting at offset,
+// or the rest of the file if length is negative.
+func (m *Memory) GetFileRange(path string, offset, length int64) (io.ReadCloser, error) {
+	f, err := m.open(context.Background(), "get", path)
+	if err != nil {
+		return nil, err
+	}
+	offset, length, err = util.ClampRange(f.Size(), offset, length)
+	if err != nil {
+		return nil, m.error("get", m.clean(path), err)
+	}
+	return ioutil.NopCloser(io.NewSectionReader(f, offset, length)), nil
+}
+
+// GetFileSeeker returns a storage.ReadSeekCloser on the file on path.
+func (m *Memory) GetFileSeeker(path string) (storage.ReadSeekCloser, error) {
+	return m.open(context.Background(), "get", path)
+}
+
+func (m *Memory) open(ctx context.Context, op, p string) (*file, error) {
+	p = m.clean(p)
+	if err := ctx.Err(); err != nil {
+		return nil, m.error(op, p, err)
+	}
+	obj, ok := m.get(p)
+	if !ok {
+		return nil, m.error(op, p, storage.ErrNotFound)
+	}
+	return &file{bytes.NewReader(obj.data)}, nil
+}
+
+// file is a stored file opened for reading.
+type file struct {
+	*bytes.Reader
+}
+
+func (f *file) Close() error {
+	return nil
+}
+
+func (m *Memory) RemoveFile(path string) error {
+	return m.RemoveFileContext(context.Background(), path)
+}
+
+// RemoveFileContext is the context-aware version of RemoveFile.
+func (m *Memory) RemoveFileContext(ctx context.Context, path string) error {
+	path = m.clean(path)
+	if err := ctx.Err(); err != nil {
+		return m.error("remove", path, err)
+	}
+	m.store.mu.Lock()
+	delete(m.store.files, path)
+	m.store.mu.Unlock()
+	return nil
+}
+
+// Stat returns the FileInfo of the file on path. The ETag is the MD5 hash of
+// its contents.
+func (m *Memory) Stat(path string) (*storage.FileInfo, error) {
+	path = m.clean(path)
+	obj, ok := m.get(path)
+	if !ok {
+		return nil, m.error("stat", path, storage.ErrNotFound)
+	}
+	return obj.fileInfo(), nil
+}
+
+// fileInfo returns a copy of the FileInfo of o, safe to modify.
+func (o *object) fileInfo() *storage.FileInfo {
+	fi := o.info
+	if o.info.Metadata != nil {
+		fi.Metadata = make(map[string]string, len(o.info.Metadata))
+		for k, v := range o.info.Metadata {
+			fi.Metadata[k] = v
+		}
+	}
+	return &fi
+}
+
+// Exists reports whether there is a file on path.
+func (m *Memory) Exists(path string) (bool, error) {
+	_, ok := m.get(m.clean(path))
+	return ok, nil
+}
+
+// Copy copies the file on src to dst, along with its content type and
+// metadata.
+func (m *Memory) Copy(src, dst string) error {
+	return m.copy("copy", src, dst, false)
+}
+
+// Move moves the file on src to dst, along with its content type and
+// metadata. It is atomic.
+func (m *Memory) Move(src, dst string) error {
+	return m.copy("move", src, dst, true)
+}
+
+func (m *Memory) copy(op, src, dst string, remove bool) error {
+	src, dst = m.clean(src), m.clean(dst)
+	if ext := filepath.Ext(dst); !m.Accepts(ext) {
+		return m.error(op, dst, fmt.Errorf("%w %s", storage.ErrInvalidExtension, ext))
+	}
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+	obj, ok := m.store.files[src]
+	if !ok {
+		return m.error(op, src, storage.ErrNotFound)
+	}
+	if _, ok := m.store.files[dst]; ok {
+		return m.error(op, dst, storage.ErrAlreadyExists)
+	}
+	cp := &object{data: obj.data, info: *obj.fileInfo()}
+	cp.info.Path = dst
+	cp.info.ModTime = time.Now()
+	m.store.files[dst] = cp
+	if remove {
+		delete(m.store.files, src)
+	}
+	return nil
+}
+
+// List returns a page of the files under prefix, out of a snapshot of the
+// files in memory.
+func (m *Memory) List(prefix string, opts *storage.ListOptions) (*storage.ListPage, error) {
+	return util.ListFromWalk(m, strings.TrimPrefix(prefix, m.path), opts)
+}
+
+// Walk calls fn for every file under prefix, in no particular order. fn is
+// called on a snapshot of the files, so it may modify the driver.
+func (m *Memory) Walk(prefix string, fn storage.WalkFunc) error {
+	prefix = strings.TrimPrefix(prefix, m.path)
+	var files []*storage.FileInfo
+	m.store.mu.RLock()
+	for key, obj := range m.store.files {
+		if strings.HasPrefix(key, prefix) {
+			files = append(files, obj.fileInfo())
+		}
+	}
+	m.store.mu.RUnlock()
+	for _, fi := range files {
+		if err := fn(fi); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OpenWriter returns a storage.Writer that buffers the file, and stores it
+// on Close.
+func (m *Memory) OpenWriter(path string, opts *storage.PutOptions) (storage.Writer, error) {
+	path = m.clean(path)
+	if ext := filepath.Ext(path); !m.Accepts(ext) {
+		return nil, m.error("add", path, fmt.Errorf("%w %s", storage.ErrInvalidExtension, ext))
+	}
+	return &writer{m: m, path: path, opts: opts}, nil
+}
+
+type writer struct {
+	m      *Memory
+	path   string
+	opts   *storage.PutOptions
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, w.m.error("add", w.path, storage.ErrWriterClosed)
+	}
+	return w.buf.Write(p)
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return w.m.error("add", w.path, storage.ErrWriterClosed)
+	}
+	w.closed = true
+	if err := w.m.put(w.path, w.buf.Bytes(), w.opts); err != nil {
+		return w.m.error("add", w.path, err)
+	}
+	return nil
+}
+
+func (w *writer) CloseWithError(err error) error {
+	if w.closed {
+		return w.m.error("add", w.path, storage.ErrWriterClosed)
+	}
+	w.closed = true
+	w.buf.Reset()
+	return nil
+}
+
+// EmptyContainer removes every file, including those of the drivers sharing
+// the files of m.
+func (m *Memory) EmptyContainer() error {
+	m.store.mu.Lock()
+	m.store.files = make(map[string]*object)
+	m.store.mu.Unlock()
+	return nil
+}
+
+// DeleteContainer empties m and forgets its name, so drivers opened with it
+// afterwards start empty.
+func (m *Memory) DeleteContainer() error {
+	if err := m.EmptyContainer(); err != nil {
+		return err
+	}
+	if m.name == "" {
+		return nil
+	}
+	storesMu.Lock()
+	if stores[m.name] == m.store {
+		delete(stores, m.name)
+	}
+	storesMu.Unlock()
+	return nil
+}
@@ -0,0 +1,93 @@
+package mem
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/djangulo/go-storage"
+	storagetest "github.com/djangulo/go-storage/testing"
+)
+
+func TestMemory(t *testing.T) {
+	drv, err := storage.Open("mem:///assets?accept=.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storagetest.Test(t, drv)
+}
+
+func TestSharedStore(t *testing.T) {
+	a, err := storage.Open("mem://shared/assets?accept=.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := storage.Open("mem://shared/assets?accept=.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := storage.Open("mem://other/assets?accept=.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.(storage.DangerDriver).DeleteContainer()
+	defer other.(storage.DangerDriver).DeleteContainer()
+
+	if _, err := a.AddFile(strings.NewReader("hello"), "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := storage.Exists(b, "a.txt"); !ok {
+		t.Error("expected drivers with the same name to share files")
+	}
+	if ok, _ := storage.Exists(other, "a.txt"); ok {
+		t.Error("expected drivers with different names not to share files")
+	}
+
+	if err := a.(storage.DangerDriver).DeleteContainer(); err != nil {
+		t.Fatal(err)
+	}
+	c, err := storage.Open("mem://shared/assets?accept=.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := storage.Exists(c, "a.txt"); ok {
+		t.Error("expected a deleted container to start empty")
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	drv, err := storage.Open("mem:///?accept=.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		created int
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// half of the goroutines race for the same path
+			path := fmt.Sprintf("file-%d.txt", i%25)
+			if _, err := drv.AddFile(strings.NewReader("data"), path); err == nil {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if created != 25 {
+		t.Errorf("expected 25 files to be created, got %d", created)
+	}
+	page, err := drv.(storage.ListDriver).List("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Files) != 25 {
+		t.Errorf("expected 25 files listed, got %d", len(page.Files))
+	}
+}
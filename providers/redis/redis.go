@@ -0,0 +1,604 @@
+// Package redis implements a storage.Driver for small files held in Redis,
+// such as avatars and thumbnails. Each file is a hash, holding its contents
+// and metadata, under a key prefix.
+package redis
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/djangulo/go-storage"
+	"github.com/djangulo/go-storage/internal/util"
+)
+
+// Storage is a storage.Driver for the files under a key prefix of a Redis
+// database.
+type Storage struct {
+	config *Config
+	client *redis.Client
+}
+
+type Config struct {
+	// Addr of the server, as host:port.
+	Addr string
+	// DB is the number of the database.
+	DB   int
+	User string
+	TLS  bool
+	// Prefix of the keys of the files, which are <Prefix>/<path>.
+	Prefix string
+	// TTL of the keys of the files added. Zero means they do not expire.
+	TTL time.Duration
+	// MaxSize of the files added, in bytes.
+	MaxSize  int64
+	password string
+	accept   map[string]struct{}
+}
+
+// ErrTooLarge is returned by AddFile for files larger than the max-size
+// parameter.
+var ErrTooLarge = errors.New("file too large")
+
+const (
+	defaultMaxSize = 1 << 20
+	// scanCount is the COUNT hint of the SCAN calls.
+	scanCount = 100
+)
+
+// fields of the hash of a file, besides its contents in "data".
+var fields = []string{
+	"size", "mod_time", "content_type", "cache_control", "content_disposition",
+	"content_encoding", "etag", "metadata",
+}
+
+// compile-time checks of the optional interfaces Storage implements.
+var (
+	_ storage.DangerDriver  = (*Storage)(nil)
+	_ storage.ContextDriver = (*Storage)(nil)
+	_ storage.StatDriver    = (*Storage)(nil)
+	_ storage.PutDriver     = (*Storage)(nil)
+	_ storage.CopyDriver    = (*Storage)(nil)
+	_ storage.ListDriver    = (*Storage)(nil)
+	_ storage.WalkDriver    = (*Storage)(nil)
+	_ storage.RangeDriver   = (*Storage)(nil)
+)
+
+func init() {
+	storage.Register("redis", &Storage{})
+	storage.Register("rediss", &Storage{})
+}
+
+// Path returns the key prefix.
+func (s *Storage) Path() string {
+	return s.config.Prefix
+}
+
+func (s *Storage) Accepts(ext string) (accepts bool) {
+	_, accepts = s.config.accept[ext]
+	return
+}
+
+// NormalizePath returns the key of the file on the joined entries.
+func (s *Storage) NormalizePath(entries ...string) string {
+	entries = append([]string{s.config.Prefix}, entries...)
+	return path.Join(entries...)
+}
+
+var ErrURLParse = errors.New("error parsing url")
+
+func parseURL(urlString string) (*Config, error) {
+	u, err := url.Parse(urlString)
+	if err != nil {
+		// *url.Error would print the url, password included
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrURLParse, err)
+	}
+	if (u.Scheme != "redis" && u.Scheme != "rediss") || u.Hostname() == "" {
+		return nil, fmt.Errorf(
+			"%w: %s does not match \"redis[s]://[user:password@]host[:port][/db]\" format",
+			ErrURLParse,
+			util.RedactURL(urlString),
+		)
+	}
+	c := &Config{
+		Addr:    u.Host,
+		TLS:     u.Scheme == "rediss",
+		Prefix:  "assets",
+		MaxSize: defaultMaxSize,
+	}
+	if u.Port() == "" {
+		c.Addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.User = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if c.DB, err = strconv.Atoi(db); err != nil || c.DB < 0 {
+			return nil, fmt.Errorf("%w: invalid database %q", ErrURLParse, db)
+		}
+	}
+
+	q := u.Query()
+	if v := strings.Trim(q.Get("prefix"), "/"); v != "" {
+		c.Prefix = v
+	}
+	if v := q.Get("ttl"); v != "" {
+		if c.TTL, err = time.ParseDuration(v); err != nil || c.TTL < 0 {
+			return nil, fmt.Errorf("%w: invalid ttl %q", ErrURLParse, v)
+		}
+	}
+	if v := q.Get("max-size"); v != "" {
+		if c.MaxSize, err = strconv.ParseInt(v, 10, 64); err != nil || c.MaxSize <= 0 {
+			return nil, fmt.Errorf("%w: invalid max-size %q", ErrURLParse, v)
+		}
+	}
+	c.accept = util.ParseCommaSeparatedQuery(q, "accept", ".jpeg", ".jpg", ".png", ".svg")
+	return c, nil
+}
+
+// Open creates a *Storage. The urlString should be in the form
+// redis://[user:password@]host[:port][/db]?prefix=&ttl=&max-size=&accept=
+// or rediss://... to connect through TLS. The port defaults to 6379, and
+// the database to 0.
+// Each file is a hash, holding its contents and metadata, whose key is the
+// prefix and the path of the file joined by "/". Files are read whole, so
+// their size is limited.
+// The URL parameters accepted are as follows:
+//   - prefix: prefix of the keys of the files. Default assets.
+//   - ttl: time to live of the keys of the files added, as parsed by
+//     time.ParseDuration. Default none.
+//   - max-size: size in bytes above which AddFile fails with ErrTooLarge.
+//     Default 1048576 (1MiB).
+//   - accept: comma-separated list of file extensions to accept. Could be
+//     repeated. e.g. url://host/path?accept=.jpeg,.svg&accept=.png would
+//     accept .jpeg, .svg and .png files. Default .jpeg,.jpg,.png,.svg
+func (s *Storage) Open(urlString string) (storage.Driver, error) {
+	return s.OpenContext(context.Background(), urlString)
+}
+
+// OpenContext is the context-aware version of Open. ctx governs the PING
+// sent to check the connection.
+func (s *Storage) OpenContext(ctx context.Context, urlString string) (storage.Driver, error) {
+	var err error
+
+	ns := &Storage{}
+
+	ns.config, err = parseURL(urlString)
+	if err != nil {
+		return nil, s.error("open", "", err)
+	}
+	opts := &redis.Options{
+		Addr:     ns.config.Addr,
+		Username: ns.config.User,
+		Password: ns.config.password,
+		DB:       ns.config.DB,
+	}
+	if ns.config.TLS {
+		host, _, _ := net.SplitHostPort(ns.config.Addr)
+		opts.TLSConfig = &tls.Config{ServerName: host}
+	}
+	ns.client = redis.NewClient(opts)
+	if err := ns.client.Ping(ctx).Err(); err != nil {
+		ns.client.Close()
+		return nil, s.error("open", "", err)
+	}
+
+	return ns, nil
+}
+
+func (s *Storage) error(op, p string, err error) error {
+	return &storage.Error{Op: op, Driver: "redis", Path: p, Err: err}
+}
+
+// Close closes the connections to the server.
+func (s *Storage) Close() error {
+	if err := s.client.Close(); err != nil {
+		return s.error("close", "", err)
+	}
+	return nil
+}
+
+// clean returns path relative to the prefix.
+func clean(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+// key returns the key of the file on the cleaned path p.
+func (s *Storage) key(p string) string {
+	return s.config.Prefix + "/" + p
+}
+
+// putScript stores a file, if the write mode allows it: ARGV[1] is create,
+// overwrite or match, ARGV[2] the ETag to match, ARGV[3] the TTL in
+// milliseconds, and the rest the fields of the hash. It returns "ok", or
+// "exists" or "missing" along with the current ETag, for the write mode to
+// report why it failed.
+var putScript = redis.NewScript(`
+local etag = redis.call('HGET', KEYS[1], 'etag')
+if ARGV[1] == 'create' and etag then
+	return {'exists', etag}
+end
+if ARGV[1] == 'match' then
+	if not etag then
+		return {'missing', ''}
+	end
+	if etag ~= ARGV[2] then
+		return {'exists', etag}
+	end
+end
+redis.call('DEL', KEYS[1])
+redis.call('HSET', KEYS[1], unpack(ARGV, 4))
+if tonumber(ARGV[3]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+return {'ok', ''}
+`)
+
+// copyScript copies the file on KEYS[1] to KEYS[2], or renames it if
+// ARGV[3] is 1, setting its mod_time to ARGV[1]. Copies get a TTL of
+// ARGV[2] milliseconds; renamed files keep theirs.
+var copyScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 'missing'
+end
+if redis.call('EXISTS', KEYS[2]) == 1 then
+	return 'exists'
+end
+if ARGV[3] == '1' then
+	redis.call('RENAME', KEYS[1], KEYS[2])
+else
+	redis.call('HSET', KEYS[2], unpack(redis.call('HGETALL', KEYS[1])))
+	if tonumber(ARGV[2]) > 0 then
+		redis.call('PEXPIRE', KEYS[2], ARGV[2])
+	end
+end
+redis.call('HSET', KEYS[2], 'mod_time', ARGV[1])
+return 'ok'
+`)
+
+func (s *Storage) AddFile(r io.Reader, p string) (string, error) {
+	return s.addFile(context.Background(), r, p, nil)
+}
+
+// AddFileContext is the context-aware version of AddFile. Nothing is stored
+// if ctx is done before r is fully read.
+func (s *Storage) AddFileContext(ctx context.Context, r io.Reader, p string) (string, error) {
+	return s.addFile(ctx, r, p, nil)
+}
+
+// AddFileWithOptions is AddFile, configured through opts. Every write mode is
+// atomic.
+func (s *Storage) AddFileWithOptions(r io.Reader, p string, opts *storage.PutOptions) (string, error) {
+	return s.addFile(context.Background(), r, p, opts)
+}
+
+func (s *Storage) addFile(ctx context.Context, r io.Reader, p string, opts *storage.PutOptions) (string, error) {
+	p = clean(p)
+	if opts == nil {
+		opts = &storage.PutOptions{}
+	}
+	if ext := filepath.Ext(p); !s.Accepts(ext) {
+		return "", s.error("add", p, fmt.Errorf("%w %s", storage.ErrInvalidExtension, ext))
+	}
+	if err := ctx.Err(); err != nil {
+		return "", s.error("add", p, err)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(util.ContextReader(ctx, r), s.config.MaxSize+1))
+	if err != nil {
+		return "", s.error("add", p, err)
+	}
+	if int64(len(data)) > s.config.MaxSize {
+		return "", s.error("add", p, fmt.Errorf("%w: over %d bytes", ErrTooLarge, s.config.MaxSize))
+	}
+
+	meta := ""
+	if len(opts.Metadata) > 0 {
+		m := make(map[string]string, len(opts.Metadata))
+		for k, v := range opts.Metadata {
+			m[strings.ToLower(k)] = v
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			return "", s.error("add", p, err)
+		}
+		meta = string(b)
+	}
+	mode := "create"
+	switch {
+	case opts.Mode.Overwrites():
+		mode = "overwrite"
+	case opts.Mode.ETag() != "":
+		mode = "match"
+	}
+	res, err := putScript.Run(ctx, s.client, []string{s.key(p)},
+		mode, opts.Mode.ETag(), s.config.TTL.Milliseconds(),
+		"data", data,
+		"size", len(data),
+		"mod_time", time.Now().UnixNano(),
+		"content_type", opts.ResolveContentType(p),
+		"cache_control", opts.CacheControl,
+		"content_disposition", opts.ContentDisposition,
+		"content_encoding", opts.ContentEncoding,
+		"etag", fmt.Sprintf("%x", md5.Sum(data)),
+		"metadata", meta,
+	).Slice()
+	if err != nil {
+		return "", s.error("add", p, err)
+	}
+	switch res[0] {
+	case "exists":
+		err = opts.Mode.Check(&storage.FileInfo{ETag: res[1].(string)})
+	case "missing":
+		err = opts.Mode.Check(nil)
+	}
+	if err != nil {
+		return "", s.error("add", p, err)
+	}
+	return s.NormalizePath(p), nil
+}
+
+func (s *Storage) RemoveFile(p string) error {
+	return s.RemoveFileContext(context.Background(), p)
+}
+
+// RemoveFileContext is the context-aware version of RemoveFile.
+func (s *Storage) RemoveFileContext(ctx context.Context, p string) error {
+	p = clean(p)
+	if err := s.client.Del(ctx, s.key(p)).Err(); err != nil {
+		return s.error("remove", p, err)
+	}
+	return nil
+}
+
+func (s *Storage) GetFile(p string) (io.ReadCloser, error) {
+	return s.GetFileContext(context.Background(), p)
+}
+
+// GetFileContext is the context-aware version of GetFile. The file is read
+// whole before GetFileContext returns.
+func (s *Storage) GetFileContext(ctx context.Context, p string) (io.ReadCloser, error) {
+	p = clean(p)
+	data, err := s.client.HGet(ctx, s.key(p), "data").Bytes()
+	if err == redis.Nil {
+		return nil, s.error("get", p, storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, s.error("get", p, err)
+	}
+	return util.ContextReadCloser(ctx, ioutil.NopCloser(bytes.NewReader(data))), nil
+}
+
+// GetFileRange returns length bytes of the file on path starting at offset,
+// or the rest of the file if length is negative. Redis cannot read part of
+// a hash field, so the file is read whole.
+func (s *Storage) GetFileRange(p string, offset, length int64) (io.ReadCloser, error) {
+	p = clean(p)
+	data, err := s.client.HGet(context.Background(), s.key(p), "data").Bytes()
+	if err == redis.Nil {
+		return nil, s.error("get", p, storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, s.error("get", p, err)
+	}
+	offset, length, err = util.ClampRange(int64(len(data)), offset, length)
+	if err != nil {
+		return nil, s.error("get", p, err)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data[offset : offset+length])), nil
+}
+
+// fileInfo builds the FileInfo of the file on p out of the values of its
+// fields, or returns nil if there is no file.
+func fileInfo(p string, values []interface{}) (*storage.FileInfo, error) {
+	v := make([]string, len(values))
+	for i, value := range values {
+		if value == nil {
+			// the key does not exist, or expired meanwhile
+			return nil, nil
+		}
+		v[i], _ = value.(string)
+	}
+	fi := &storage.FileInfo{
+		Path:               p,
+		ContentType:        v[2],
+		CacheControl:       v[3],
+		ContentDisposition: v[4],
+		ContentEncoding:    v[5],
+		ETag:               v[6],
+	}
+	var err error
+	if fi.Size, err = strconv.ParseInt(v[0], 10, 64); err != nil {
+		return nil, err
+	}
+	modTime, err := strconv.ParseInt(v[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	fi.ModTime = time.Unix(0, modTime)
+	if v[7] != "" {
+		if err := json.Unmarshal([]byte(v[7]), &fi.Metadata); err != nil {
+			return nil, err
+		}
+	}
+	return fi, nil
+}
+
+// Stat returns the FileInfo of the file on path. The ETag is the MD5 hash of
+// its contents.
+func (s *Storage) Stat(p string) (*storage.FileInfo, error) {
+	p = clean(p)
+	values, err := s.client.HMGet(context.Background(), s.key(p), fields...).Result()
+	if err != nil {
+		return nil, s.error("stat", p, err)
+	}
+	fi, err := fileInfo(p, values)
+	if err == nil && fi == nil {
+		err = storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, s.error("stat", p, err)
+	}
+	return fi, nil
+}
+
+// Exists reports whether there is a file on path.
+func (s *Storage) Exists(p string) (bool, error) {
+	p = clean(p)
+	n, err := s.client.Exists(context.Background(), s.key(p)).Result()
+	if err != nil {
+		return false, s.error("stat", p, err)
+	}
+	return n > 0, nil
+}
+
+// Copy copies the file on src to dst, along with its content type and
+// metadata. It is atomic. The copy gets the TTL of the ttl parameter.
+func (s *Storage) Copy(src, dst string) error {
+	return s.copy("copy", src, dst, false)
+}
+
+// Move renames the file on src to dst, which keeps its TTL. It is atomic.
+func (s *Storage) Move(src, dst string) error {
+	return s.copy("move", src, dst, true)
+}
+
+func (s *Storage) copy(op, src, dst string, move bool) error {
+	src, dst = clean(src), clean(dst)
+	if ext := filepath.Ext(dst); !s.Accepts(ext) {
+		return s.error(op, dst, fmt.Errorf("%w %s", storage.ErrInvalidExtension, ext))
+	}
+	rename := "0"
+	if move {
+		rename = "1"
+	}
+	res, err := copyScript.Run(context.Background(), s.client, []string{s.key(src), s.key(dst)},
+		time.Now().UnixNano(), s.config.TTL.Milliseconds(), rename).Text()
+	switch {
+	case err != nil:
+		return s.error(op, dst, err)
+	case res == "missing":
+		return s.error(op, src, storage.ErrNotFound)
+	case res == "exists":
+		return s.error(op, dst, storage.ErrAlreadyExists)
+	}
+	return nil
+}
+
+// List returns a page of the files under prefix, out of a SCAN of every
+// key under it, so files added or removed meanwhile may or may not be
+// listed.
+func (s *Storage) List(prefix string, opts *storage.ListOptions) (*storage.ListPage, error) {
+	return util.ListFromWalk(s, prefix, opts)
+}
+
+// Walk calls fn for every file under prefix, in no particular order,
+// through SCAN. Files added or removed during the walk may or may not be
+// reported.
+func (s *Storage) Walk(prefix string, fn storage.WalkFunc) error {
+	ctx := context.Background()
+	seen := make(map[string]struct{})
+	err := s.scan(ctx, s.config.Prefix+"/"+prefix, func(keys []string) error {
+		pipe := s.client.Pipeline()
+		cmds := make([]*redis.SliceCmd, len(keys))
+		for i, key := range keys {
+			cmds[i] = pipe.HMGet(ctx, key, fields...)
+		}
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return err
+		}
+		for i, key := range keys {
+			if _, ok := seen[key]; ok {
+				// SCAN may return a key more than once
+				continue
+			}
+			seen[key] = struct{}{}
+			fi, err := fileInfo(strings.TrimPrefix(key, s.config.Prefix+"/"), cmds[i].Val())
+			if err != nil {
+				return err
+			}
+			if fi == nil {
+				continue
+			}
+			if err := fn(fi); err != nil {
+				return &util.FnError{Err: err}
+			}
+		}
+		return nil
+	})
+	if ferr, ok := err.(*util.FnError); ok {
+		return ferr.Err
+	}
+	if err != nil {
+		return s.error("walk", prefix, err)
+	}
+	return nil
+}
+
+// scan calls fn with every batch of the keys starting with prefix.
+func (s *Storage) scan(ctx context.Context, prefix string, fn func(keys []string) error) error {
+	match := globEscaper.Replace(prefix) + "*"
+	var cursor uint64
+	for {
+		keys, next, err := s.client.Scan(ctx, cursor, match, scanCount).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// globEscaper escapes the special characters of SCAN patterns.
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// EmptyContainer removes every file under the prefix, through SCAN and DEL.
+// Other keys are left alone.
+func (s *Storage) EmptyContainer() error {
+	ctx := context.Background()
+	err := s.scan(ctx, s.config.Prefix+"/", func(keys []string) error {
+		return s.client.Del(ctx, keys...).Err()
+	})
+	if err != nil {
+		return s.error("empty", "", err)
+	}
+	return nil
+}
+
+// DeleteContainer removes every file under the prefix, like EmptyContainer,
+// as there is no container to delete.
+func (s *Storage) DeleteContainer() error {
+	if err := s.EmptyContainer(); err != nil {
+		var se *storage.Error
+		if errors.As(err, &se) {
+			se.Op = "delete"
+		}
+		return err
+	}
+	return nil
+}
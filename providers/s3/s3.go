@@ -0,0 +1,281 @@
+// Package s3 implements a storage.Driver for any S3-compatible service, such
+// as MinIO, Cloudflare R2, Backblaze B2, Wasabi or a local S3 emulator.
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	s3sdk "github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/djangulo/go-storage"
+	"github.com/djangulo/go-storage/internal/util"
+)
+
+// Storage is a storage.Driver for an S3-compatible bucket.
+type Storage struct {
+	*util.Bucket
+	config *Config
+}
+
+type Config struct {
+	AutoBucketCreate bool
+	Region           string
+	Bucket           string
+	Prefix           string
+	FileACL          string
+	// Endpoint of the service, as host[:port] or a URL. Empty for AWS.
+	Endpoint string
+	// PathStyle addresses buckets as endpoint/bucket instead of
+	// bucket.endpoint.
+	PathStyle  bool
+	DisableSSL bool
+	// PublicURL the bucket is served from, e.g. through a CDN.
+	PublicURL string
+	// Conditional is set if the service enforces the If-None-Match and
+	// If-Match headers of uploads.
+	Conditional bool
+	key         string
+	secret      string
+	accept      map[string]struct{}
+}
+
+// compile-time checks of the optional interfaces Storage implements.
+var (
+	_ storage.DangerDriver  = (*Storage)(nil)
+	_ storage.ContextDriver = (*Storage)(nil)
+	_ storage.StatDriver    = (*Storage)(nil)
+	_ storage.PutDriver     = (*Storage)(nil)
+	_ storage.CopyDriver    = (*Storage)(nil)
+	_ storage.ListDriver    = (*Storage)(nil)
+	_ storage.WalkDriver    = (*Storage)(nil)
+	_ storage.RangeDriver   = (*Storage)(nil)
+	_ storage.WriterDriver  = (*Storage)(nil)
+	_ storage.PresignDriver = (*Storage)(nil)
+)
+
+func init() {
+	storage.Register("s3", &Storage{})
+}
+
+// Path returns the URL of the prefix: PublicURL if set, otherwise the URL
+// the endpoint serves the bucket from.
+func (s *Storage) Path() string {
+	return util.JoinURL(s.config.baseURL(), s.config.Prefix)
+}
+
+func (c *Config) baseURL() string {
+	if c.PublicURL != "" {
+		return c.PublicURL
+	}
+	scheme, host := "https", c.Endpoint
+	if c.DisableSSL {
+		scheme = "http"
+	}
+	if i := strings.Index(host, "://"); i >= 0 {
+		scheme, host = host[:i], host[i+3:]
+	}
+	if host == "" {
+		host = fmt.Sprintf("s3.%s.amazonaws.com", c.Region)
+	}
+	if c.PathStyle {
+		return fmt.Sprintf("%s://%s/%s", scheme, host, c.Bucket)
+	}
+	return fmt.Sprintf("%s://%s.%s", scheme, c.Bucket, host)
+}
+
+func (s *Storage) NormalizePath(entries ...string) string {
+	return util.JoinURL(s.Path(), entries...)
+}
+
+var (
+	acceptableACL = map[string]struct{}{
+		"private":                   {},
+		"public-read":               {},
+		"public-read-write":         {},
+		"aws-exec-read":             {},
+		"authenticated-read":        {},
+		"bucket-owner-read":         {},
+		"bucket-owner-full-control": {},
+		"log-delivery-write":        {},
+	}
+	acceptableAutoCreate = map[string]struct{}{
+		"0":       {},
+		"false":   {},
+		"nil":     {},
+		"disable": {},
+		"none":    {},
+		"off":     {},
+	}
+	ErrURLParse = errors.New("error parsing url")
+)
+
+func parseURL(urlString string) (*Config, error) {
+	u, err := url.Parse(urlString)
+	if err != nil {
+		// *url.Error would print the url, secret included
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrURLParse, err)
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf(
+			"%w: %s does not match \"s3://[key:secret@]bucket/prefix\" format",
+			ErrURLParse,
+			util.RedactURL(urlString),
+		)
+	}
+	c := &Config{
+		Region:           "us-east-1",
+		AutoBucketCreate: true,
+		Bucket:           u.Host,
+		Prefix:           "/" + strings.Trim(u.Path, "/"),
+	}
+	if u.User != nil {
+		c.key = u.User.Username()
+		c.secret, _ = u.User.Password()
+	}
+
+	q := u.Query()
+	if region := q.Get("region"); region != "" {
+		c.Region = region
+	}
+	if acl := q.Get("acl"); acl != "" {
+		acl = strings.ToLower(acl)
+		if _, ok := acceptableACL[acl]; ok {
+			c.FileACL = acl
+		} else {
+			return nil, fmt.Errorf("%w: unknown acl: %s", ErrURLParse, acl)
+		}
+	}
+	if ac := q.Get("auto-create"); ac != "" {
+		ac = strings.ToLower(ac)
+		if _, ok := acceptableAutoCreate[ac]; ok {
+			c.AutoBucketCreate = false
+		} else {
+			return nil, fmt.Errorf("%w: unknown auto-create value: %s", ErrURLParse, ac)
+		}
+	}
+	c.Endpoint = q.Get("endpoint")
+	if c.PathStyle, err = util.ParseBoolQuery(q, "path-style", false); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrURLParse, err)
+	}
+	if c.DisableSSL, err = util.ParseBoolQuery(q, "disable-ssl", false); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrURLParse, err)
+	}
+	if c.Conditional, err = util.ParseBoolQuery(q, "conditional", true); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrURLParse, err)
+	}
+	if pu := q.Get("public-url"); pu != "" {
+		if pub, err := url.Parse(pu); err != nil || pub.Scheme == "" || pub.Host == "" {
+			return nil, fmt.Errorf("%w: invalid public-url: %s", ErrURLParse, pu)
+		}
+		c.PublicURL = pu
+	}
+	c.accept = util.ParseCommaSeparatedQuery(q, "accept", ".jpeg", ".jpg", ".png", ".svg")
+	return c, nil
+}
+
+// Open creates a *Storage. The urlString should be in the form
+// s3://[key:secret@]bucket/prefix?endpoint=&region=&path-style=&disable-ssl=&conditional=&public-url=&accept=&auto-create=&acl=
+// Without key and secret, credentials are looked up like the AWS CLI does,
+// from the environment and ~/.aws/credentials.
+// The URL parameters accepted are as follows:
+//   - endpoint: host[:port] or URL of the service, e.g. localhost:9000 for a
+//     local MinIO, or <account>.r2.cloudflarestorage.com. Default AWS.
+//   - region: region of the bucket. Default "us-east-1", which is what most
+//     S3-compatible services expect ("auto" for R2).
+//   - path-style: address the bucket as endpoint/bucket rather than
+//     bucket.endpoint if true. Required by MinIO and most emulators.
+//     Default false.
+//   - disable-ssl: connect through plain HTTP if true. Default false.
+//   - conditional: send the write mode of uploads as If-None-Match/If-Match
+//     headers, which the service enforces atomically. Set it to false for
+//     services that ignore them, to check the mode through HeadObject
+//     instead, which is not atomic. Default true.
+//   - public-url: base URL the bucket is served from, e.g. a CDN or R2 public
+//     bucket URL. Returned paths are built on it. Default the endpoint URL.
+//   - accept: comma-separated list of file extensions to accept. Could be
+//     repeated. e.g. url://bucket/prefix?accept=.jpeg,.svg&accept=.png would
+//     accept .jpeg, .svg and .png files. Default .jpeg,.jpg,.png,.svg
+//   - auto-create: will NOT create the bucket automatically if this value is
+//     any of: 0, off, disable, false.
+//   - acl: canned ACL policy for file uploads. Default none, as not every
+//     service supports ACLs.
+func (s *Storage) Open(urlString string) (storage.Driver, error) {
+	return s.OpenContext(context.Background(), urlString)
+}
+
+// OpenContext is the context-aware version of Open. ctx governs the bucket
+// lookup and creation.
+func (s *Storage) OpenContext(ctx context.Context, urlString string) (storage.Driver, error) {
+	var err error
+
+	ns := new(Storage)
+
+	ns.config, err = parseURL(urlString)
+	if err != nil {
+		return nil, s.error("open", "", err)
+	}
+	cfg := &aws.Config{
+		Region:           &ns.config.Region,
+		S3ForcePathStyle: &ns.config.PathStyle,
+		DisableSSL:       &ns.config.DisableSSL,
+	}
+	if ns.config.Endpoint != "" {
+		cfg.Endpoint = &ns.config.Endpoint
+	}
+	if ns.config.key != "" {
+		cfg.Credentials = credentials.NewStaticCredentials(ns.config.key, ns.config.secret, "")
+	}
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		return nil, s.error("open", "", err)
+	}
+	client := s3sdk.New(sess)
+
+	var exists = util.BucketExists(ctx, client, ns.config.Bucket)
+	if !exists && !ns.config.AutoBucketCreate {
+		return nil, s.error("open", "", errors.New("bucket does not exist; AutoBucketCreation is off"))
+	}
+	if !exists && ns.config.AutoBucketCreate {
+		input := &s3sdk.CreateBucketInput{Bucket: &ns.config.Bucket}
+		// us-east-1 is the default location, and rejected as a constraint
+		if ns.config.Region != "us-east-1" {
+			input.CreateBucketConfiguration = &s3sdk.CreateBucketConfiguration{
+				LocationConstraint: &ns.config.Region,
+			}
+		}
+		if _, err := client.CreateBucketWithContext(ctx, input); err != nil {
+			return nil, s.error("open", "", err)
+		}
+	}
+
+	ns.Bucket = &util.Bucket{
+		Driver:  "s3",
+		Session: sess,
+		Client:  client,
+		Name:    ns.config.Bucket,
+		// unlike aws-s3, keys have no leading slash, which most
+		// S3-compatible services drop or reject
+		Prefix:      strings.TrimPrefix(ns.config.Prefix, "/"),
+		FileACL:     ns.config.FileACL,
+		ACLs:        acceptableACL,
+		Conditional: ns.config.Conditional,
+		URL:         ns.Path(),
+		Accept:      ns.config.accept,
+	}
+
+	return ns, nil
+}
+
+func (s *Storage) error(op, p string, err error) error {
+	return &storage.Error{Op: op, Driver: "s3", Path: p, Err: util.AWSError(err)}
+}
@@ -0,0 +1,195 @@
+package s3
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+
+	"github.com/djangulo/go-storage"
+	storagetest "github.com/djangulo/go-storage/testing"
+)
+
+// fakeS3 starts an in-process S3 server, returning its host:port. It patches
+// two gaps between gofakes3 and S3: gofakes3 drops the Cache-Control header,
+// and keeps the metadata of an object when it is overwritten.
+func fakeS3(t *testing.T) (string, func()) {
+	t.Helper()
+	faker := gofakes3.New(&overwritingBackend{s3mem.New()})
+	handler := faker.Server()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// gofakes3 stores every X-Amz- header
+		if cc := r.Header.Get("Cache-Control"); cc != "" {
+			r.Header.Set(fakeCacheControl, cc)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	return strings.TrimPrefix(ts.URL, "http://"), ts.Close
+}
+
+const fakeCacheControl = "X-Amz-Fake-Cache-Control"
+
+type overwritingBackend struct {
+	*s3mem.Backend
+}
+
+func (b *overwritingBackend) PutObject(bucket, key string, meta map[string]string, input io.Reader, size int64) (gofakes3.PutObjectResult, error) {
+	if cc, ok := meta[fakeCacheControl]; ok {
+		delete(meta, fakeCacheControl)
+		meta["Cache-Control"] = cc
+	}
+	if _, err := b.Backend.DeleteObject(bucket, key); err != nil {
+		return gofakes3.PutObjectResult{}, err
+	}
+	return b.Backend.PutObject(bucket, key, meta, input, size)
+}
+
+func TestS3(t *testing.T) {
+	endpoint, cleanup := fakeS3(t)
+	defer cleanup()
+
+	drv, err := storage.Open("s3://key:secret@test-bucket/assets?accept=.txt&path-style=true&disable-ssl=true&endpoint=" + endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storagetest.Test(t, drv)
+
+	t.Run("path", func(t *testing.T) {
+		want := "http://" + endpoint + "/test-bucket/assets/a.txt"
+		if got := drv.NormalizePath("a.txt"); got != want {
+			t.Errorf("expected %q got %q", want, got)
+		}
+	})
+	t.Run("delete container", func(t *testing.T) {
+		if err := drv.(storage.DangerDriver).DeleteContainer(); err != nil {
+			t.Fatal(err)
+		}
+		_, err := storage.Open("s3://key:secret@test-bucket/assets?auto-create=false&path-style=true&disable-ssl=true&endpoint=" + endpoint)
+		if err == nil {
+			t.Error("expected an error opening a deleted bucket")
+		}
+	})
+}
+
+// TestNotConditional checks the write modes without conditional headers,
+// which gofakes3 ignores anyway.
+func TestNotConditional(t *testing.T) {
+	endpoint, cleanup := fakeS3(t)
+	defer cleanup()
+
+	drv, err := storage.Open("s3://key:secret@test-bucket/assets?accept=.txt&conditional=false&path-style=true&disable-ssl=true&endpoint=" + endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storagetest.TestWriteModes(t, drv)
+	storagetest.TestWriter(t, drv)
+
+	t.Run("created while writing", func(t *testing.T) {
+		path := "tests/test-not-conditional.txt"
+		defer drv.RemoveFile(path)
+		w, err := storage.OpenWriter(drv, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, "second")
+		if _, err := drv.AddFile(strings.NewReader("first"), path); err != nil {
+			w.CloseWithError(err)
+			t.Fatal(err)
+		}
+		if err := w.Close(); !errors.Is(err, storage.ErrAlreadyExists) {
+			t.Errorf("expected %v got %v", storage.ErrAlreadyExists, err)
+		}
+		rc, err := drv.GetFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if b, _ := ioutil.ReadAll(rc); string(b) != "first" {
+			t.Errorf("expected %q got %q", "first", b)
+		}
+	})
+}
+
+func TestParseURL(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want *Config
+		err  error
+	}{
+		{
+			"s3://testbucket/assets?accept=.txt",
+			&Config{
+				Bucket:           "testbucket",
+				Prefix:           "/assets",
+				AutoBucketCreate: true,
+				accept:           map[string]struct{}{".txt": {}},
+				Region:           "us-east-1",
+				Conditional:      true,
+			},
+			nil,
+		},
+		{
+			"s3://id:s3cr3t@testbucket/assets/?endpoint=localhost:9000&path-style=1&disable-ssl=true&conditional=false&region=auto&acl=private&public-url=https://cdn.example.com",
+			&Config{
+				Bucket:           "testbucket",
+				Prefix:           "/assets",
+				AutoBucketCreate: true,
+				accept:           map[string]struct{}{".jpeg": {}, ".jpg": {}, ".png": {}, ".svg": {}},
+				Region:           "auto",
+				FileACL:          "private",
+				Endpoint:         "localhost:9000",
+				PathStyle:        true,
+				DisableSSL:       true,
+				PublicURL:        "https://cdn.example.com",
+				key:              "id",
+				secret:           "s3cr3t",
+			},
+			nil,
+		},
+		{"s3://testbucket/assets?path-style=maybe", nil, ErrURLParse},
+		{"s3://testbucket/assets?conditional=maybe", nil, ErrURLParse},
+		{"s3://testbucket/assets?acl=everyone", nil, ErrURLParse},
+		{"s3://testbucket/assets?public-url=cdn", nil, ErrURLParse},
+		{"s3:///assets", nil, ErrURLParse},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseURL(tt.in)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("expected %v got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\nexpected\t%+v\ngot\t\t%+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPath(t *testing.T) {
+	for _, tt := range []struct {
+		config *Config
+		want   string
+	}{
+		{&Config{Bucket: "b", Prefix: "/assets", Region: "us-east-2"}, "https://b.s3.us-east-2.amazonaws.com/assets"},
+		{&Config{Bucket: "b", Prefix: "/assets", Endpoint: "localhost:9000", PathStyle: true, DisableSSL: true}, "http://localhost:9000/b/assets"},
+		{&Config{Bucket: "b", Prefix: "/assets", Endpoint: "https://s3.us-west-002.backblazeb2.com"}, "https://b.s3.us-west-002.backblazeb2.com/assets"},
+		{&Config{Bucket: "b", Prefix: "/assets", Endpoint: "localhost:9000", PublicURL: "https://cdn.example.com/files"}, "https://cdn.example.com/files/assets"},
+	} {
+		s := &Storage{config: tt.config}
+		if got := s.Path(); got != tt.want {
+			t.Errorf("expected %q got %q", tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package sftp
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/pkg/sftp"
+
+	"github.com/djangulo/go-storage"
+)
+
+// OpenWriter returns a storage.Writer that writes to a temporary file next to
+// path, which Close moves to path like AddFile does. Only the write mode of
+// opts is honoured: SFTP cannot store content types, headers nor metadata,
+// and setting them fails with storage.ErrNotSupported.
+func (s *Storage) OpenWriter(p string, opts *storage.PutOptions) (storage.Writer, error) {
+	if opts == nil {
+		opts = &storage.PutOptions{}
+	}
+	if opts.ContentType != "" || opts.CacheControl != "" || opts.ContentDisposition != "" ||
+		opts.ContentEncoding != "" || len(opts.Metadata) > 0 {
+		return nil, s.error("add", p, fmt.Errorf("%w: sftp only accepts a write mode", storage.ErrNotSupported))
+	}
+	client, err := s.prepare(context.Background(), p)
+	if err != nil {
+		return nil, s.error("add", p, err)
+	}
+	file, err := createTemp(client, path.Dir(s.remote(p)))
+	if err != nil {
+		return nil, s.error("add", p, err)
+	}
+	return &writer{s: s, client: client, file: file, path: p, mode: opts.Mode}, nil
+}
+
+type writer struct {
+	s      *Storage
+	client *sftp.Client
+	file   *sftp.File
+	path   string
+	mode   storage.WriteMode
+	closed bool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, w.s.error("add", w.path, storage.ErrWriterClosed)
+	}
+	n, err := w.file.Write(p)
+	if err != nil {
+		return n, w.s.error("add", w.path, err)
+	}
+	return n, nil
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return w.s.error("add", w.path, storage.ErrWriterClosed)
+	}
+	w.closed = true
+	err := w.file.Close()
+	if err == nil {
+		err = commit(w.client, w.file.Name(), w.s.remote(w.path), w.mode)
+	}
+	if err != nil {
+		w.client.Remove(w.file.Name())
+		return w.s.error("add", w.path, err)
+	}
+	return nil
+}
+
+func (w *writer) CloseWithError(err error) error {
+	if w.closed {
+		return w.s.error("add", w.path, storage.ErrWriterClosed)
+	}
+	w.closed = true
+	w.file.Close()
+	if err := w.client.Remove(w.file.Name()); err != nil {
+		return w.s.error("add", w.path, err)
+	}
+	return nil
+}
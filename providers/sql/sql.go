@@ -0,0 +1,795 @@
+// Package sqlstorage implements a storage.Driver for a table of a SQL
+// database, through database/sql. Files are split into chunks, stored one
+// per row, so they are read without being loaded whole.
+package sqlstorage
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/djangulo/go-storage"
+	"github.com/djangulo/go-storage/internal/util"
+)
+
+// Storage is a storage.Driver for a table of a SQL database.
+type Storage struct {
+	config  *Config
+	db      *sql.DB
+	dialect *dialect
+	// queries by name, with the table names and placeholders of the
+	// dialect.
+	queries map[string]string
+}
+
+type Config struct {
+	// Dialect is either sqlite or postgres.
+	Dialect string
+	// Driver is the name of the database/sql driver.
+	Driver string
+	// Table holds the files, and Table_chunks their contents.
+	Table string
+	// ChunkSize is the size of the chunks files are split into.
+	ChunkSize int
+	// dsn is passed to the driver. It may hold a password.
+	dsn    string
+	accept map[string]struct{}
+}
+
+// dialect holds the differences between the supported databases.
+type dialect struct {
+	// driver is the default database/sql driver.
+	driver string
+	// blob is the column type of the chunks.
+	blob string
+	// forUpdate locks the rows selected in a transaction, where supported.
+	forUpdate string
+	// numbered placeholders ($1, $2...) rather than ?.
+	numbered bool
+}
+
+var dialects = map[string]*dialect{
+	// SQLite locks the whole database on writes instead
+	"sqlite":   {driver: "sqlite3", blob: "BLOB"},
+	"postgres": {driver: "postgres", blob: "BYTEA", forUpdate: " FOR UPDATE", numbered: true},
+}
+
+const (
+	defaultChunkSize = 256 << 10
+	// walkBatch is the number of rows Walk reads at once.
+	walkBatch = 1000
+)
+
+// compile-time checks of the optional interfaces Storage implements.
+var (
+	_ storage.DangerDriver  = (*Storage)(nil)
+	_ storage.ContextDriver = (*Storage)(nil)
+	_ storage.StatDriver    = (*Storage)(nil)
+	_ storage.PutDriver     = (*Storage)(nil)
+	_ storage.CopyDriver    = (*Storage)(nil)
+	_ storage.ListDriver    = (*Storage)(nil)
+	_ storage.WalkDriver    = (*Storage)(nil)
+	_ storage.RangeDriver   = (*Storage)(nil)
+)
+
+func init() {
+	storage.Register("sql+sqlite", &Storage{})
+	storage.Register("sql+postgres", &Storage{})
+}
+
+// Path returns the name of the table.
+func (s *Storage) Path() string {
+	return s.config.Table
+}
+
+func (s *Storage) Accepts(ext string) (accepts bool) {
+	_, accepts = s.config.accept[ext]
+	return
+}
+
+func (s *Storage) NormalizePath(entries ...string) string {
+	entries = append([]string{s.config.Table}, entries...)
+	return path.Join(entries...)
+}
+
+var ErrURLParse = errors.New("error parsing url")
+
+// identifier matches the table names accepted, optionally schema-qualified.
+var identifier = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*\.)?[A-Za-z_][A-Za-z0-9_]*$`)
+
+func parseURL(urlString string) (*Config, error) {
+	u, err := url.Parse(urlString)
+	if err != nil {
+		// *url.Error would print the url, password included
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrURLParse, err)
+	}
+	c := &Config{Dialect: strings.TrimPrefix(u.Scheme, "sql+")}
+	d, ok := dialects[c.Dialect]
+	if !ok || (c.Dialect == "sqlite" && u.Host+u.Path == "") || (c.Dialect == "postgres" && u.Host == "") {
+		return nil, fmt.Errorf(
+			"%w: %s does not match \"sql+sqlite:///path/to/db\" nor \"sql+postgres://[user:password@]host[:port]/database\" format",
+			ErrURLParse,
+			util.RedactURL(urlString),
+		)
+	}
+
+	q := u.Query()
+	c.Driver, c.Table, c.ChunkSize = d.driver, "files", defaultChunkSize
+	if v := q.Get("driver"); v != "" {
+		c.Driver = v
+	}
+	if v := q.Get("table"); v != "" {
+		if !identifier.MatchString(v) {
+			return nil, fmt.Errorf("%w: invalid table name %q", ErrURLParse, v)
+		}
+		c.Table = v
+	}
+	if v := q.Get("chunk-size"); v != "" {
+		if c.ChunkSize, err = strconv.Atoi(v); err != nil || c.ChunkSize <= 0 {
+			return nil, fmt.Errorf("%w: invalid chunk-size %q", ErrURLParse, v)
+		}
+	}
+	c.accept = util.ParseCommaSeparatedQuery(q, "accept", ".jpeg", ".jpg", ".png", ".svg")
+
+	// the other parameters are the driver's
+	for _, k := range []string{"driver", "table", "chunk-size", "accept"} {
+		q.Del(k)
+	}
+	if c.Dialect == "sqlite" {
+		c.dsn = filepath.FromSlash(u.Host + u.Path)
+		if len(q) > 0 {
+			c.dsn += "?" + q.Encode()
+		}
+	} else {
+		c.dsn = (&url.URL{Scheme: "postgres", User: u.User, Host: u.Host, Path: u.Path, RawQuery: q.Encode()}).String()
+	}
+	return c, nil
+}
+
+// Open creates a *Storage. The urlString should be in the form
+// sql+sqlite:///path/to/db?table=&chunk-size=&driver=&accept=
+// for SQLite, where relative paths are written as sql+sqlite://./db, or
+// sql+postgres://[user:password@]host[:port]/database?table=&chunk-size=&driver=&accept=
+// for PostgreSQL. The database/sql driver is not imported by this package:
+// import the one of your choice.
+// The tables are created if missing. SQLite databases are used through a
+// single connection, as SQLite locks the whole database on writes.
+// The URL parameters accepted are as follows:
+//   - table: name of the table holding the files. Their contents are held
+//     by <table>_chunks. Default files.
+//   - chunk-size: size in bytes of the chunks files are split into. Default
+//     262144 (256KiB).
+//   - driver: name of the database/sql driver. Default sqlite3 for SQLite,
+//     as registered by github.com/mattn/go-sqlite3, and postgres for
+//     PostgreSQL, as registered by github.com/lib/pq.
+//   - accept: comma-separated list of file extensions to accept. Could be
+//     repeated. e.g. url://host/path?accept=.jpeg,.svg&accept=.png would
+//     accept .jpeg, .svg and .png files. Default .jpeg,.jpg,.png,.svg
+//
+// Any other parameter is passed on to the driver, e.g. sslmode for
+// PostgreSQL or _busy_timeout for go-sqlite3.
+func (s *Storage) Open(urlString string) (storage.Driver, error) {
+	return s.OpenContext(context.Background(), urlString)
+}
+
+// OpenContext is the context-aware version of Open. ctx governs the
+// connection and the creation of the tables.
+func (s *Storage) OpenContext(ctx context.Context, urlString string) (storage.Driver, error) {
+	var err error
+
+	ns := &Storage{}
+
+	ns.config, err = parseURL(urlString)
+	if err != nil {
+		return nil, s.error("open", "", err)
+	}
+	ns.dialect = dialects[ns.config.Dialect]
+	ns.prepareQueries()
+
+	ns.db, err = sql.Open(ns.config.Driver, ns.config.dsn)
+	if err != nil {
+		return nil, s.error("open", "", err)
+	}
+	if ns.config.Dialect == "sqlite" {
+		ns.db.SetMaxOpenConns(1)
+	}
+	for _, name := range []string{"create files", "create chunks"} {
+		if _, err := ns.db.ExecContext(ctx, ns.queries[name]); err != nil {
+			ns.db.Close()
+			return nil, s.error("open", "", err)
+		}
+	}
+
+	return ns, nil
+}
+
+func (s *Storage) error(op, p string, err error) error {
+	return &storage.Error{Op: op, Driver: "sql", Path: p, Err: err}
+}
+
+// Close closes the database.
+func (s *Storage) Close() error {
+	if err := s.db.Close(); err != nil {
+		return s.error("close", "", err)
+	}
+	return nil
+}
+
+// columns of the files table that make up a FileInfo.
+const columns = "path, size, mod_time, content_type, cache_control, content_disposition, content_encoding, etag, metadata"
+
+func (s *Storage) prepareQueries() {
+	s.queries = make(map[string]string)
+	tables := strings.NewReplacer("{files}", s.config.Table, "{chunks}", s.config.Table+"_chunks", "{blob}", s.dialect.blob)
+	for name, q := range map[string]string{
+		"create files": `CREATE TABLE IF NOT EXISTS {files} (
+	path TEXT PRIMARY KEY,
+	id TEXT NOT NULL,
+	size BIGINT NOT NULL,
+	chunk_size BIGINT NOT NULL,
+	mod_time BIGINT NOT NULL,
+	content_type TEXT NOT NULL,
+	cache_control TEXT NOT NULL,
+	content_disposition TEXT NOT NULL,
+	content_encoding TEXT NOT NULL,
+	etag TEXT NOT NULL,
+	metadata TEXT NOT NULL
+)`,
+		"create chunks": `CREATE TABLE IF NOT EXISTS {chunks} (
+	id TEXT NOT NULL,
+	seq BIGINT NOT NULL,
+	data {blob} NOT NULL,
+	PRIMARY KEY (id, seq)
+)`,
+		"insert chunk":  "INSERT INTO {chunks} (id, seq, data) VALUES (?, ?, ?)",
+		"copy chunks":   "INSERT INTO {chunks} (id, seq, data) SELECT ?, seq, data FROM {chunks} WHERE id = ?",
+		"get chunk":     "SELECT data FROM {chunks} WHERE id = ? AND seq = ?",
+		"delete chunks": "DELETE FROM {chunks} WHERE id = ?",
+		"create": "INSERT INTO {files} (id, chunk_size, " + columns + ") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) " +
+			"ON CONFLICT (path) DO NOTHING",
+		"upsert": "INSERT INTO {files} (id, chunk_size, " + columns + ") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) " +
+			"ON CONFLICT (path) DO UPDATE SET id = excluded.id, chunk_size = excluded.chunk_size, size = excluded.size, " +
+			"mod_time = excluded.mod_time, content_type = excluded.content_type, cache_control = excluded.cache_control, " +
+			"content_disposition = excluded.content_disposition, content_encoding = excluded.content_encoding, " +
+			"etag = excluded.etag, metadata = excluded.metadata",
+		"lock":         "SELECT id, etag FROM {files} WHERE path = ?" + s.dialect.forUpdate,
+		"open":         "SELECT id, size, chunk_size FROM {files} WHERE path = ?",
+		"get":          "SELECT id, chunk_size, " + columns + " FROM {files} WHERE path = ?" + s.dialect.forUpdate,
+		"stat":         "SELECT " + columns + " FROM {files} WHERE path = ?",
+		"exists":       "SELECT COUNT(*) FROM {files} WHERE path = ?",
+		"delete":       "DELETE FROM {files} WHERE path = ?",
+		"move":         "UPDATE {files} SET path = ?, mod_time = ? WHERE path = ? AND NOT EXISTS (SELECT 1 FROM {files} WHERE path = ?)",
+		"walk":         "SELECT " + columns + " FROM {files} WHERE path > ? AND substr(path, 1, ?) = ? ORDER BY path LIMIT ?",
+		"empty files":  "DELETE FROM {files}",
+		"empty chunks": "DELETE FROM {chunks}",
+		"drop files":   "DROP TABLE IF EXISTS {files}",
+		"drop chunks":  "DROP TABLE IF EXISTS {chunks}",
+	} {
+		s.queries[name] = s.dialect.rebind(tables.Replace(q))
+	}
+}
+
+// rebind replaces the ? placeholders of q with numbered ones, if the
+// dialect uses them.
+func (d *dialect) rebind(q string) string {
+	if !d.numbered {
+		return q
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range q {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+		n++
+		b.WriteString("$" + strconv.Itoa(n))
+	}
+	return b.String()
+}
+
+// clean returns the key of path in the table.
+func clean(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+// newID returns a random identifier for the chunks of a file.
+func newID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+func (s *Storage) AddFile(r io.Reader, p string) (string, error) {
+	return s.addFile(context.Background(), r, p, nil)
+}
+
+// AddFileContext is the context-aware version of AddFile. Nothing is stored
+// if ctx is done before r is fully read.
+func (s *Storage) AddFileContext(ctx context.Context, r io.Reader, p string) (string, error) {
+	return s.addFile(ctx, r, p, nil)
+}
+
+// AddFileWithOptions is AddFile, configured through opts. The contents are
+// written before the row of the file, which is inserted or replaced in a
+// transaction, so every write mode is atomic and readers never see a
+// partial file.
+func (s *Storage) AddFileWithOptions(r io.Reader, p string, opts *storage.PutOptions) (string, error) {
+	return s.addFile(context.Background(), r, p, opts)
+}
+
+func (s *Storage) addFile(ctx context.Context, r io.Reader, p string, opts *storage.PutOptions) (string, error) {
+	p = clean(p)
+	if opts == nil {
+		opts = &storage.PutOptions{}
+	}
+	if ext := filepath.Ext(p); !s.Accepts(ext) {
+		return "", s.error("add", p, fmt.Errorf("%w %s", storage.ErrInvalidExtension, ext))
+	}
+	if err := ctx.Err(); err != nil {
+		return "", s.error("add", p, err)
+	}
+
+	id, err := newID()
+	if err != nil {
+		return "", s.error("add", p, err)
+	}
+	fi, err := s.writeChunks(ctx, id, r)
+	if err == nil {
+		fi.Path = p
+		fi.ContentType = opts.ResolveContentType(p)
+		fi.CacheControl = opts.CacheControl
+		fi.ContentDisposition = opts.ContentDisposition
+		fi.ContentEncoding = opts.ContentEncoding
+		if len(opts.Metadata) > 0 {
+			fi.Metadata = make(map[string]string, len(opts.Metadata))
+			for k, v := range opts.Metadata {
+				fi.Metadata[strings.ToLower(k)] = v
+			}
+		}
+		err = s.commit(ctx, id, fi, opts.Mode)
+	}
+	if err != nil {
+		s.db.ExecContext(context.Background(), s.queries["delete chunks"], id)
+		return "", s.error("add", p, err)
+	}
+	return s.NormalizePath(p), nil
+}
+
+// writeChunks stores the contents of r as the chunks of id, and returns
+// their size and MD5 hash.
+func (s *Storage) writeChunks(ctx context.Context, id string, r io.Reader) (*storage.FileInfo, error) {
+	var (
+		h    = md5.New()
+		buf  = make([]byte, s.config.ChunkSize)
+		size int64
+	)
+	r = io.TeeReader(util.ContextReader(ctx, r), h)
+	for seq := 0; ; seq++ {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if _, err := s.db.ExecContext(ctx, s.queries["insert chunk"], id, seq, buf[:n]); err != nil {
+				return nil, err
+			}
+			size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &storage.FileInfo{
+		Size:    size,
+		ModTime: time.Now(),
+		ETag:    hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// commit stores the row of the file fi, whose contents are the chunks of
+// id, if mode allows it. The chunks of the file it replaces are removed.
+func (s *Storage) commit(ctx context.Context, id string, fi *storage.FileInfo, mode storage.WriteMode) error {
+	meta, err := encodeMetadata(fi.Metadata)
+	if err != nil {
+		return err
+	}
+	args := []interface{}{
+		id, s.config.ChunkSize, fi.Path, fi.Size, fi.ModTime.UnixNano(), fi.ContentType,
+		fi.CacheControl, fi.ContentDisposition, fi.ContentEncoding, fi.ETag, meta,
+	}
+
+	if mode == storage.CreateOnly {
+		res, err := s.db.ExecContext(ctx, s.queries["create"], args...)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return storage.ErrAlreadyExists
+		}
+		return nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var oldID, oldETag string
+	var existing *storage.FileInfo
+	switch err := tx.QueryRowContext(ctx, s.queries["lock"], fi.Path).Scan(&oldID, &oldETag); err {
+	case nil:
+		existing = &storage.FileInfo{ETag: oldETag}
+	case sql.ErrNoRows:
+	default:
+		return err
+	}
+	if err := mode.Check(existing); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, s.queries["upsert"], args...); err != nil {
+		return err
+	}
+	if existing != nil {
+		if _, err := tx.ExecContext(ctx, s.queries["delete chunks"], oldID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func encodeMetadata(m map[string]string) (string, error) {
+	if len(m) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(m)
+	return string(b), err
+}
+
+// scanner is either a *sql.Row or *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanInfo scans the columns of a FileInfo, preceded by dest.
+func scanInfo(row scanner, dest ...interface{}) (*storage.FileInfo, error) {
+	var (
+		fi      storage.FileInfo
+		modTime int64
+		meta    string
+	)
+	dest = append(dest, &fi.Path, &fi.Size, &modTime, &fi.ContentType, &fi.CacheControl,
+		&fi.ContentDisposition, &fi.ContentEncoding, &fi.ETag, &meta)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	fi.ModTime = time.Unix(0, modTime)
+	if meta != "" {
+		if err := json.Unmarshal([]byte(meta), &fi.Metadata); err != nil {
+			return nil, err
+		}
+	}
+	return &fi, nil
+}
+
+func (s *Storage) RemoveFile(p string) error {
+	return s.RemoveFileContext(context.Background(), p)
+}
+
+// RemoveFileContext is the context-aware version of RemoveFile.
+func (s *Storage) RemoveFileContext(ctx context.Context, p string) error {
+	p = clean(p)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return s.error("remove", p, err)
+	}
+	defer tx.Rollback()
+	var id, etag string
+	switch err := tx.QueryRowContext(ctx, s.queries["lock"], p).Scan(&id, &etag); err {
+	case nil:
+	case sql.ErrNoRows:
+		return nil
+	default:
+		return s.error("remove", p, err)
+	}
+	if _, err := tx.ExecContext(ctx, s.queries["delete"], p); err != nil {
+		return s.error("remove", p, err)
+	}
+	if _, err := tx.ExecContext(ctx, s.queries["delete chunks"], id); err != nil {
+		return s.error("remove", p, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return s.error("remove", p, err)
+	}
+	return nil
+}
+
+func (s *Storage) GetFile(p string) (io.ReadCloser, error) {
+	return s.GetFileContext(context.Background(), p)
+}
+
+// GetFileContext is the context-aware version of GetFile. ctx also governs
+// reads from the returned io.ReadCloser, which query one chunk at a time.
+func (s *Storage) GetFileContext(ctx context.Context, p string) (io.ReadCloser, error) {
+	return s.open(ctx, clean(p), 0, -1)
+}
+
+// GetFileRange returns length bytes of the file on path starting at offset,
+// or the rest of the file if length is negative. Only the chunks holding the
+// range are read.
+func (s *Storage) GetFileRange(p string, offset, length int64) (io.ReadCloser, error) {
+	return s.open(context.Background(), clean(p), offset, length)
+}
+
+func (s *Storage) open(ctx context.Context, p string, offset, length int64) (io.ReadCloser, error) {
+	var (
+		id              string
+		size, chunkSize int64
+	)
+	err := s.db.QueryRowContext(ctx, s.queries["open"], p).Scan(&id, &size, &chunkSize)
+	if err == sql.ErrNoRows {
+		return nil, s.error("get", p, storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, s.error("get", p, err)
+	}
+	offset, length, err = util.ClampRange(size, offset, length)
+	if err != nil {
+		return nil, s.error("get", p, err)
+	}
+	return &reader{
+		ctx:       ctx,
+		s:         s,
+		path:      p,
+		id:        id,
+		seq:       offset / chunkSize,
+		skip:      offset % chunkSize,
+		remaining: length,
+	}, nil
+}
+
+// reader reads a file one chunk at a time.
+type reader struct {
+	ctx  context.Context
+	s    *Storage
+	path string
+	id   string
+	// seq of the next chunk to read.
+	seq int64
+	// skip is the number of bytes of the next chunk before the range.
+	skip      int64
+	remaining int64
+	buf       []byte
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if len(r.buf) == 0 {
+		var data []byte
+		err := r.s.db.QueryRowContext(r.ctx, r.s.queries["get chunk"], r.id, r.seq).Scan(&data)
+		if err == sql.ErrNoRows {
+			// the file was replaced or removed since it was opened
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return 0, r.s.error("get", r.path, err)
+		}
+		if r.skip > int64(len(data)) {
+			r.skip = int64(len(data))
+		}
+		r.buf, r.skip = data[r.skip:], 0
+		r.seq++
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.remaining -= int64(n)
+	return n, nil
+}
+
+func (r *reader) Close() error {
+	r.remaining = 0
+	r.buf = nil
+	return nil
+}
+
+// Stat returns the FileInfo of the file on path. The ETag is the MD5 hash of
+// its contents.
+func (s *Storage) Stat(p string) (*storage.FileInfo, error) {
+	p = clean(p)
+	fi, err := scanInfo(s.db.QueryRow(s.queries["stat"], p))
+	if err == sql.ErrNoRows {
+		return nil, s.error("stat", p, storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, s.error("stat", p, err)
+	}
+	return fi, nil
+}
+
+// Exists reports whether there is a file on path.
+func (s *Storage) Exists(p string) (bool, error) {
+	p = clean(p)
+	var n int
+	if err := s.db.QueryRow(s.queries["exists"], p).Scan(&n); err != nil {
+		return false, s.error("stat", p, err)
+	}
+	return n > 0, nil
+}
+
+// Copy copies the file on src to dst, along with its content type and
+// metadata, inside the database.
+func (s *Storage) Copy(src, dst string) error {
+	return s.copy("copy", src, dst)
+}
+
+// Move renames the file on src to dst. It is atomic.
+func (s *Storage) Move(src, dst string) error {
+	src, dst = clean(src), clean(dst)
+	if ext := filepath.Ext(dst); !s.Accepts(ext) {
+		return s.error("move", dst, fmt.Errorf("%w %s", storage.ErrInvalidExtension, ext))
+	}
+	res, err := s.db.Exec(s.queries["move"], dst, time.Now().UnixNano(), src, dst)
+	if err != nil {
+		return s.error("move", dst, err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return s.error("move", dst, err)
+	} else if n > 0 {
+		return nil
+	}
+	if ok, err := s.Exists(src); err != nil {
+		return err
+	} else if !ok {
+		return s.error("move", src, storage.ErrNotFound)
+	}
+	return s.error("move", dst, storage.ErrAlreadyExists)
+}
+
+func (s *Storage) copy(op, src, dst string) error {
+	src, dst = clean(src), clean(dst)
+	if ext := filepath.Ext(dst); !s.Accepts(ext) {
+		return s.error(op, dst, fmt.Errorf("%w %s", storage.ErrInvalidExtension, ext))
+	}
+	ctx := context.Background()
+	id, err := newID()
+	if err != nil {
+		return s.error(op, dst, err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return s.error(op, dst, err)
+	}
+	defer tx.Rollback()
+	var srcID string
+	var chunkSize int
+	fi, err := scanInfo(tx.QueryRowContext(ctx, s.queries["get"], src), &srcID, &chunkSize)
+	if err == sql.ErrNoRows {
+		return s.error(op, src, storage.ErrNotFound)
+	}
+	if err != nil {
+		return s.error(op, src, err)
+	}
+	meta, err := encodeMetadata(fi.Metadata)
+	if err != nil {
+		return s.error(op, dst, err)
+	}
+	if _, err := tx.ExecContext(ctx, s.queries["copy chunks"], id, srcID); err != nil {
+		return s.error(op, dst, err)
+	}
+	res, err := tx.ExecContext(ctx, s.queries["create"], id, chunkSize, dst, fi.Size, time.Now().UnixNano(),
+		fi.ContentType, fi.CacheControl, fi.ContentDisposition, fi.ContentEncoding, fi.ETag, meta)
+	if err != nil {
+		return s.error(op, dst, err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return s.error(op, dst, err)
+	} else if n == 0 {
+		return s.error(op, dst, storage.ErrAlreadyExists)
+	}
+	if err := tx.Commit(); err != nil {
+		return s.error(op, dst, err)
+	}
+	return nil
+}
+
+// List returns a page of the files under prefix. Every row under prefix
+// is read to group the files by delimiter, so large prefixes are better
+// walked.
+func (s *Storage) List(prefix string, opts *storage.ListOptions) (*storage.ListPage, error) {
+	return util.ListFromWalk(s, prefix, opts)
+}
+
+// Walk calls fn for every file under prefix, ordered by path. The files are
+// read in batches, and fn is only called between queries, so it may use the
+// driver.
+func (s *Storage) Walk(prefix string, fn storage.WalkFunc) error {
+	last := ""
+	for {
+		files, err := s.walkBatch(prefix, last)
+		if err != nil {
+			return s.error("walk", prefix, err)
+		}
+		for _, fi := range files {
+			if err := fn(fi); err != nil {
+				return err
+			}
+		}
+		if len(files) < walkBatch {
+			return nil
+		}
+		last = files[len(files)-1].Path
+	}
+}
+
+// walkBatch returns the files under prefix whose path sorts after last.
+func (s *Storage) walkBatch(prefix, last string) ([]*storage.FileInfo, error) {
+	rows, err := s.db.Query(s.queries["walk"], last, utf8.RuneCountInString(prefix), prefix, walkBatch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var files []*storage.FileInfo
+	for rows.Next() {
+		fi, err := scanInfo(rows)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, fi)
+	}
+	return files, rows.Err()
+}
+
+// EmptyContainer removes every file.
+func (s *Storage) EmptyContainer() error {
+	return s.exec("empty", "empty files", "empty chunks")
+}
+
+// DeleteContainer drops the tables.
+func (s *Storage) DeleteContainer() error {
+	return s.exec("delete", "drop files", "drop chunks")
+}
+
+// exec runs the named queries in a transaction.
+func (s *Storage) exec(op string, queries ...string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return s.error(op, "", err)
+	}
+	defer tx.Rollback()
+	for _, name := range queries {
+		if _, err := tx.Exec(s.queries[name]); err != nil {
+			return s.error(op, "", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return s.error(op, "", err)
+	}
+	return nil
+}
@@ -0,0 +1,601 @@
+// Package webdav implements a storage.Driver for a collection on a WebDAV
+// server, such as Nextcloud, ownCloud or Apache mod_dav.
+package webdav
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/djangulo/go-storage"
+	"github.com/djangulo/go-storage/internal/util"
+)
+
+// Storage is a storage.Driver for a WebDAV collection.
+type Storage struct {
+	config *Config
+	client *http.Client
+	// dirs holds the paths of the collections known to exist, so that
+	// AddFile only sends MKCOL for new ones.
+	dirs sync.Map
+}
+
+type Config struct {
+	User string
+	// Host of the server, as host[:port].
+	Host string
+	// Prefix is the path of the collection the files are stored in.
+	Prefix     string
+	DisableSSL bool
+	password   string
+	accept     map[string]struct{}
+}
+
+// tempPattern names the temporary files of in-progress uploads, which Walk
+// and List skip.
+const tempPattern = ".go-storage-*.tmp"
+
+// compile-time checks of the optional interfaces Storage implements.
+var (
+	_ storage.DangerDriver  = (*Storage)(nil)
+	_ storage.ContextDriver = (*Storage)(nil)
+	_ storage.StatDriver    = (*Storage)(nil)
+	_ storage.CopyDriver    = (*Storage)(nil)
+	_ storage.ListDriver    = (*Storage)(nil)
+	_ storage.WalkDriver    = (*Storage)(nil)
+	_ storage.RangeDriver   = (*Storage)(nil)
+)
+
+func init() {
+	storage.Register("webdav", &Storage{})
+}
+
+// Path returns the URL of the collection.
+func (s *Storage) Path() string {
+	return s.url(s.config.Prefix)
+}
+
+// url returns the URL of the remote path p.
+func (s *Storage) url(p string) string {
+	u := url.URL{Scheme: "https", Host: s.config.Host, Path: p}
+	if s.config.DisableSSL {
+		u.Scheme = "http"
+	}
+	return u.String()
+}
+
+func (s *Storage) Accepts(ext string) (accepts bool) {
+	_, accepts = s.config.accept[ext]
+	return
+}
+
+func (s *Storage) NormalizePath(entries ...string) string {
+	return util.JoinURL(s.Path(), entries...)
+}
+
+var ErrURLParse = errors.New("error parsing url")
+
+func parseURL(urlString string) (*Config, error) {
+	u, err := url.Parse(urlString)
+	if err != nil {
+		// *url.Error would print the url, password included
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrURLParse, err)
+	}
+	if u.Scheme != "webdav" || u.Host == "" {
+		return nil, fmt.Errorf(
+			"%w: %s does not match \"webdav://[user:password@]host[:port]/path\" format",
+			ErrURLParse,
+			util.RedactURL(urlString),
+		)
+	}
+	c := &Config{
+		Host:   u.Host,
+		Prefix: "/" + strings.Trim(u.Path, "/"),
+	}
+	if u.User != nil {
+		c.User = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+
+	q := u.Query()
+	if c.DisableSSL, err = util.ParseBoolQuery(q, "disable-ssl", false); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrURLParse, err)
+	}
+	c.accept = util.ParseCommaSeparatedQuery(q, "accept", ".jpeg", ".jpg", ".png", ".svg")
+	return c, nil
+}
+
+// Open creates a *Storage. The urlString should be in the form
+// webdav://[user:password@]host[:port]/path?disable-ssl=&accept=
+// The user and password, if any, are sent through basic authentication.
+// The collection on path is created if missing. For Nextcloud and ownCloud,
+// the path starts with /remote.php/dav/files/<user>/.
+// The URL parameters accepted are as follows:
+//   - disable-ssl: connect through plain HTTP if true. Default false.
+//   - accept: comma-separated list of file extensions to accept. Could be
+//     repeated. e.g. url://host/path?accept=.jpeg,.svg&accept=.png would
+//     accept .jpeg, .svg and .png files. Default .jpeg,.jpg,.png,.svg
+func (s *Storage) Open(urlString string) (storage.Driver, error) {
+	return s.OpenContext(context.Background(), urlString)
+}
+
+// OpenContext is the context-aware version of Open. ctx governs the
+// creation of the collection.
+func (s *Storage) OpenContext(ctx context.Context, urlString string) (storage.Driver, error) {
+	var err error
+
+	ns := &Storage{client: &http.Client{}}
+
+	ns.config, err = parseURL(urlString)
+	if err != nil {
+		return nil, s.error("open", "", err)
+	}
+	if err := ns.mkcol(ctx, ns.config.Prefix); err != nil {
+		return nil, s.error("open", "", err)
+	}
+
+	return ns, nil
+}
+
+func (s *Storage) error(op, p string, err error) error {
+	return &storage.Error{Op: op, Driver: "webdav", Path: p, Err: err}
+}
+
+// Close noop
+func (s *Storage) Close() error {
+	return nil
+}
+
+// do sends a request for the remote path p, a collection if it ends in
+// "/", and returns the response if its status is one of ok.
+func (s *Storage) do(ctx context.Context, method, p string, body io.Reader, header http.Header, ok ...int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.url(p), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if s.config.User != "" {
+		req.SetBasicAuth(s.config.User, s.config.password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return util.CheckStatus(resp, ok...)
+}
+
+// remote returns the path of p on the server.
+func (s *Storage) remote(p string) string {
+	return path.Join(s.config.Prefix, p)
+}
+
+// mkcol creates the collection on the remote path dir, and its missing
+// parents.
+func (s *Storage) mkcol(ctx context.Context, dir string) error {
+	if dir == "/" || dir == "." {
+		return nil
+	}
+	if _, ok := s.dirs.Load(dir); ok {
+		return nil
+	}
+	resp, err := s.do(ctx, "MKCOL", collection(dir), nil, nil, http.StatusCreated)
+	switch util.Status(err) {
+	case http.StatusConflict:
+		// the parent is missing
+		if err := s.mkcol(ctx, path.Dir(dir)); err != nil {
+			return err
+		}
+		resp, err = s.do(ctx, "MKCOL", collection(dir), nil, nil, http.StatusCreated)
+	case http.StatusMethodNotAllowed:
+		// it exists
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	if resp != nil {
+		resp.Body.Close()
+	}
+	s.dirs.Store(dir, struct{}{})
+	return nil
+}
+
+// AddFile saves the contents of r to a temporary file, which is moved to
+// path once complete, so readers never see a partial file. The move fails
+// atomically if path exists. Missing collections are created.
+func (s *Storage) AddFile(r io.Reader, p string) (string, error) {
+	return s.AddFileContext(context.Background(), r, p)
+}
+
+// AddFileContext is the context-aware version of AddFile. Cancelling ctx
+// aborts the upload.
+func (s *Storage) AddFileContext(ctx context.Context, r io.Reader, p string) (string, error) {
+	if ext := filepath.Ext(p); !s.Accepts(ext) {
+		return "", s.error("add", p, fmt.Errorf("%w %s", storage.ErrInvalidExtension, ext))
+	}
+	remote := s.remote(p)
+	if err := s.mkcol(ctx, path.Dir(remote)); err != nil {
+		return "", s.error("add", p, err)
+	}
+	// fail early rather than after the upload
+	if _, err := s.stat(ctx, remote); err == nil {
+		return "", s.error("add", p, storage.ErrAlreadyExists)
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return "", s.error("add", p, err)
+	}
+
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", s.error("add", p, err)
+	}
+	tmp := path.Join(path.Dir(remote), strings.Replace(tempPattern, "*", hex.EncodeToString(b[:]), 1))
+	header := http.Header{"Content-Type": {storage.ResolveContentType(p)}}
+	resp, err := s.do(ctx, http.MethodPut, tmp, util.ContextReader(ctx, r), header, http.StatusCreated, http.StatusNoContent, http.StatusOK)
+	if err == nil {
+		resp.Body.Close()
+		err = s.transfer(ctx, "MOVE", tmp, remote)
+	}
+	if err != nil {
+		// some servers keep what they received of an aborted upload
+		if resp, derr := s.do(context.Background(), http.MethodDelete, tmp, nil, nil, http.StatusNoContent, http.StatusOK); derr == nil {
+			resp.Body.Close()
+		}
+		return "", s.error("add", p, err)
+	}
+	return s.NormalizePath(p), nil
+}
+
+// Copy copies the file on src to dst, on the server.
+func (s *Storage) Copy(src, dst string) error {
+	return s.copy("copy", "COPY", src, dst)
+}
+
+// Move moves the file on src to dst, on the server.
+func (s *Storage) Move(src, dst string) error {
+	return s.copy("move", "MOVE", src, dst)
+}
+
+func (s *Storage) copy(op, method, src, dst string) error {
+	if ext := filepath.Ext(dst); !s.Accepts(ext) {
+		return s.error(op, dst, fmt.Errorf("%w %s", storage.ErrInvalidExtension, ext))
+	}
+	ctx := context.Background()
+	if err := s.mkcol(ctx, path.Dir(s.remote(dst))); err != nil {
+		return s.error(op, dst, err)
+	}
+	err := s.transfer(ctx, method, s.remote(src), s.remote(dst))
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return s.error(op, src, err)
+	case err != nil:
+		return s.error(op, dst, err)
+	}
+	return nil
+}
+
+// transfer sends a COPY or MOVE request from the remote path src to dst,
+// which fails if dst exists.
+func (s *Storage) transfer(ctx context.Context, method, src, dst string) error {
+	header := http.Header{
+		"Destination": {s.url(dst)},
+		"Overwrite":   {"F"},
+	}
+	resp, err := s.do(ctx, method, src, nil, header, http.StatusCreated, http.StatusNoContent)
+	switch util.Status(err) {
+	case http.StatusNotFound:
+		return storage.ErrNotFound
+	case http.StatusPreconditionFailed:
+		return storage.ErrAlreadyExists
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *Storage) RemoveFile(p string) error {
+	return s.RemoveFileContext(context.Background(), p)
+}
+
+// RemoveFileContext is the context-aware version of RemoveFile.
+func (s *Storage) RemoveFileContext(ctx context.Context, p string) error {
+	resp, err := s.do(ctx, http.MethodDelete, s.remote(p), nil, nil, http.StatusNoContent, http.StatusOK)
+	if util.Status(err) == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return s.error("remove", p, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *Storage) GetFile(p string) (io.ReadCloser, error) {
+	return s.GetFileContext(context.Background(), p)
+}
+
+// GetFileContext is the context-aware version of GetFile. ctx also governs
+// reads from the returned body.
+func (s *Storage) GetFileContext(ctx context.Context, p string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.remote(p), nil, nil, http.StatusOK)
+	if util.Status(err) == http.StatusNotFound {
+		return nil, s.error("get", p, storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, s.error("get", p, err)
+	}
+	return util.ContextReadCloser(ctx, resp.Body), nil
+}
+
+// GetFileRange returns length bytes of the file on path starting at offset,
+// or the rest of the file if length is negative, through a Range request.
+// Servers that ignore the range send the whole file, which is skipped
+// through.
+func (s *Storage) GetFileRange(p string, offset, length int64) (io.ReadCloser, error) {
+	ctx := context.Background()
+	if length == 0 {
+		if _, err := s.Stat(p); err != nil {
+			var se *storage.Error
+			if errors.As(err, &se) {
+				se.Op = "get"
+			}
+			return nil, err
+		}
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	rc, err := util.GetRange(func(header http.Header) (*http.Response, error) {
+		return s.do(ctx, http.MethodGet, s.remote(p), nil, header, http.StatusOK, http.StatusPartialContent)
+	}, offset, length)
+	if util.Status(err) == http.StatusNotFound {
+		return nil, s.error("get", p, storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, s.error("get", p, err)
+	}
+	return rc, nil
+}
+
+// propfind is the body of the PROPFIND requests, asking for the properties
+// FileInfo is made of.
+const propfind = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop>
+<d:resourcetype/><d:getcontentlength/><d:getlastmodified/><d:getetag/>
+</d:prop></d:propfind>`
+
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"DAV: href"`
+		Propstat []struct {
+			Prop   prop   `xml:"DAV: prop"`
+			Status string `xml:"DAV: status"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+type prop struct {
+	ResourceType struct {
+		Collection *struct{} `xml:"DAV: collection"`
+	} `xml:"DAV: resourcetype"`
+	ContentLength int64  `xml:"DAV: getcontentlength"`
+	LastModified  string `xml:"DAV: getlastmodified"`
+	ETag          string `xml:"DAV: getetag"`
+}
+
+// resource is an entry of a PROPFIND response.
+type resource struct {
+	// path on the server, without trailing slash
+	path string
+	dir  bool
+	info *storage.FileInfo
+}
+
+// collection returns the remote path p with a trailing slash, as
+// collections are addressed.
+func collection(p string) string {
+	return strings.TrimSuffix(p, "/") + "/"
+}
+
+// forgetDirs empties the cache of existing collections.
+func (s *Storage) forgetDirs() {
+	s.dirs.Range(func(k, _ interface{}) bool {
+		s.dirs.Delete(k)
+		return true
+	})
+}
+
+// props sends a PROPFIND request for the remote path p, with depth 0 or 1.
+func (s *Storage) props(ctx context.Context, p string, depth int) ([]resource, error) {
+	header := http.Header{
+		"Content-Type": {"application/xml; charset=utf-8"},
+		"Depth":        {strconv.Itoa(depth)},
+	}
+	resp, err := s.do(ctx, "PROPFIND", p, strings.NewReader(propfind), header, http.StatusMultiStatus)
+	if util.Status(err) == http.StatusNotFound {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("PROPFIND: %w", err)
+	}
+
+	resources := make([]resource, 0, len(ms.Responses))
+	for _, r := range ms.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			return nil, fmt.Errorf("PROPFIND: %w", err)
+		}
+		res := resource{path: path.Clean("/" + href.Path), info: &storage.FileInfo{}}
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			res.dir = res.dir || ps.Prop.ResourceType.Collection != nil
+			if ps.Prop.ContentLength != 0 {
+				res.info.Size = ps.Prop.ContentLength
+			}
+			if t, err := http.ParseTime(ps.Prop.LastModified); err == nil {
+				res.info.ModTime = t
+			}
+			if ps.Prop.ETag != "" {
+				res.info.ETag = strings.Trim(strings.TrimPrefix(ps.Prop.ETag, "W/"), `"`)
+			}
+		}
+		resources = append(resources, res)
+	}
+	return resources, nil
+}
+
+// stat returns the FileInfo of the file on the remote path p, without Path.
+func (s *Storage) stat(ctx context.Context, p string) (*storage.FileInfo, error) {
+	resources, err := s.props(ctx, p, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(resources) == 0 || resources[0].dir {
+		return nil, storage.ErrNotFound
+	}
+	return resources[0].info, nil
+}
+
+// Stat returns the FileInfo of the file on path, through PROPFIND. The
+// content type is derived from its extension, as not every server stores
+// the one it was uploaded with.
+func (s *Storage) Stat(p string) (*storage.FileInfo, error) {
+	fi, err := s.stat(context.Background(), s.remote(p))
+	if err != nil {
+		return nil, s.error("stat", p, err)
+	}
+	fi.Path = p
+	fi.ContentType = storage.ResolveContentType(p)
+	return fi, nil
+}
+
+// Exists reports whether there is a file on path.
+func (s *Storage) Exists(p string) (bool, error) {
+	_, err := s.Stat(p)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// List returns a page of the files under prefix, out of a PROPFIND of
+// every collection under it.
+func (s *Storage) List(prefix string, opts *storage.ListOptions) (*storage.ListPage, error) {
+	return util.ListFromWalk(s, prefix, opts)
+}
+
+// Walk calls fn for every file under prefix, through a PROPFIND request of
+// depth 1 per collection, as most servers refuse infinite depth.
+func (s *Storage) Walk(prefix string, fn storage.WalkFunc) error {
+	// only descend from the deepest collection the prefix names
+	start := s.config.Prefix
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		start = s.remote(prefix[:i])
+	}
+	err := s.walk(context.Background(), start, prefix, fn)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if ferr, ok := err.(*util.FnError); ok {
+		return ferr.Err
+	}
+	if err != nil {
+		return s.error("walk", prefix, err)
+	}
+	return nil
+}
+
+func (s *Storage) walk(ctx context.Context, dir, prefix string, fn storage.WalkFunc) error {
+	resources, err := s.props(ctx, collection(dir), 1)
+	if err != nil {
+		return err
+	}
+	root := collection(s.config.Prefix)
+	for _, r := range resources {
+		if r.path == dir {
+			continue
+		}
+		if r.dir {
+			if err := s.walk(ctx, r.path, prefix, fn); err != nil && !errors.Is(err, storage.ErrNotFound) {
+				return err
+			}
+			continue
+		}
+		if ok, _ := path.Match(tempPattern, path.Base(r.path)); ok {
+			continue
+		}
+		rel := strings.TrimPrefix(r.path, root)
+		if !strings.HasPrefix(rel, prefix) {
+			continue
+		}
+		r.info.Path = rel
+		r.info.ContentType = storage.ResolveContentType(rel)
+		if err := fn(r.info); err != nil {
+			return &util.FnError{Err: err}
+		}
+	}
+	return nil
+}
+
+// EmptyContainer removes every file and collection in the collection.
+func (s *Storage) EmptyContainer() error {
+	ctx := context.Background()
+	resources, err := s.props(ctx, collection(s.config.Prefix), 1)
+	if err != nil {
+		return s.error("empty", "", err)
+	}
+	for _, r := range resources {
+		if r.path == s.config.Prefix {
+			continue
+		}
+		p := r.path
+		if r.dir {
+			p = collection(p)
+		}
+		resp, err := s.do(ctx, http.MethodDelete, p, nil, nil, http.StatusNoContent, http.StatusOK)
+		if err != nil && util.Status(err) != http.StatusNotFound {
+			return s.error("empty", "", err)
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+	}
+	s.forgetDirs()
+	return nil
+}
+
+// DeleteContainer deletes the collection, along with its contents.
+func (s *Storage) DeleteContainer() error {
+	resp, err := s.do(context.Background(), http.MethodDelete, collection(s.config.Prefix), nil, nil, http.StatusNoContent, http.StatusOK)
+	if err != nil && util.Status(err) != http.StatusNotFound {
+		return s.error("delete", "", err)
+	}
+	if resp != nil {
+		resp.Body.Close()
+	}
+	s.forgetDirs()
+	return nil
+}
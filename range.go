@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ReadSeekCloser groups the Read, Seek and Close methods. It is what
+// http.ServeContent needs, plus Close.
+type ReadSeekCloser interface {
+	io.Reader
+	io.Seeker
+	io.Closer
+}
+
+// RangeDriver interface to be implemented by storage drivers that can read
+// part of a file without reading it whole.
+type RangeDriver interface {
+	Driver
+	// GetFileRange returns an io.ReadCloser with length bytes of the file,
+	// starting at offset. A negative length reads to the end of the file.
+	// Fewer than length bytes are returned if the file ends first, and none
+	// if offset is past the end. The error wraps ErrNotFound if there is no
+	// file on path.
+	GetFileRange(path string, offset, length int64) (io.ReadCloser, error)
+}
+
+// SeekDriver interface to be implemented by storage drivers that can open
+// files for random access natively, such as fs.
+type SeekDriver interface {
+	Driver
+	// GetFileSeeker returns a ReadSeekCloser with the contents of the file.
+	// The error wraps ErrNotFound if there is no file on path.
+	GetFileSeeker(path string) (ReadSeekCloser, error)
+}
+
+// GetFileSeeker returns a ReadSeekCloser with the contents of the file on
+// path. It uses the driver's GetFileSeeker method if it implements
+// SeekDriver. Otherwise, if it implements RangeDriver and StatDriver, the
+// returned reader issues a GetFileRange call from the current offset on the
+// first Read after each Seek, so seeking is cheap and only the data read is
+// transferred. It returns ErrNotSupported for any other driver.
+func GetFileSeeker(d Driver, path string) (ReadSeekCloser, error) {
+	if sd, ok := d.(SeekDriver); ok {
+		return sd.GetFileSeeker(path)
+	}
+	rd, ok := d.(RangeDriver)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T cannot read ranges", ErrNotSupported, d)
+	}
+	sd, ok := d.(StatDriver)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T cannot stat files", ErrNotSupported, d)
+	}
+	fi, err := sd.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &rangeReader{d: rd, path: path, size: fi.Size}, nil
+}
+
+// rangeReader implements ReadSeekCloser on top of a RangeDriver.
+type rangeReader struct {
+	d      RangeDriver
+	path   string
+	size   int64
+	offset int64
+	rc     io.ReadCloser
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.rc == nil {
+		rc, err := r.d.GetFileRange(r.path, r.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.rc = rc
+	}
+	n, err := r.rc.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *rangeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("storage: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("storage: negative position")
+	}
+	if offset != r.offset && r.rc != nil {
+		r.rc.Close()
+		r.rc = nil
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *rangeReader) Close() error {
+	if r.rc == nil {
+		return nil
+	}
+	err := r.rc.Close()
+	r.rc = nil
+	return err
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rangeDriver is an in-memory RangeDriver and StatDriver, which exercises
+// the rangeReader fallback of GetFileSeeker.
+type rangeDriver struct {
+	files map[string]string
+	gets  int
+}
+
+func (d *rangeDriver) Open(string) (Driver, error)            { return d, nil }
+func (d *rangeDriver) Close() error                           { return nil }
+func (d *rangeDriver) Accepts(string) bool                    { return true }
+func (d *rangeDriver) Path() string                           { return "" }
+func (d *rangeDriver) NormalizePath(entries ...string) string { return strings.Join(entries, "/") }
+func (d *rangeDriver) AddFile(io.Reader, string) (string, error) {
+	return "", ErrNotSupported
+}
+func (d *rangeDriver) RemoveFile(string) error { return ErrNotSupported }
+func (d *rangeDriver) GetFile(path string) (io.ReadCloser, error) {
+	return d.GetFileRange(path, 0, -1)
+}
+
+func (d *rangeDriver) GetFileRange(path string, offset, length int64) (io.ReadCloser, error) {
+	s, ok := d.files[path]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	d.gets++
+	if offset > int64(len(s)) {
+		offset = int64(len(s))
+	}
+	s = s[offset:]
+	if length >= 0 && length < int64(len(s)) {
+		s = s[:length]
+	}
+	return ioutil.NopCloser(strings.NewReader(s)), nil
+}
+
+func (d *rangeDriver) Stat(path string) (*FileInfo, error) {
+	s, ok := d.files[path]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &FileInfo{Path: path, Size: int64(len(s))}, nil
+}
+
+func (d *rangeDriver) Exists(path string) (bool, error) {
+	_, ok := d.files[path]
+	return ok, nil
+}
+
+func TestGetFileSeeker(t *testing.T) {
+	d := &rangeDriver{files: map[string]string{"a.txt": "0123456789"}}
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := GetFileSeeker(d, "b.txt")
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected %v got %v", ErrNotFound, err)
+		}
+	})
+	t.Run("seeks lazily", func(t *testing.T) {
+		d.gets = 0
+		rs, err := GetFileSeeker(d, "a.txt")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer rs.Close()
+		rs.Seek(0, io.SeekEnd)
+		rs.Seek(-3, io.SeekCurrent)
+		b, err := ioutil.ReadAll(rs)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(b) != "789" {
+			t.Errorf("expected %q got %q", "789", string(b))
+		}
+		if d.gets != 1 {
+			t.Errorf("expected 1 ranged get got %d", d.gets)
+		}
+		if _, err := rs.Seek(-1, io.SeekStart); err == nil {
+			t.Error("expected an error seeking to a negative position")
+		}
+	})
+	t.Run("serve content", func(t *testing.T) {
+		rs, err := GetFileSeeker(d, "a.txt")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer rs.Close()
+		req := httptest.NewRequest("GET", "/a.txt", nil)
+		req.Header.Set("Range", "bytes=2-4")
+		rec := httptest.NewRecorder()
+		http.ServeContent(rec, req, "a.txt", time.Time{}, rs)
+		if rec.Code != http.StatusPartialContent {
+			t.Errorf("expected status %d got %d", http.StatusPartialContent, rec.Code)
+		}
+		if got := rec.Body.String(); got != "234" {
+			t.Errorf("expected %q got %q", "234", got)
+		}
+	})
+	t.Run("not supported", func(t *testing.T) {
+		_, err := GetFileSeeker(struct{ Driver }{d}, "a.txt")
+		if !errors.Is(err, ErrNotSupported) {
+			t.Errorf("expected %v got %v", ErrNotSupported, err)
+		}
+	})
+}
@@ -1,16 +1,32 @@
 package testing
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/djangulo/go-storage"
 )
 
 func Test(t *testing.T, d storage.Driver) {
 	t.Run("TestAPI", func(t *testing.T) { TestAPI(t, d) })
+	t.Run("TestContext", func(t *testing.T) { TestContext(t, d) })
+	t.Run("TestStat", func(t *testing.T) { TestStat(t, d) })
+	t.Run("TestList", func(t *testing.T) { TestList(t, d) })
+	t.Run("TestNotFound", func(t *testing.T) { TestNotFound(t, d) })
+	t.Run("TestWriteModes", func(t *testing.T) { TestWriteModes(t, d) })
+	t.Run("TestPutOptions", func(t *testing.T) { TestPutOptions(t, d) })
+	t.Run("TestCopy", func(t *testing.T) { TestCopy(t, d) })
+	t.Run("TestRange", func(t *testing.T) { TestRange(t, d) })
+	t.Run("TestWriter", func(t *testing.T) { TestWriter(t, d) })
 }
 
 // TestAPI adds a file, gets it, removes it.
@@ -47,3 +63,708 @@ func TestAPI(t *testing.T, d storage.Driver) {
 		})
 	}
 }
+
+// TestReadOnly checks a read-only driver holding files, a map of paths to
+// contents: files can be read, stat'ed and ranged over where supported, and
+// writes fail with storage.ErrReadOnly.
+func TestReadOnly(t *testing.T, d storage.Driver, files map[string]string) {
+	for path, want := range files {
+		t.Run(fmt.Sprintf("get %q", path), func(t *testing.T) {
+			assertContents(t, d, path, want)
+		})
+		t.Run(fmt.Sprintf("stat %q", path), func(t *testing.T) {
+			sd, ok := d.(storage.StatDriver)
+			if !ok {
+				t.Skipf("%T does not implement storage.StatDriver", d)
+			}
+			fi, err := sd.Stat(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if fi.Path != path || fi.Size != int64(len(want)) {
+				t.Errorf("expected %q of %d bytes got %q of %d bytes", path, len(want), fi.Path, fi.Size)
+			}
+		})
+		t.Run(fmt.Sprintf("range %q", path), func(t *testing.T) {
+			rd, ok := d.(storage.RangeDriver)
+			if !ok {
+				t.Skipf("%T does not implement storage.RangeDriver", d)
+			}
+			if len(want) < 2 {
+				t.Skip("file too short")
+			}
+			rc, err := rd.GetFileRange(path, 1, 1)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer rc.Close()
+			b, err := ioutil.ReadAll(rc)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(b) != want[1:2] {
+				t.Errorf("expected %q got %q", want[1:2], string(b))
+			}
+			// a length past the end of the file reads to its end
+			rc, err = rd.GetFileRange(path, 1, math.MaxInt64)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer rc.Close()
+			if b, err = ioutil.ReadAll(rc); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(b) != want[1:] {
+				t.Errorf("expected %q got %q", want[1:], string(b))
+			}
+		})
+		t.Run(fmt.Sprintf("add %q", path), func(t *testing.T) {
+			_, err := d.AddFile(strings.NewReader("hello world"), path)
+			if !errors.Is(err, storage.ErrReadOnly) {
+				t.Errorf("expected %v got %v", storage.ErrReadOnly, err)
+			}
+		})
+		t.Run(fmt.Sprintf("remove %q", path), func(t *testing.T) {
+			if err := d.RemoveFile(path); !errors.Is(err, storage.ErrReadOnly) {
+				t.Errorf("expected %v got %v", storage.ErrReadOnly, err)
+			}
+			assertContents(t, d, path, want)
+		})
+	}
+	t.Run("not found", func(t *testing.T) {
+		_, err := d.GetFile("tests/test-not-found.txt")
+		if !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("expected %v got %v", storage.ErrNotFound, err)
+		}
+		ok, err := storage.Exists(d, "tests/test-not-found.txt")
+		if ok || err != nil {
+			t.Errorf("expected false, <nil> got %v, %v", ok, err)
+		}
+	})
+}
+
+// TestContext checks that a ContextDriver honours cancelled contexts, and
+// that a cancelled upload leaves nothing behind.
+func TestContext(t *testing.T, d storage.Driver) {
+	cd, ok := d.(storage.ContextDriver)
+	if !ok {
+		t.Skipf("%T does not implement storage.ContextDriver", d)
+	}
+	path := "tests/test-context.txt"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	t.Run("add with cancelled context", func(t *testing.T) {
+		_, err := cd.AddFileContext(ctx, strings.NewReader("hello world"), path)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected %v got %v", context.Canceled, err)
+		}
+	})
+	t.Run("file was not added", func(t *testing.T) {
+		_, err := cd.AddFileContext(context.Background(), strings.NewReader("hello world"), path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := cd.RemoveFileContext(context.Background(), path); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	t.Run("get with cancelled context", func(t *testing.T) {
+		if _, err := cd.GetFileContext(ctx, path); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected %v got %v", context.Canceled, err)
+		}
+	})
+	t.Run("cancel during add", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		r := &stallReader{data: []byte("hello world"), cancel: cancel, stall: make(chan struct{})}
+		defer close(r.stall)
+		done := make(chan error, 1)
+		go func() {
+			_, err := cd.AddFileContext(ctx, r, path)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("expected %v got %v", context.Canceled, err)
+			}
+		case <-time.After(cancelTimeout):
+			t.Fatalf("AddFileContext still running %v after the context was cancelled", cancelTimeout)
+		}
+		if rc, err := cd.GetFileContext(context.Background(), path); err == nil {
+			rc.Close()
+			cd.RemoveFileContext(context.Background(), path)
+			t.Errorf("expected %q not to be added", path)
+		}
+	})
+	t.Run("cancel during get", func(t *testing.T) {
+		if _, err := cd.AddFileContext(context.Background(), strings.NewReader("hello world"), path); err != nil {
+			t.Fatal(err)
+		}
+		defer cd.RemoveFileContext(context.Background(), path)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		rc, err := cd.GetFileContext(ctx, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if _, err := io.ReadFull(rc, make([]byte, 5)); err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+		done := make(chan error, 1)
+		go func() {
+			_, err := ioutil.ReadAll(rc)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("expected %v got %v", context.Canceled, err)
+			}
+		case <-time.After(cancelTimeout):
+			t.Fatalf("read still running %v after the context was cancelled", cancelTimeout)
+		}
+	})
+}
+
+// cancelTimeout is how long a call may take to return once its context is
+// cancelled.
+const cancelTimeout = 10 * time.Second
+
+// stallReader returns data on its first Read. The next Read cancels the
+// context of the call, and blocks until stall is closed, as a client that
+// stops sending would.
+type stallReader struct {
+	data   []byte
+	cancel context.CancelFunc
+	stall  chan struct{}
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	if len(r.data) > 0 {
+		n := copy(p, r.data)
+		r.data = r.data[n:]
+		return n, nil
+	}
+	r.cancel()
+	<-r.stall
+	return 0, io.ErrUnexpectedEOF
+}
+
+// TestStat checks the FileInfo reported by a StatDriver.
+func TestStat(t *testing.T, d storage.Driver) {
+	sd, ok := d.(storage.StatDriver)
+	if !ok {
+		t.Skipf("%T does not implement storage.StatDriver", d)
+	}
+	path := "tests/test-stat.txt"
+	content := "hello world"
+	if _, err := d.AddFile(strings.NewReader(content), path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer d.RemoveFile(path)
+
+	fi, err := sd.Stat(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fi.Path != path {
+		t.Errorf("expected path %q got %q", path, fi.Path)
+	}
+	if fi.Size != int64(len(content)) {
+		t.Errorf("expected size %d got %d", len(content), fi.Size)
+	}
+	if want := storage.ResolveContentType(path); fi.ContentType != want {
+		t.Errorf("expected content type %q got %q", want, fi.ContentType)
+	}
+	if fi.ModTime.IsZero() {
+		t.Error("expected non-zero ModTime")
+	}
+	if fi.ETag == "" {
+		t.Error("expected non-empty ETag")
+	}
+}
+
+// TestList checks the folder semantics and pagination of a ListDriver, and
+// storage.Walk.
+func TestList(t *testing.T, d storage.Driver) {
+	ld, ok := d.(storage.ListDriver)
+	if !ok {
+		t.Skipf("%T does not implement storage.ListDriver", d)
+	}
+	files := []string{
+		"tests/list/a.txt",
+		"tests/list/b.txt",
+		"tests/list/sub/c.txt",
+	}
+	for _, path := range files {
+		if _, err := d.AddFile(strings.NewReader(path), path); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer d.RemoveFile(path)
+	}
+
+	t.Run("delimiter", func(t *testing.T) {
+		page, err := ld.List("tests/list/", &storage.ListOptions{Delimiter: "/"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got, want := paths(page.Files), files[:2]; !reflect.DeepEqual(got, want) {
+			t.Errorf("expected files %v got %v", want, got)
+		}
+		if want := []string{"tests/list/sub/"}; !reflect.DeepEqual(page.Prefixes, want) {
+			t.Errorf("expected prefixes %v got %v", want, page.Prefixes)
+		}
+		if page.NextToken != "" {
+			t.Errorf("expected no next token got %q", page.NextToken)
+		}
+	})
+	t.Run("pagination", func(t *testing.T) {
+		var (
+			got   = make([]string, 0)
+			opts  = &storage.ListOptions{MaxResults: 1}
+			pages int
+		)
+		for {
+			page, err := ld.List("tests/list/", opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got = append(got, paths(page.Files)...)
+			pages++
+			if page.NextToken == "" || pages > len(files) {
+				break
+			}
+			opts.Token = page.NextToken
+		}
+		if !reflect.DeepEqual(got, files) {
+			t.Errorf("expected %v got %v", files, got)
+		}
+	})
+	t.Run("walk", func(t *testing.T) {
+		got := make([]string, 0)
+		err := storage.Walk(d, "tests/list/", func(fi *storage.FileInfo) error {
+			got = append(got, fi.Path)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, files) {
+			t.Errorf("expected %v got %v", files, got)
+		}
+	})
+	t.Run("missing prefix", func(t *testing.T) {
+		page, err := ld.List("tests/missing/dir/", &storage.ListOptions{Delimiter: "/"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(page.Files) != 0 || len(page.Prefixes) != 0 {
+			t.Errorf("expected an empty page got %v %v", paths(page.Files), page.Prefixes)
+		}
+		err = storage.Walk(d, "tests/missing/", func(fi *storage.FileInfo) error {
+			t.Errorf("unexpected file %q", fi.Path)
+			return nil
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	t.Run("walk skip all", func(t *testing.T) {
+		var n int
+		err := storage.Walk(d, "tests/list/", func(fi *storage.FileInfo) error {
+			n++
+			return storage.SkipAll
+		})
+		if err != nil || n != 1 {
+			t.Errorf("expected 1 call and <nil> got %d and %v", n, err)
+		}
+	})
+}
+
+func paths(files []*storage.FileInfo) []string {
+	ret := make([]string, 0, len(files))
+	for _, fi := range files {
+		ret = append(ret, fi.Path)
+	}
+	return ret
+}
+
+// TestNotFound checks that missing files are reported with
+// storage.ErrNotFound, and storage.Exists.
+func TestNotFound(t *testing.T, d storage.Driver) {
+	path := "tests/test-not-found.txt"
+	t.Run("get", func(t *testing.T) {
+		_, err := d.GetFile(path)
+		if !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("expected %v got %v", storage.ErrNotFound, err)
+		}
+		var serr *storage.Error
+		if !errors.As(err, &serr) {
+			t.Fatalf("expected a *storage.Error got %T", err)
+		}
+		if serr.Op != "get" || serr.Path != path || serr.Driver == "" {
+			t.Errorf("expected Op %q, Path %q and a Driver, got %+v", "get", path, serr)
+		}
+	})
+	t.Run("remove", func(t *testing.T) {
+		if err := d.RemoveFile(path); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	t.Run("stat", func(t *testing.T) {
+		sd, ok := d.(storage.StatDriver)
+		if !ok {
+			t.Skipf("%T does not implement storage.StatDriver", d)
+		}
+		if _, err := sd.Stat(path); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("expected %v got %v", storage.ErrNotFound, err)
+		}
+	})
+	t.Run("exists", func(t *testing.T) {
+		ok, err := storage.Exists(d, path)
+		if ok || err != nil {
+			t.Errorf("expected false, <nil> got %v, %v", ok, err)
+		}
+		if _, err := d.AddFile(strings.NewReader("hello world"), path); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer d.RemoveFile(path)
+		ok, err = storage.Exists(d, path)
+		if !ok || err != nil {
+			t.Errorf("expected true, <nil> got %v, %v", ok, err)
+		}
+	})
+}
+
+// TestWriteModes checks that AddFile refuses to overwrite files, and the
+// write modes of a PutDriver.
+func TestWriteModes(t *testing.T, d storage.Driver) {
+	path := "tests/test-write-modes.txt"
+	if _, err := d.AddFile(strings.NewReader("first"), path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer d.RemoveFile(path)
+
+	t.Run("add existing", func(t *testing.T) {
+		_, err := d.AddFile(strings.NewReader("second"), path)
+		if !errors.Is(err, storage.ErrAlreadyExists) {
+			t.Errorf("expected %v got %v", storage.ErrAlreadyExists, err)
+		}
+		assertContents(t, d, path, "first")
+	})
+
+	pd, ok := d.(storage.PutDriver)
+	if !ok {
+		t.Skipf("%T does not implement storage.PutDriver", d)
+	}
+	t.Run("create only", func(t *testing.T) {
+		_, err := pd.AddFileWithOptions(strings.NewReader("second"), path, &storage.PutOptions{Mode: storage.CreateOnly})
+		if !errors.Is(err, storage.ErrAlreadyExists) {
+			t.Errorf("expected %v got %v", storage.ErrAlreadyExists, err)
+		}
+		assertContents(t, d, path, "first")
+	})
+	t.Run("overwrite", func(t *testing.T) {
+		_, err := pd.AddFileWithOptions(strings.NewReader("second"), path, &storage.PutOptions{Mode: storage.Overwrite})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertContents(t, d, path, "second")
+	})
+	t.Run("if match mismatch", func(t *testing.T) {
+		_, err := pd.AddFileWithOptions(strings.NewReader("third"), path, &storage.PutOptions{Mode: storage.IfMatch("not-the-etag")})
+		if !errors.Is(err, storage.ErrPreconditionFailed) {
+			t.Errorf("expected %v got %v", storage.ErrPreconditionFailed, err)
+		}
+		assertContents(t, d, path, "second")
+	})
+	t.Run("if match missing file", func(t *testing.T) {
+		missing := "tests/test-write-modes-missing.txt"
+		_, err := pd.AddFileWithOptions(strings.NewReader("third"), missing, &storage.PutOptions{Mode: storage.IfMatch("not-the-etag")})
+		if !errors.Is(err, storage.ErrPreconditionFailed) {
+			t.Errorf("expected %v got %v", storage.ErrPreconditionFailed, err)
+		}
+		if ok, _ := storage.Exists(d, missing); ok {
+			t.Errorf("expected %q not to be created", missing)
+			d.RemoveFile(missing)
+		}
+	})
+	t.Run("if match", func(t *testing.T) {
+		sd, ok := d.(storage.StatDriver)
+		if !ok {
+			t.Skipf("%T does not implement storage.StatDriver", d)
+		}
+		fi, err := sd.Stat(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err = pd.AddFileWithOptions(strings.NewReader("third"), path, &storage.PutOptions{Mode: storage.IfMatch(fi.ETag)})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertContents(t, d, path, "third")
+	})
+}
+
+func assertContents(t *testing.T, d storage.Driver, path, want string) {
+	t.Helper()
+	rc, err := d.GetFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(b) != want {
+		t.Errorf("expected contents %q got %q", want, string(b))
+	}
+}
+
+// TestPutOptions checks that the headers and metadata set through
+// storage.PutOptions are read back by Stat, and replaced on overwrite.
+func TestPutOptions(t *testing.T, d storage.Driver) {
+	pd, ok := d.(storage.PutDriver)
+	if !ok {
+		t.Skipf("%T does not implement storage.PutDriver", d)
+	}
+	sd, ok := d.(storage.StatDriver)
+	if !ok {
+		t.Skipf("%T does not implement storage.StatDriver", d)
+	}
+	path := "tests/test-put-options.txt"
+	opts := &storage.PutOptions{
+		ContentType:        "application/x-go-storage",
+		CacheControl:       "max-age=60",
+		ContentDisposition: `attachment; filename="test.txt"`,
+		ContentEncoding:    "identity",
+		Metadata:           map[string]string{"Owner": "bob"},
+	}
+	if _, err := pd.AddFileWithOptions(strings.NewReader("hello world"), path, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer d.RemoveFile(path)
+
+	t.Run("stat", func(t *testing.T) {
+		fi, err := sd.Stat(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got := &storage.PutOptions{
+			ContentType:        fi.ContentType,
+			CacheControl:       fi.CacheControl,
+			ContentDisposition: fi.ContentDisposition,
+			ContentEncoding:    fi.ContentEncoding,
+			Metadata:           fi.Metadata,
+		}
+		want := *opts
+		want.Metadata = map[string]string{"owner": "bob"}
+		if !reflect.DeepEqual(got, &want) {
+			t.Errorf("\nexpected\t%+v\ngot\t\t%+v", &want, got)
+		}
+	})
+	t.Run("overwrite resets", func(t *testing.T) {
+		_, err := pd.AddFileWithOptions(strings.NewReader("hello world"), path, &storage.PutOptions{Mode: storage.Overwrite})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		fi, err := sd.Stat(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if want := storage.ResolveContentType(path); fi.ContentType != want {
+			t.Errorf("expected content type %q got %q", want, fi.ContentType)
+		}
+		if len(fi.Metadata) != 0 || fi.CacheControl != "" {
+			t.Errorf("expected no metadata got %+v", fi)
+		}
+	})
+}
+
+// TestCopy copies and moves files within d, through storage.Copy and
+// storage.Move.
+func TestCopy(t *testing.T, d storage.Driver) {
+	var (
+		src   = "tests/test-copy-src.txt"
+		dst   = "tests/test-copy-dst.txt"
+		moved = "tests/test-copy-moved.txt"
+	)
+	if _, err := d.AddFile(strings.NewReader("hello world"), src); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer d.RemoveFile(src)
+	defer d.RemoveFile(dst)
+	defer d.RemoveFile(moved)
+
+	t.Run("copy", func(t *testing.T) {
+		if err := storage.Copy(d, dst, d, src); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertContents(t, d, src, "hello world")
+		assertContents(t, d, dst, "hello world")
+	})
+	t.Run("copy onto existing", func(t *testing.T) {
+		err := storage.Copy(d, dst, d, src)
+		if !errors.Is(err, storage.ErrAlreadyExists) {
+			t.Errorf("expected %v got %v", storage.ErrAlreadyExists, err)
+		}
+	})
+	t.Run("copy missing", func(t *testing.T) {
+		err := storage.Copy(d, moved, d, "tests/test-copy-missing.txt")
+		if !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("expected %v got %v", storage.ErrNotFound, err)
+		}
+	})
+	t.Run("move", func(t *testing.T) {
+		if err := storage.Move(d, moved, d, dst); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertContents(t, d, moved, "hello world")
+		if ok, err := storage.Exists(d, dst); ok || err != nil {
+			t.Errorf("expected %q to be removed, got %v, %v", dst, ok, err)
+		}
+	})
+}
+
+// TestRange reads parts of a file through storage.RangeDriver, and seeks
+// through the reader returned by storage.GetFileSeeker.
+func TestRange(t *testing.T, d storage.Driver) {
+	rd, ok := d.(storage.RangeDriver)
+	if !ok {
+		t.Skipf("%T does not implement storage.RangeDriver", d)
+	}
+	path := "tests/test-range.txt"
+	content := "0123456789"
+	if _, err := d.AddFile(strings.NewReader(content), path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer d.RemoveFile(path)
+
+	for _, tt := range []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, -1, content},
+		{3, 4, "3456"},
+		{7, -1, "789"},
+		{7, 10, "789"},
+		{3, math.MaxInt64, "3456789"},
+		{4, 0, ""},
+		{20, -1, ""},
+	} {
+		t.Run(fmt.Sprintf("range %d %d", tt.offset, tt.length), func(t *testing.T) {
+			rc, err := rd.GetFileRange(path, tt.offset, tt.length)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer rc.Close()
+			b, err := ioutil.ReadAll(rc)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("expected %q got %q", tt.want, string(b))
+			}
+		})
+	}
+	t.Run("range missing", func(t *testing.T) {
+		_, err := rd.GetFileRange("tests/test-range-missing.txt", 0, -1)
+		if !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("expected %v got %v", storage.ErrNotFound, err)
+		}
+	})
+	t.Run("seeker", func(t *testing.T) {
+		rs, err := storage.GetFileSeeker(d, path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer rs.Close()
+		size, err := rs.Seek(0, io.SeekEnd)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if size != int64(len(content)) {
+			t.Errorf("expected size %d got %d", len(content), size)
+		}
+		if _, err := rs.Seek(-4, io.SeekEnd); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		b := make([]byte, 2)
+		if _, err := io.ReadFull(rs, b); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(b) != "67" {
+			t.Errorf("expected %q got %q", "67", string(b))
+		}
+		if _, err := rs.Seek(1, io.SeekStart); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := io.ReadFull(rs, b); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(b) != "12" {
+			t.Errorf("expected %q got %q", "12", string(b))
+		}
+	})
+}
+
+// TestWriter writes files through storage.OpenWriter, checking that they
+// are only stored on Close.
+func TestWriter(t *testing.T, d storage.Driver) {
+	path := "tests/test-writer.txt"
+	defer d.RemoveFile(path)
+
+	t.Run("close", func(t *testing.T) {
+		w, err := storage.OpenWriter(d, path, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, s := range []string{"hello", " ", "world"} {
+			if _, err := io.WriteString(w, s); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if ok, _ := storage.Exists(d, path); ok {
+			t.Errorf("expected %q not to exist before Close", path)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertContents(t, d, path, "hello world")
+		if _, err := w.Write([]byte("more")); err == nil {
+			t.Error("expected an error writing after Close")
+		}
+	})
+	t.Run("create only", func(t *testing.T) {
+		w, err := storage.OpenWriter(d, path, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		io.WriteString(w, "second")
+		if err := w.Close(); !errors.Is(err, storage.ErrAlreadyExists) {
+			t.Errorf("expected %v got %v", storage.ErrAlreadyExists, err)
+		}
+		assertContents(t, d, path, "hello world")
+	})
+	t.Run("close with error", func(t *testing.T) {
+		aborted := "tests/test-writer-aborted.txt"
+		w, err := storage.OpenWriter(d, aborted, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		io.WriteString(w, "partial")
+		if err := w.CloseWithError(errors.New("encoder failed")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ok, _ := storage.Exists(d, aborted); ok {
+			t.Errorf("expected %q not to be stored", aborted)
+			d.RemoveFile(aborted)
+		}
+	})
+}
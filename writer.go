@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Writer is returned by OpenWriter. The file is only stored once Close
+// returns without error; until then, readers do not see it.
+type Writer interface {
+	io.WriteCloser
+	// CloseWithError aborts the write, so that nothing is stored, and
+	// releases the resources held by the Writer. err is reported by any
+	// concurrent Write.
+	CloseWithError(err error) error
+}
+
+// WriterDriver interface to be implemented by storage drivers that can
+// write files through an io.Writer.
+type WriterDriver interface {
+	Driver
+	// OpenWriter returns a Writer for the file on path. The write mode of
+	// opts is checked on Close. opts may be nil.
+	OpenWriter(path string, opts *PutOptions) (Writer, error)
+}
+
+// ErrWriterClosed is returned when using a Writer after Close or
+// CloseWithError.
+var ErrWriterClosed = errors.New("storage: write to closed writer")
+
+// OpenWriter returns a Writer for the file on path. It uses the driver's
+// OpenWriter method if it implements WriterDriver, and otherwise streams
+// the writes into AddFileWithOptions (or AddFile, if opts is nil) through
+// NewPipeWriter.
+func OpenWriter(d Driver, path string, opts *PutOptions) (Writer, error) {
+	if wd, ok := d.(WriterDriver); ok {
+		return wd.OpenWriter(path, opts)
+	}
+	if opts == nil {
+		return NewPipeWriter(func(r io.Reader) error {
+			_, err := d.AddFile(r, path)
+			return err
+		}), nil
+	}
+	pd, ok := d.(PutDriver)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T does not accept PutOptions", ErrNotSupported, d)
+	}
+	return NewPipeWriter(func(r io.Reader) error {
+		_, err := pd.AddFileWithOptions(r, path, opts)
+		return err
+	}), nil
+}
+
+// NewPipeWriter returns a Writer whose writes are read by upload, which
+// runs on its own goroutine. Close waits for upload to return, and returns
+// its error. CloseWithError makes upload's reads fail, so upload must not
+// store anything when its reader fails. Typically used from driver
+// implementations whose API takes an io.Reader.
+func NewPipeWriter(upload func(r io.Reader) error) Writer {
+	pr, pw := io.Pipe()
+	w := &pipeWriter{pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := upload(pr)
+		// unblock pending writes if upload returned early
+		if err != nil {
+			pr.CloseWithError(err)
+		} else {
+			pr.Close()
+		}
+		w.done <- err
+	}()
+	return w
+}
+
+type pipeWriter struct {
+	pw     *io.PipeWriter
+	done   chan error
+	closed bool
+}
+
+func (w *pipeWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrWriterClosed
+	}
+	return w.pw.Write(p)
+}
+
+func (w *pipeWriter) Close() error {
+	if w.closed {
+		return ErrWriterClosed
+	}
+	w.closed = true
+	w.pw.Close()
+	return <-w.done
+}
+
+func (w *pipeWriter) CloseWithError(err error) error {
+	if w.closed {
+		return ErrWriterClosed
+	}
+	w.closed = true
+	if err == nil {
+		err = ErrWriterClosed
+	}
+	w.pw.CloseWithError(err)
+	<-w.done
+	return nil
+}
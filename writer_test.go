@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestPipeWriter(t *testing.T) {
+	t.Run("close", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewPipeWriter(func(r io.Reader) error {
+			_, err := io.Copy(&buf, r)
+			return err
+		})
+		io.WriteString(w, "hello ")
+		io.WriteString(w, "world")
+		if err := w.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := buf.String(); got != "hello world" {
+			t.Errorf("expected %q got %q", "hello world", got)
+		}
+		if err := w.Close(); !errors.Is(err, ErrWriterClosed) {
+			t.Errorf("expected %v got %v", ErrWriterClosed, err)
+		}
+	})
+	t.Run("close with error", func(t *testing.T) {
+		abort := errors.New("abort")
+		var uploadErr error
+		w := NewPipeWriter(func(r io.Reader) error {
+			_, uploadErr = io.Copy(ioutil.Discard, r)
+			return uploadErr
+		})
+		io.WriteString(w, "partial")
+		if err := w.CloseWithError(abort); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if uploadErr != abort {
+			t.Errorf("expected upload to read %v got %v", abort, uploadErr)
+		}
+	})
+	t.Run("upload fails early", func(t *testing.T) {
+		fail := errors.New("fail")
+		w := NewPipeWriter(func(r io.Reader) error { return fail })
+		if _, err := io.WriteString(w, "data"); err != fail {
+			t.Errorf("expected write error %v got %v", fail, err)
+		}
+		if err := w.Close(); err != fail {
+			t.Errorf("expected %v got %v", fail, err)
+		}
+	})
+}